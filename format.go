@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// runFormat formats the gunk files of the packages given as arguments, with
+// the same rules as the server.
+func runFormat(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("format", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gunkls format [flags] [packages]")
+		fs.PrintDefaults()
+	}
+	write := fs.Bool("w", false, "write the formatted files instead of printing them")
+	check := fs.Bool("check", false, "list the files whose formatting differs, and fail if there are any")
+	diff := fs.Bool("diff", false, "print the diffs of the files whose formatting differs")
+	var opts lsp.FormatOptions
+	fs.BoolVar(&opts.AlignTags, "align-tags", false, "align the fields of structs in columns, across blank lines and comments")
+	fs.BoolVar(&opts.GroupImports, "group-imports", false, "group imports into standard library, annotation, third-party and module packages")
+	fs.IntVar(&opts.WrapWidth, "wrap", 0, "wrap doc comments at the given column")
+	fs.BoolVar(&opts.ManualPB, "manual-pb", false, "don't number fields without a pb tag")
+	fs.Parse(args)
+	if *write && (*check || *diff) {
+		return fmt.Errorf("-w cannot be used with -check or -diff")
+	}
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	files, err := lsp.GunkFiles(dir, patterns)
+	if err != nil {
+		return err
+	}
+	var unformatted int
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		formatted, err := lsp.FormatFile(file, src, opts)
+		if err != nil {
+			return err
+		}
+		if !*write && !*check && !*diff {
+			os.Stdout.Write(formatted)
+			continue
+		}
+		if bytes.Equal(src, formatted) {
+			continue
+		}
+		unformatted++
+		name := file
+		if rel, err := filepath.Rel(dir, file); err == nil {
+			name = rel
+		}
+		if *diff {
+			if err := writeDiff(os.Stdout, filepath.ToSlash(name), src, formatted); err != nil {
+				return err
+			}
+			continue
+		}
+		if *check {
+			fmt.Println(name)
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, formatted, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if *check && unformatted > 0 {
+		return fmt.Errorf("%d files are not formatted", unformatted)
+	}
+	return nil
+}
+
+// writeDiff writes a unified diff from the source of a file to its formatted
+// source.
+func writeDiff(w io.Writer, name string, src, formatted []byte) error {
+	return difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+		A:        splitLines(src),
+		B:        splitLines(formatted),
+		FromFile: "a/" + name,
+		ToFile:   "b/" + name,
+		Context:  3,
+	})
+}
+
+// splitLines splits src into lines, keeping their line endings.
+func splitLines(src []byte) []string {
+	lines := strings.SplitAfter(string(src), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
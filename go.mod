@@ -3,11 +3,15 @@ module github.com/gunk/gunkls
 go 1.17
 
 require (
+	github.com/Microsoft/go-winio v0.5.2
+	github.com/fsnotify/fsnotify v1.5.4
 	github.com/gunk/gunk v0.11.3
 	github.com/kenshaw/snaker v0.2.0
+	github.com/pmezard/go-difflib v1.0.0
 	go.lsp.dev/jsonrpc2 v0.9.0
 	go.lsp.dev/protocol v0.11.2
 	go.lsp.dev/uri v0.3.0
+	go.uber.org/zap v1.17.0
 	golang.org/x/tools v0.1.9
 )
 
@@ -28,9 +32,8 @@ require (
 	go.lsp.dev/pkg v0.0.0-20210323044036-f7deec69b52e // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	go.uber.org/zap v1.17.0 // indirect
 	golang.org/x/mod v0.6.0-dev.0.20211013180041-c96bc1413d57 // indirect
-	golang.org/x/sys v0.0.0-20220227234510-4e6760a101f9 // indirect
+	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/genproto v0.0.0-20220202230416-2a053f022f0d // indirect
 	google.golang.org/protobuf v1.27.1 // indirect
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gunk/gunkls/lsp"
+	"github.com/gunk/gunkls/lsp/lint"
+	"go.lsp.dev/protocol"
+)
+
+// runLint reports the diagnostics and lint findings of the packages given as
+// arguments, and fails if any of them is an error.
+func runLint(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gunkls lint [flags] [packages]")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "human", "output format: human, json or sarif")
+	configFile := fs.String("config", "", `JSON file with the lint settings, as the "lint" setting of the server`)
+	fs.Parse(args)
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	write, ok := lintFormats[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	cfg := lint.DefaultConfig()
+	if *configFile != "" {
+		b, err := os.ReadFile(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return fmt.Errorf("invalid lint settings: %v", err)
+		}
+	}
+	cfg.Enabled = true
+	diags, err := lsp.Lint(ctx, dir, patterns, cfg)
+	if err != nil {
+		return err
+	}
+
+	var findings []finding
+	for file, fileDiags := range diags {
+		name := file
+		if rel, err := filepath.Rel(dir, file); err == nil {
+			name = rel
+		}
+		for _, d := range fileDiags {
+			findings = append(findings, finding{File: filepath.ToSlash(name), Diagnostic: d})
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Range.Start.Line != b.Range.Start.Line {
+			return a.Range.Start.Line < b.Range.Start.Line
+		}
+		return a.Range.Start.Character < b.Range.Start.Character
+	})
+	if err := write(os.Stdout, findings); err != nil {
+		return err
+	}
+	var errs int
+	for _, f := range findings {
+		if f.Severity == protocol.DiagnosticSeverityError {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d errors found", errs)
+	}
+	return nil
+}
+
+// finding is a diagnostic of a file, relative to the current directory.
+type finding struct {
+	File string `json:"file"`
+	protocol.Diagnostic
+}
+
+// lintFormats are the output formats of the lint command.
+var lintFormats = map[string]func(w io.Writer, findings []finding) error{
+	"human": writeHuman,
+	"json":  writeJSON,
+	"sarif": writeSARIF,
+}
+
+func writeHuman(w io.Writer, findings []finding) error {
+	for _, f := range findings {
+		_, err := fmt.Fprintf(w, "%s:%d:%d: %s: %s (%v)\n", f.File,
+			f.Range.Start.Line+1, f.Range.Start.Character+1,
+			severityName(f.Severity), f.Message, f.Code)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, findings []finding) error {
+	if findings == nil {
+		findings = []finding{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(findings)
+}
+
+// writeSARIF writes the findings as a SARIF 2.1.0 log, which code scanning
+// services such as GitHub's can show on pull requests.
+func writeSARIF(w io.Writer, findings []finding) error {
+	type object = map[string]interface{}
+	rules := []object{}
+	seen := make(map[string]bool)
+	results := []object{}
+	for _, f := range findings {
+		id := fmt.Sprint(f.Code)
+		if !seen[id] {
+			seen[id] = true
+			rules = append(rules, object{"id": id})
+		}
+		level := "warning"
+		switch f.Severity {
+		case protocol.DiagnosticSeverityError:
+			level = "error"
+		case protocol.DiagnosticSeverityInformation, protocol.DiagnosticSeverityHint:
+			level = "note"
+		}
+		results = append(results, object{
+			"ruleId":  id,
+			"level":   level,
+			"message": object{"text": f.Message},
+			"locations": []object{{
+				"physicalLocation": object{
+					"artifactLocation": object{
+						"uri":       f.File,
+						"uriBaseId": "%SRCROOT%",
+					},
+					// SARIF counts columns in UTF-16 code
+					// units by default, like LSP.
+					"region": object{
+						"startLine":   f.Range.Start.Line + 1,
+						"startColumn": f.Range.Start.Character + 1,
+						"endLine":     f.Range.End.Line + 1,
+						"endColumn":   f.Range.End.Character + 1,
+					},
+				},
+			}},
+		})
+	}
+	log := object{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []object{{
+			"tool": object{
+				"driver": object{
+					"name":           "gunkls",
+					"version":        version,
+					"informationUri": "https://github.com/gunk/gunkls",
+					"rules":          rules,
+				},
+			},
+			"results": results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(log)
+}
+
+func severityName(s protocol.DiagnosticSeverity) string {
+	switch s {
+	case protocol.DiagnosticSeverityError:
+		return "error"
+	case protocol.DiagnosticSeverityWarning:
+		return "warning"
+	case protocol.DiagnosticSeverityInformation:
+		return "info"
+	}
+	return "hint"
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// listen listens for clients on addr: a unix socket as unix:/path/to.sock, a
+// named pipe as pipe:name, or a TCP address otherwise.
+func listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		path := strings.TrimPrefix(addr, "unix:")
+		// Remove the socket left by a previous server that didn't shut
+		// down cleanly, but nothing else.
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	case strings.HasPrefix(addr, "pipe:"):
+		return listenPipe(strings.TrimPrefix(addr, "pipe:"))
+	case strings.HasPrefix(addr, "tcp:"):
+		return net.Listen("tcp", strings.TrimPrefix(addr, "tcp:"))
+	default:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %v", addr, err)
+		}
+		return net.Listen("tcp", addr)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// listenPipe fails, as named pipes only exist on Windows. Unix sockets are
+// used instead.
+func listenPipe(name string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on Windows, use a unix socket instead")
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// listenPipe listens on a named pipe. Names without a prefix are created
+// under \\.\pipe\.
+func listenPipe(name string) (net.Listener, error) {
+	if !strings.HasPrefix(name, `\\`) {
+		name = `\\.\pipe\` + name
+	}
+	return winio.ListenPipe(name, nil)
+}
@@ -0,0 +1,232 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// methodAST replies with the syntax tree of a file and its gunk tags, as seen
+// by the last analysis of its package, so that parsing issues can be reported
+// with what the parser saw.
+const methodAST = "gunkls/ast"
+
+type astParams struct {
+	TextDocument protocol.TextDocumentIdentifier `json:"textDocument"`
+	// Format is the format of the syntax tree: "sexp" for an
+	// s-expression, which is the default, or "json".
+	Format string `json:"format,omitempty"`
+}
+
+type astResult struct {
+	// AST is the syntax tree, as a string or an astNode.
+	AST  interface{} `json:"ast"`
+	Tags []astTag    `json:"tags"`
+}
+
+// astNode is a node of a syntax tree. Values are the values of its fields
+// that aren't nodes, such as names and literals, and Children its fields that
+// are nodes, in the order of the fields.
+type astNode struct {
+	Type     string            `json:"type"`
+	Range    protocol.Range    `json:"range"`
+	Values   map[string]string `json:"values,omitempty"`
+	Children []astField        `json:"children,omitempty"`
+}
+
+type astField struct {
+	Name  string     `json:"name"`
+	Nodes []*astNode `json:"nodes"`
+}
+
+// astTag is a gunk tag split from the documentation of a declaration.
+type astTag struct {
+	Node  string         `json:"node"`
+	Range protocol.Range `json:"range"`
+	Expr  string         `json:"expr"`
+	Type  string         `json:"type,omitempty"`
+	Value string         `json:"value,omitempty"`
+}
+
+// AST replies with the syntax tree and gunk tags of a file.
+func (l *LSP) AST(ctx context.Context, params astParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok {
+		reply(ctx, nil, fmt.Errorf("package of %s has not been analyzed yet", file))
+		return
+	}
+	var f *ast.File
+	for i, path := range pkg.GunkFiles {
+		if path == file && i < len(pkg.GunkSyntax) {
+			f = pkg.GunkSyntax[i]
+			break
+		}
+	}
+	if f == nil {
+		reply(ctx, nil, fmt.Errorf("%s has not been parsed", file))
+		return
+	}
+
+	res := astResult{Tags: []astTag{}}
+	tree := newASTNode(s.fset, f)
+	switch params.Format {
+	case "", "sexp":
+		var b strings.Builder
+		tree.writeSexp(&b, 0)
+		res.AST = b.String()
+	case "json":
+		res.AST = tree
+	default:
+		reply(ctx, nil, fmt.Errorf("unknown format %q", params.Format))
+		return
+	}
+	ast.Inspect(f, func(node ast.Node) bool {
+		for _, tag := range pkg.GunkTags[node] {
+			t := astTag{
+				Node:  nodeLabel(node),
+				Range: nodeRange(s.fset, node),
+				Expr:  types.ExprString(tag.Expr),
+			}
+			if tag.Type != nil {
+				t.Type = tag.Type.String()
+			}
+			if tag.Value != nil {
+				t.Value = tag.Value.ExactString()
+			}
+			res.Tags = append(res.Tags, t)
+		}
+		return true
+	})
+	reply(ctx, res, nil)
+}
+
+var (
+	nodeType  = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	posType   = reflect.TypeOf(token.NoPos)
+	tokenType = reflect.TypeOf(token.ILLEGAL)
+)
+
+// newASTNode converts a syntax tree to an astNode, using reflection so that
+// all node types are covered. Positions are converted to ranges, and the
+// resolved objects and scopes are left out.
+func newASTNode(fset *token.FileSet, node ast.Node) *astNode {
+	n := &astNode{
+		Type:  strings.TrimPrefix(reflect.TypeOf(node).String(), "*ast."),
+		Range: nodeRange(fset, node),
+	}
+	v := reflect.ValueOf(node).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		field := v.Field(i)
+		switch {
+		case field.Type() == posType:
+		case field.Type() == tokenType:
+			n.setValue(name, field.Interface().(token.Token).String())
+		case field.Kind() == reflect.String:
+			if field.String() != "" {
+				n.setValue(name, field.String())
+			}
+		case field.Kind() == reflect.Bool:
+			if field.Bool() {
+				n.setValue(name, "true")
+			}
+		case field.Type().Implements(nodeType):
+			if !field.IsNil() {
+				n.addChild(name, newASTNode(fset, field.Interface().(ast.Node)))
+			}
+		case field.Kind() == reflect.Slice && field.Type().Elem().Implements(nodeType):
+			if _, ok := node.(*ast.File); ok && name != "Decls" {
+				// Imports and Comments repeat nodes of Decls
+				// and the documentation.
+				continue
+			}
+			for j := 0; j < field.Len(); j++ {
+				if elem := field.Index(j); !elem.IsNil() {
+					n.addChild(name, newASTNode(fset, elem.Interface().(ast.Node)))
+				}
+			}
+		}
+	}
+	return n
+}
+
+func (n *astNode) setValue(name, value string) {
+	if n.Values == nil {
+		n.Values = make(map[string]string)
+	}
+	n.Values[name] = value
+}
+
+func (n *astNode) addChild(name string, child *astNode) {
+	if len(n.Children) == 0 || n.Children[len(n.Children)-1].Name != name {
+		n.Children = append(n.Children, astField{Name: name})
+	}
+	last := &n.Children[len(n.Children)-1]
+	last.Nodes = append(last.Nodes, child)
+}
+
+// writeSexp writes the node as an indented s-expression, such as
+// (Ident 3:6-3:9 Name="Foo"), with 1-based lines and columns.
+func (n *astNode) writeSexp(b *strings.Builder, depth int) {
+	fmt.Fprintf(b, "%s(%s %d:%d-%d:%d", strings.Repeat("  ", depth), n.Type,
+		n.Range.Start.Line+1, n.Range.Start.Character+1,
+		n.Range.End.Line+1, n.Range.End.Character+1)
+	names := make([]string, 0, len(n.Values))
+	for name := range n.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(b, " %s=%q", name, n.Values[name])
+	}
+	for _, field := range n.Children {
+		fmt.Fprintf(b, "\n%s:%s", strings.Repeat("  ", depth+1), field.Name)
+		for _, child := range field.Nodes {
+			b.WriteString("\n")
+			child.writeSexp(b, depth+2)
+		}
+	}
+	b.WriteString(")")
+}
+
+// nodeLabel describes a node that gunk tags may be attached to.
+func nodeLabel(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.File:
+		return "package " + node.Name.Name
+	case *ast.TypeSpec:
+		return "type " + node.Name.Name
+	case *ast.Field:
+		var names []string
+		for _, name := range node.Names {
+			names = append(names, name.Name)
+		}
+		return "field " + strings.Join(names, ", ")
+	case *ast.ValueSpec:
+		var names []string
+		for _, name := range node.Names {
+			names = append(names, name.Name)
+		}
+		return "value " + strings.Join(names, ", ")
+	}
+	return strings.TrimPrefix(reflect.TypeOf(node).String(), "*ast.")
+}
@@ -0,0 +1,236 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/lint"
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+func (l *LSP) CodeAction(ctx context.Context, params protocol.CodeActionParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok {
+		reply(ctx, nil, fmt.Errorf("package of %s has not been analyzed yet", file))
+		return
+	}
+	actions := make([]protocol.CodeAction, 0)
+	for _, d := range params.Context.Diagnostics {
+		if d.Code == "type error" {
+			actions = append(actions, l.declareActions(s, pkg, file, d)...)
+			continue
+		}
+		if d.Code != "messagename" || d.Data == nil {
+			continue
+		}
+		// The data has been sent back by the client, so it needs to be
+		// decoded again.
+		b, err := json.Marshal(d.Data)
+		if err != nil {
+			continue
+		}
+		var fix lint.RenameFix
+		if err := json.Unmarshal(b, &fix); err != nil || fix.From == "" {
+			continue
+		}
+		if pkg.Types == nil || pkg.Stale || pkg.Types.Scope().Lookup(fix.To) != nil {
+			// Renaming would conflict with an existing declaration,
+			// or edit outdated positions.
+			continue
+		}
+		edit := l.renameEdit(s, pkg.Types.Scope().Lookup(fix.From), fix.To)
+		if edit == nil {
+			continue
+		}
+		actions = append(actions, protocol.CodeAction{
+			Title:       fmt.Sprintf("Rename %s to %s", fix.From, fix.To),
+			Kind:        protocol.QuickFix,
+			Diagnostics: []protocol.Diagnostic{d},
+			IsPreferred: true,
+			Edit:        edit,
+		})
+	}
+	var src []byte
+	v.locked(func() {
+		src, err = v.loader.ReadFile(file)
+	})
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	free := func(name string) bool {
+		return pkg.Types == nil || pkg.Types.Scope().Lookup(name) == nil
+	}
+	if edits := extractMessage(file, src, params.Range, free); edits != nil {
+		actions = append(actions, protocol.CodeAction{
+			Title: "Extract fields into a new message",
+			Kind:  protocol.RefactorExtract,
+			Edit: &protocol.WorkspaceEdit{Changes: map[protocol.DocumentURI][]protocol.TextEdit{
+				params.TextDocument.URI: edits,
+			}},
+		})
+	}
+	if l.createFiles {
+		var edit *resourceEdit
+		v.locked(func() {
+			exists := func(path string) bool {
+				_, err := v.loader.ReadFile(path)
+				return err == nil
+			}
+			edit = l.splitFile(file, src, params.Range.Start, l.settings.Lint.ServiceSuffix, exists)
+		})
+		if edit != nil {
+			// The action can't be a protocol.CodeAction, whose edit
+			// can't create files.
+			all := make([]interface{}, 0, len(actions)+1)
+			for _, action := range actions {
+				all = append(all, action)
+			}
+			reply(ctx, append(all, resourceAction{Title: "Split file by service", Kind: protocol.Refactor, Edit: *edit}), nil)
+			return
+		}
+	}
+	reply(ctx, actions, nil)
+}
+
+// nodeRange converts the position of a node to an LSP range.
+func nodeRange(fset *token.FileSet, node ast.Node) protocol.Range {
+	return posRange(fset, node.Pos(), node.End())
+}
+
+// posRange converts a range of positions to an LSP range.
+func posRange(fset *token.FileSet, pos, end token.Pos) protocol.Range {
+	start := fset.Position(pos)
+	stop := fset.Position(end)
+	return protocol.Range{
+		Start: protocol.Position{
+			Line:      uint32(start.Line - 1),
+			Character: uint32(start.Column - 1),
+		},
+		End: protocol.Position{
+			Line:      uint32(stop.Line - 1),
+			Character: uint32(stop.Column - 1),
+		},
+	}
+}
+
+// renameEdit creates a workspace edit renaming the definition and all uses of
+// an object in the loaded packages.
+func (l *LSP) renameEdit(s *snapshot, obj types.Object, name string) *protocol.WorkspaceEdit {
+	if obj == nil {
+		return nil
+	}
+	changes := make(map[protocol.DocumentURI][]protocol.TextEdit)
+	// Packages spanning several directories are listed once for each.
+	seen := make(map[*loader.GunkPackage]bool)
+	for _, p := range s.pkgs {
+		if p.TypesInfo == nil || seen[p] {
+			continue
+		}
+		seen[p] = true
+		add := func(ident *ast.Ident) {
+			file := s.fset.Position(ident.Pos()).Filename
+			u := l.fileURI(file)
+			changes[u] = append(changes[u], protocol.TextEdit{
+				Range:   nodeRange(s.fset, ident),
+				NewText: name,
+			})
+		}
+		for ident, o := range p.TypesInfo.Defs {
+			if o == obj {
+				add(ident)
+			}
+		}
+		for ident, o := range p.TypesInfo.Uses {
+			if o == obj {
+				add(ident)
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return &protocol.WorkspaceEdit{Changes: changes}
+}
+
+// declareActions returns the quick fixes of an undeclared type, which declare
+// it at the end of the file as a message or, unless it is the request or the
+// response of a method, as an enum, with a placeholder doc comment.
+func (l *LSP) declareActions(s *snapshot, pkg *loader.GunkPackage, file string, d protocol.Diagnostic) []protocol.CodeAction {
+	name := strings.TrimPrefix(d.Message, "undefined: ")
+	if name == d.Message || !token.IsIdentifier(name) {
+		// Not undeclared, or from another package.
+		return nil
+	}
+	if pkg.Types == nil || pkg.Stale || pkg.Types.Scope().Lookup(name) != nil {
+		return nil
+	}
+	f := pkgFile(pkg, file)
+	if f == nil || len(f.Decls) == 0 {
+		return nil
+	}
+	ident, _ := identAt(s.fset, pkg, f, d.Range.Start)
+	if ident == nil || ident.Name != name {
+		// Such as in a gunk tag, where it isn't a type.
+		return nil
+	}
+	method := false
+	ast.Inspect(f, func(node ast.Node) bool {
+		if ft, ok := node.(*ast.FuncType); ok && ft.Pos() <= ident.Pos() && ident.End() <= ft.End() {
+			method = true
+		}
+		return !method
+	})
+	pos := posRange(s.fset, f.Decls[len(f.Decls)-1].End(), f.Decls[len(f.Decls)-1].End())
+	doc := "\n\n" + placeholderDoc(name) + "\n"
+	type declaration struct{ kind, text string }
+	decls := []declaration{
+		{"message", doc + "type " + name + " struct{}"},
+	}
+	if !method {
+		suffix := l.settings.Lint.EnumZeroSuffix
+		if suffix == "" {
+			suffix = "Unspecified"
+		}
+		decls = append(decls, declaration{
+			"enum", doc + "type " + name + " int\n\nconst (\n\t" + name + suffix + " " + name + " = iota\n)",
+		})
+	}
+	actions := make([]protocol.CodeAction, 0, len(decls))
+	for i, decl := range decls {
+		actions = append(actions, protocol.CodeAction{
+			Title:       fmt.Sprintf("Create %s %s", decl.kind, name),
+			Kind:        protocol.QuickFix,
+			Diagnostics: []protocol.Diagnostic{d},
+			IsPreferred: i == 0,
+			Edit: &protocol.WorkspaceEdit{Changes: map[protocol.DocumentURI][]protocol.TextEdit{
+				l.fileURI(file): {{Range: pos, NewText: decl.text}},
+			}},
+		})
+	}
+	return actions
+}
+
+// placeholderDoc returns the doc comment of a declaration added by a code
+// action, to be written by the user.
+func placeholderDoc(name string) string {
+	return fmt.Sprintf("// %s TODO: describe %s.", name, name)
+}
@@ -0,0 +1,57 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// ExecuteCommand runs a command of the server.
+func (l *LSP) ExecuteCommand(ctx context.Context, params protocol.ExecuteCommandParams, reply jsonrpc2.Replier) {
+	switch params.Command {
+	case commandDocs:
+		l.Docs(ctx, params, reply)
+	case commandGenerate:
+		l.Generate(ctx, params, reply)
+	case commandRenameProtoPackage:
+		l.RenameProtoPackage(ctx, params, reply)
+	case commandWriteHeapProfile:
+		var path string
+		if len(params.Arguments) > 0 {
+			path, _ = params.Arguments[0].(string)
+		}
+		path, err := writeHeapProfile(path)
+		if err != nil {
+			reply(ctx, nil, err)
+			return
+		}
+		l.msg(ctx, protocol.MessageTypeInfo, "Wrote heap profile to "+path)
+		reply(ctx, path, nil)
+	case commandWarmup:
+		l.Warmup(ctx, reply)
+	case commandDuplicateMessages:
+		l.DuplicateMessages(ctx, reply)
+	default:
+		reply(ctx, nil, fmt.Errorf("unknown command %q", params.Command))
+	}
+}
+
+// applyEdit asks the client to apply a workspace edit, and waits for it to be
+// applied. As the client may send requests before replying, it must be called
+// from a goroutine rather than while handling a request.
+func (l *LSP) applyEdit(ctx context.Context, label string, edit protocol.WorkspaceEdit) error {
+	var res protocol.ApplyWorkspaceEditResponse
+	_, err := l.conn.Call(ctx, protocol.MethodWorkspaceApplyEdit, &protocol.ApplyWorkspaceEditParams{Label: label, Edit: edit}, &res)
+	if err != nil {
+		return err
+	}
+	if !res.Applied {
+		if res.FailureReason != "" {
+			return fmt.Errorf("%s was not applied: %s", label, res.FailureReason)
+		}
+		return fmt.Errorf("%s was not applied", label)
+	}
+	return nil
+}
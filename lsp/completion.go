@@ -0,0 +1,419 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// Completion completes the values of the enum fields of the annotations in
+// gunk tags, such as the schemes of an OpenAPI option, with the constants of
+// the enum, and the types of fields with the messages and enums of the
+// symbol index.
+func (l *LSP) Completion(ctx context.Context, params protocol.CompletionParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok {
+		reply(ctx, nil, nil)
+		return
+	}
+	f := pkgFile(pkg, file)
+	if f == nil {
+		reply(ctx, nil, nil)
+		return
+	}
+	// The tag is read from the current contents, which may not have been
+	// analyzed yet.
+	var src []byte
+	v.locked(func() {
+		src, err = v.loader.ReadFile(file)
+	})
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	if start, ok := fieldTypeBefore(string(src), params.Position); ok {
+		qualifiers := importQualifiers(v, s, f)
+		items := typeItems(s, pkg, qualifiers, protocol.Range{Start: start, End: params.Position})
+		reply(ctx, &protocol.CompletionList{Items: items}, nil)
+		return
+	}
+	tag, ok := tagBefore(string(src), params.Position)
+	if !ok || pkg.Types == nil {
+		reply(ctx, nil, nil)
+		return
+	}
+	typ, start, ok := tag.enumValue(s.fset, pkg, f)
+	if !ok {
+		reply(ctx, nil, nil)
+		return
+	}
+	items := enumItems(pkg, f, typ, protocol.Range{Start: start, End: params.Position})
+	reply(ctx, &protocol.CompletionList{Items: items}, nil)
+}
+
+// gunkTag is the text of a gunk tag up to a position, with its first line
+// starting at the column of the tag in the file.
+type gunkTag struct {
+	text string
+	// line is the line of the tag, and columns are the columns at
+	// which the comment text starts on each of its lines.
+	line    uint32
+	columns []int
+}
+
+// tagBefore returns the gunk tag of a comment, up to pos. A gunk tag starts
+// with "+gunk" and goes on until the end of the comment, in line comments.
+func tagBefore(src string, pos protocol.Position) (*gunkTag, bool) {
+	lines := strings.Split(src, "\n")
+	if int(pos.Line) >= len(lines) || int(pos.Character) > len(lines[pos.Line]) {
+		return nil, false
+	}
+	var texts []string
+	var columns []int
+	for i := int(pos.Line); i >= 0; i-- {
+		line := lines[i]
+		if i == int(pos.Line) {
+			line = line[:pos.Character]
+		}
+		trimmed := strings.TrimLeft(line, " \t")
+		if !strings.HasPrefix(trimmed, "//") {
+			return nil, false
+		}
+		text := strings.TrimPrefix(trimmed[2:], " ")
+		texts = append([]string{text}, texts...)
+		columns = append([]int{len(line) - len(text)}, columns...)
+		if strings.HasPrefix(text, "+gunk ") {
+			// Keep the columns of the tag expression.
+			texts[0] = strings.Replace(text, "+gunk", "     ", 1)
+			return &gunkTag{text: strings.Join(texts, "\n"), line: uint32(i), columns: columns}, true
+		}
+	}
+	return nil, false
+}
+
+// position returns the position in the file of an offset in the tag text.
+func (t *gunkTag) position(offset int) protocol.Position {
+	before := t.text[:offset]
+	i := strings.Count(before, "\n")
+	col := offset - (strings.LastIndex(before, "\n") + 1)
+	return protocol.Position{Line: t.line + uint32(i), Character: uint32(t.columns[i] + col)}
+}
+
+// tagToken is a token of a gunk tag.
+type tagToken struct {
+	tok    token.Token
+	offset int
+	lit    string
+}
+
+// enumValue returns the enum type of the value being written at the end of
+// the tag, either a field of a struct literal or an element of a slice
+// literal, and the position where the value starts. The types of the
+// literals are evaluated in the scope of f.
+func (t *gunkTag) enumValue(fset *token.FileSet, pkg *loader.GunkPackage, f *ast.File) (*types.Named, protocol.Position, bool) {
+	tfset := token.NewFileSet()
+	tfile := tfset.AddFile("", -1, len(t.text))
+	var sc scanner.Scanner
+	sc.Init(tfile, []byte(t.text), nil, 0)
+	var toks []tagToken
+	// literals are the types of the composite literals still open.
+	var literals []string
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			// Inserted at the end of lines.
+			continue
+		}
+		offset := tfile.Offset(pos)
+		switch tok {
+		case token.LBRACE:
+			// The type of the literal is the type expression
+			// before the brace, if not elided.
+			i := len(toks)
+			for i > 0 && typeToken(toks[i-1].tok) {
+				i--
+			}
+			typ := ""
+			if i < len(toks) {
+				typ = t.text[toks[i].offset:offset]
+			}
+			literals = append(literals, typ)
+		case token.RBRACE:
+			if len(literals) > 0 {
+				literals = literals[:len(literals)-1]
+			}
+		}
+		toks = append(toks, tagToken{tok: tok, offset: offset, lit: lit})
+	}
+	if len(literals) == 0 || literals[len(literals)-1] == "" {
+		return nil, protocol.Position{}, false
+	}
+	// The value being written, such as "openapiv2.HT", is replaced.
+	start := len(t.text)
+	i := len(toks)
+	for i > 0 && (toks[i-1].tok == token.IDENT || toks[i-1].tok == token.PERIOD) {
+		i--
+		start = toks[i].offset
+	}
+	if i < len(toks) {
+		last := toks[len(toks)-1]
+		end := last.offset + len(last.lit)
+		if last.tok == token.PERIOD {
+			end++
+		}
+		if end < len(t.text) {
+			// The cursor is past the value, such as after a
+			// space.
+			return nil, protocol.Position{}, false
+		}
+	}
+
+	tv, err := types.Eval(fset, pkg.Types, f.Name.Pos(), literals[len(literals)-1])
+	if err != nil || !tv.IsType() {
+		return nil, protocol.Position{}, false
+	}
+	var typ types.Type
+	switch {
+	case i >= 2 && toks[i-1].tok == token.COLON && toks[i-2].tok == token.IDENT:
+		st, ok := tv.Type.Underlying().(*types.Struct)
+		if !ok {
+			return nil, protocol.Position{}, false
+		}
+		for j := 0; j < st.NumFields(); j++ {
+			if st.Field(j).Name() == toks[i-2].lit {
+				typ = st.Field(j).Type()
+			}
+		}
+	case i >= 1 && (toks[i-1].tok == token.LBRACE || toks[i-1].tok == token.COMMA):
+		switch u := tv.Type.Underlying().(type) {
+		case *types.Slice:
+			typ = u.Elem()
+		case *types.Array:
+			typ = u.Elem()
+		}
+	}
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return nil, protocol.Position{}, false
+	}
+	if basic, ok := named.Underlying().(*types.Basic); !ok || basic.Info()&types.IsInteger == 0 {
+		return nil, protocol.Position{}, false
+	}
+	return named, t.position(start), true
+}
+
+// typeToken reports whether a token can be part of the type of a composite
+// literal, such as "[]openapiv2.Scheme".
+func typeToken(tok token.Token) bool {
+	switch tok {
+	case token.IDENT, token.PERIOD, token.LBRACK, token.RBRACK, token.INT, token.MUL:
+		return true
+	}
+	return false
+}
+
+// enumItems returns the completion items of the constants of an enum,
+// replacing rng, in the order of their values. Constants of other packages
+// are qualified with the name they are imported with in f.
+func enumItems(pkg *loader.GunkPackage, f *ast.File, enum *types.Named, rng protocol.Range) []protocol.CompletionItem {
+	epkg := enum.Obj().Pkg()
+	qualifier := ""
+	if epkg != pkg.Types {
+		qualifier = epkg.Name()
+		for _, spec := range f.Imports {
+			if path, _ := strconv.Unquote(spec.Path.Value); spec.Name != nil && path == epkg.Path() {
+				qualifier = spec.Name.Name
+			}
+		}
+		qualifier += "."
+	}
+	// The docs of the constants are in the syntax of their package.
+	var syntax []*ast.File
+	var info *types.Info
+	if epkg == pkg.Types {
+		syntax, info = pkg.GunkSyntax, pkg.TypesInfo
+	} else if imp := pkg.Imports[epkg.Path()]; imp != nil {
+		syntax, info = imp.GunkSyntax, imp.TypesInfo
+	}
+	docs := make(map[types.Object]string)
+	for _, file := range syntax {
+		for _, decl := range file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST || info == nil {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				text := docText(vs.Doc)
+				if text == "" {
+					text = docText(vs.Comment)
+				}
+				for _, name := range vs.Names {
+					if obj := info.Defs[name]; obj != nil {
+						docs[obj] = text
+					}
+				}
+			}
+		}
+	}
+
+	var consts []*types.Const
+	scope := epkg.Scope()
+	for _, name := range scope.Names() {
+		if c, ok := scope.Lookup(name).(*types.Const); ok && (epkg == pkg.Types || c.Exported()) && types.Identical(c.Type(), enum) {
+			consts = append(consts, c)
+		}
+	}
+	sort.SliceStable(consts, func(i, j int) bool {
+		return constant.Compare(consts[i].Val(), token.LSS, consts[j].Val())
+	})
+	items := make([]protocol.CompletionItem, 0, len(consts))
+	for i, c := range consts {
+		name := qualifier + c.Name()
+		item := protocol.CompletionItem{
+			Label:    name,
+			Kind:     protocol.CompletionItemKindEnumMember,
+			Detail:   fmt.Sprintf("%s = %s", types.TypeString(enum, types.RelativeTo(pkg.Types)), c.Val().ExactString()),
+			SortText: fmt.Sprintf("%05d", i),
+			TextEdit: &protocol.TextEdit{Range: rng, NewText: name},
+		}
+		if doc := docs[c]; doc != "" {
+			item.Documentation = protocol.MarkupContent{Kind: protocol.Markdown, Value: doc}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// fieldType matches a line up to the type of a field being written, such as
+// "\tOwner []users.Us". The type is the last group.
+var fieldType = regexp.MustCompile(`^\s*([A-Za-z_]\w*)\s+(?:\[\]|\*|map\[\w+\])*((?:[A-Za-z_]\w*\.)?\w*)$`)
+
+// fieldTypeBefore reports whether pos is at the end of the type of a field,
+// and returns the position where the type starts.
+func fieldTypeBefore(src string, pos protocol.Position) (protocol.Position, bool) {
+	lines := strings.Split(src, "\n")
+	if int(pos.Line) >= len(lines) || int(pos.Character) > len(lines[pos.Line]) {
+		return protocol.Position{}, false
+	}
+	m := fieldType.FindStringSubmatch(lines[pos.Line][:pos.Character])
+	if m == nil || token.IsKeyword(m[1]) {
+		// Declarations such as "type Foo" name a new type.
+		return protocol.Position{}, false
+	}
+	return protocol.Position{Line: pos.Line, Character: pos.Character - uint32(len(m[2]))}, true
+}
+
+// importQualifiers returns the qualifiers of the packages imported by f, by
+// the directories of the symbol index they are in. The packages are found
+// from their import paths rather than from the type information, as the
+// package usually doesn't type check while a type is being written.
+func importQualifiers(v *view, s *snapshot, f *ast.File) map[string]string {
+	imports := make(map[string]*ast.ImportSpec)
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+			imports[path] = spec
+		}
+	}
+	qualifiers := make(map[string]string)
+	checked := make(map[string]bool)
+	v.locked(func() {
+		for file := range s.symbols {
+			dir := filepath.Dir(file)
+			if checked[dir] {
+				continue
+			}
+			checked[dir] = true
+			spec := imports[v.loader.ImportPath(dir)]
+			if spec == nil {
+				continue
+			}
+			name := ""
+			if spec.Name != nil {
+				name = spec.Name.Name
+			} else if src, err := v.loader.ReadFile(file); err == nil {
+				if pf, err := parser.ParseFile(token.NewFileSet(), file, src, parser.PackageClauseOnly); err == nil {
+					name = pf.Name.Name
+				}
+			}
+			if name != "" && name != "_" && name != "." {
+				qualifiers[dir] = name + "."
+			}
+		}
+	})
+	return qualifiers
+}
+
+// typeItems returns the completion items of the messages and enums that can
+// be used as field types in the files of pkg, replacing rng: the ones of pkg,
+// and the exported ones of the imported packages, with their qualifiers by
+// directory. Types of packages that aren't imported yet aren't offered.
+func typeItems(s *snapshot, pkg *loader.GunkPackage, qualifiers map[string]string, rng protocol.Range) []protocol.CompletionItem {
+	seen := make(map[string]bool)
+	var items []protocol.CompletionItem
+	for file, syms := range s.symbols {
+		dir := filepath.Dir(file)
+		qualifier, ok := qualifiers[dir]
+		if !ok && !pkg.HasDir(dir) {
+			continue
+		}
+		for _, sym := range syms {
+			kind := protocol.CompletionItemKindStruct
+			switch {
+			case strings.Contains(sym.Name, "."):
+				// Fields, values and methods.
+				continue
+			case sym.Kind == protocol.SymbolKindEnum:
+				kind = protocol.CompletionItemKindEnum
+			case sym.Kind != protocol.SymbolKindStruct:
+				continue
+			}
+			name := qualifier + sym.Name
+			if seen[name] || qualifier != "" && !token.IsExported(sym.Name) {
+				continue
+			}
+			seen[name] = true
+			item := protocol.CompletionItem{
+				Label:    name,
+				Kind:     kind,
+				TextEdit: &protocol.TextEdit{Range: rng, NewText: name},
+			}
+			if sym.Deprecated {
+				item.Tags = []protocol.CompletionItemTag{protocol.CompletionItemTagDeprecated}
+			}
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
@@ -0,0 +1,374 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// commandDocs writes the API reference of the package of the file given as its
+// first argument. The second argument is the format, "markdown" by default or
+// "html".
+const commandDocs = "gunkls.docs"
+
+// apiDoc is the API reference of a package.
+type apiDoc struct {
+	Name     string
+	Path     string
+	Doc      string
+	Services []docService
+	Messages []docMessage
+	Enums    []docEnum
+}
+
+type docService struct {
+	Name    string
+	Doc     string
+	Methods []docMethod
+}
+
+type docMethod struct {
+	Name     string
+	Doc      string
+	Request  string
+	Response string
+}
+
+type docMessage struct {
+	Name   string
+	Doc    string
+	Fields []docField
+}
+
+type docField struct {
+	Name   string
+	Type   string
+	Number string
+	JSON   string
+	Doc    string
+}
+
+type docEnum struct {
+	Name   string
+	Doc    string
+	Values []docValue
+}
+
+type docValue struct {
+	Name   string
+	Number string
+	Doc    string
+}
+
+// Docs writes the API reference of a package to the docs directory of the
+// settings, and replies with the path of the written file.
+func (l *LSP) Docs(ctx context.Context, params protocol.ExecuteCommandParams, reply jsonrpc2.Replier) {
+	var u, format string
+	if len(params.Arguments) > 0 {
+		u, _ = params.Arguments[0].(string)
+	}
+	if len(params.Arguments) > 1 {
+		format, _ = params.Arguments[1].(string)
+	}
+	if u == "" {
+		reply(ctx, nil, fmt.Errorf("%s expects the URI of a gunk file", commandDocs))
+		return
+	}
+	file, err := l.filePath(protocol.DocumentURI(u))
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	if isRemote(file) {
+		reply(ctx, nil, fmt.Errorf("cannot write docs for %s: not on the local file system", u))
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok {
+		reply(ctx, nil, fmt.Errorf("package of %s has not been analyzed yet", file))
+		return
+	}
+	if len(pkg.Errors) > 0 || pkg.Stale {
+		reply(ctx, nil, fmt.Errorf("package %s has errors", pkg.PkgPath))
+		return
+	}
+	path, err := writeDocs(newAPIDoc(pkg), l.settings.DocsDir, pkg.Dir, format)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	l.msg(ctx, protocol.MessageTypeInfo, "Wrote API reference to "+path)
+	reply(ctx, path, nil)
+}
+
+// writeDocs writes the API reference of a package to dir, relative to the
+// package directory if not absolute, and returns the path of the file.
+func writeDocs(doc *apiDoc, dir, pkgDir, format string) (string, error) {
+	var write func(io.Writer, interface{}) error
+	var ext string
+	switch format {
+	case "", "markdown":
+		write, ext = markdownDocs.Execute, ".md"
+	case "html":
+		write, ext = htmlDocs.Execute, ".html"
+	default:
+		return "", fmt.Errorf("unknown docs format %q", format)
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(pkgDir, dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, doc.Name+ext)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := write(f, doc); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// newAPIDoc collects the services, messages and enums of a package, in the
+// order of their declarations.
+func newAPIDoc(pkg *loader.GunkPackage) *apiDoc {
+	doc := &apiDoc{Name: pkg.Name, Path: pkg.PkgPath}
+	qualifier := types.RelativeTo(pkg.Types)
+	enums := make(map[types.Object]int)
+	for _, f := range pkg.GunkSyntax {
+		if f.Doc != nil && doc.Doc == "" {
+			doc.Doc = docText(f.Doc)
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				text := docText(ts.Doc)
+				if text == "" && len(gd.Specs) == 1 {
+					text = docText(gd.Doc)
+				}
+				switch t := ts.Type.(type) {
+				case *ast.InterfaceType:
+					doc.Services = append(doc.Services, newDocService(ts.Name.Name, text, t, pkg, qualifier))
+				case *ast.StructType:
+					doc.Messages = append(doc.Messages, newDocMessage(ts.Name.Name, text, t, pkg, qualifier))
+				default:
+					obj := pkg.TypesInfo.Defs[ts.Name]
+					if obj == nil {
+						continue
+					}
+					if basic, ok := obj.Type().Underlying().(*types.Basic); ok && basic.Info()&types.IsInteger != 0 {
+						enums[obj] = len(doc.Enums)
+						doc.Enums = append(doc.Enums, docEnum{Name: ts.Name.Name, Doc: text})
+					}
+				}
+			}
+		}
+	}
+	// Enum values may be declared in any file of the package.
+	for _, f := range pkg.GunkSyntax {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for _, name := range vs.Names {
+					c, ok := pkg.TypesInfo.Defs[name].(*types.Const)
+					if !ok {
+						continue
+					}
+					named, ok := c.Type().(*types.Named)
+					if !ok {
+						continue
+					}
+					i, ok := enums[named.Obj()]
+					if !ok {
+						continue
+					}
+					text := docText(vs.Doc)
+					if text == "" {
+						text = docText(vs.Comment)
+					}
+					doc.Enums[i].Values = append(doc.Enums[i].Values, docValue{
+						Name:   name.Name,
+						Number: c.Val().ExactString(),
+						Doc:    text,
+					})
+				}
+			}
+		}
+	}
+	return doc
+}
+
+func newDocService(name, text string, it *ast.InterfaceType, pkg *loader.GunkPackage, qualifier types.Qualifier) docService {
+	service := docService{Name: name, Doc: text}
+	for _, m := range it.Methods.List {
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok || len(m.Names) != 1 {
+			continue
+		}
+		method := docMethod{Name: m.Names[0].Name, Doc: docText(m.Doc)}
+		if ft.Params != nil && len(ft.Params.List) > 0 {
+			method.Request = types.TypeString(pkg.TypesInfo.TypeOf(ft.Params.List[0].Type), qualifier)
+		}
+		if ft.Results != nil && len(ft.Results.List) > 0 {
+			method.Response = types.TypeString(pkg.TypesInfo.TypeOf(ft.Results.List[0].Type), qualifier)
+		}
+		service.Methods = append(service.Methods, method)
+	}
+	return service
+}
+
+func newDocMessage(name, text string, st *ast.StructType, pkg *loader.GunkPackage, qualifier types.Qualifier) docMessage {
+	msg := docMessage{Name: name, Doc: text}
+	for _, field := range st.Fields.List {
+		if len(field.Names) != 1 {
+			continue
+		}
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			raw, _ := strconv.Unquote(field.Tag.Value)
+			tag = reflect.StructTag(raw)
+		}
+		f := docField{
+			Name:   field.Names[0].Name,
+			Type:   types.TypeString(pkg.TypesInfo.TypeOf(field.Type), qualifier),
+			Number: tag.Get("pb"),
+			// gunk uses the json tag as the JSON name of the
+			// field, and protoc the field name without one.
+			JSON: tag.Get("json"),
+			Doc:  docText(field.Doc),
+		}
+		if f.JSON == "" {
+			f.JSON = f.Name
+		}
+		if f.Doc == "" {
+			f.Doc = docText(field.Comment)
+		}
+		msg.Fields = append(msg.Fields, f)
+	}
+	return msg
+}
+
+// docText returns the text of a doc comment, without its gunk tags.
+func docText(group *ast.CommentGroup) string {
+	if group == nil {
+		return ""
+	}
+	var lines []string
+	for _, line := range strings.Split(group.Text(), "\n") {
+		if strings.HasPrefix(line, "+gunk ") {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// cell escapes text for a cell of a Markdown table.
+func cell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+var markdownDocs = template.Must(template.New("markdown").Funcs(template.FuncMap{"cell": cell}).Parse(`# {{.Name}}
+
+` + "`{{.Path}}`" + `
+{{with .Doc}}
+{{.}}
+{{end}}{{with .Services}}
+## Services
+{{range .}}
+### {{.Name}}
+{{with .Doc}}
+{{.}}
+{{end}}{{range .Methods}}
+#### {{.Name}}
+
+` + "`{{.Name}}({{.Request}}) {{.Response}}`" + `
+{{with .Doc}}
+{{.}}
+{{end}}{{end}}{{end}}{{end}}{{with .Messages}}
+## Messages
+{{range .}}
+### {{.Name}}
+{{with .Doc}}
+{{.}}
+{{end}}{{with .Fields}}
+| Field | Type | Number | JSON name | Description |
+| --- | --- | --- | --- | --- |
+{{range .}}| {{.Name}} | ` + "`{{cell .Type}}`" + ` | {{.Number}} | {{cell .JSON}} | {{cell .Doc}} |
+{{end}}{{end}}{{end}}{{end}}{{with .Enums}}
+## Enums
+{{range .}}
+### {{.Name}}
+{{with .Doc}}
+{{.}}
+{{end}}{{with .Values}}
+| Value | Number | Description |
+| --- | --- | --- |
+{{range .}}| {{.Name}} | {{.Number}} | {{cell .Doc}} |
+{{end}}{{end}}{{end}}{{end}}`))
+
+var htmlDocs = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p><code>{{.Path}}</code></p>
+{{with .Doc}}<p>{{.}}</p>
+{{end}}{{with .Services}}<h2>Services</h2>
+{{range .}}<h3 id="{{.Name}}">{{.Name}}</h3>
+{{with .Doc}}<p>{{.}}</p>
+{{end}}{{range .Methods}}<h4>{{.Name}}</h4>
+<p><code>{{.Name}}({{.Request}}) {{.Response}}</code></p>
+{{with .Doc}}<p>{{.}}</p>
+{{end}}{{end}}{{end}}{{end}}{{with .Messages}}<h2>Messages</h2>
+{{range .}}<h3 id="{{.Name}}">{{.Name}}</h3>
+{{with .Doc}}<p>{{.}}</p>
+{{end}}{{with .Fields}}<table>
+<tr><th>Field</th><th>Type</th><th>Number</th><th>JSON name</th><th>Description</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td><code>{{.Type}}</code></td><td>{{.Number}}</td><td>{{.JSON}}</td><td>{{.Doc}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{end}}{{with .Enums}}<h2>Enums</h2>
+{{range .}}<h3 id="{{.Name}}">{{.Name}}</h3>
+{{with .Doc}}<p>{{.}}</p>
+{{end}}{{with .Values}}<table>
+<tr><th>Value</th><th>Number</th><th>Description</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Number}}</td><td>{{.Doc}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{end}}</body>
+</html>
+`))
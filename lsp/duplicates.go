@@ -0,0 +1,247 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// commandDuplicateMessages finds the messages of the workspace with the same
+// fields, so that duplicated definitions can be consolidated.
+const commandDuplicateMessages = "gunkls.duplicateMessages"
+
+// message is a message declared in a gunk file, with the key of its fields.
+type message struct {
+	name string
+	pkg  string
+	file string
+	loc  protocol.Location
+	// fields are the numbers, names and types of the fields, sorted by
+	// number.
+	fields string
+}
+
+// duplicates are the hints of the last run of commandDuplicateMessages, which
+// are merged into the diagnostics of their files so that publishing them
+// again doesn't drop the hints.
+type duplicates struct {
+	mu sync.Mutex
+	// hints are the hints by file. The hints of a file are dropped once
+	// it is edited, as their ranges are outdated.
+	hints map[string][]protocol.Diagnostic
+}
+
+// set replaces the hints, and returns the files whose hints changed.
+func (d *duplicates) set(hints map[string][]protocol.Diagnostic) map[string]bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	changed := make(map[string]bool)
+	for file := range d.hints {
+		changed[file] = true
+	}
+	for file := range hints {
+		changed[file] = true
+	}
+	d.hints = hints
+	return changed
+}
+
+// file returns a copy of the hints of a file.
+func (d *duplicates) file(path string) []protocol.Diagnostic {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]protocol.Diagnostic(nil), d.hints[path]...)
+}
+
+// forget drops the hints of a file.
+func (d *duplicates) forget(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.hints, path)
+}
+
+// DuplicateMessages parses the gunk files of all views in the background, and
+// publishes hints on the messages whose fields have the same numbers, names
+// and types as the fields of other messages, along with the other diagnostics
+// of their files. The hints refer to the other messages, and replace the ones
+// of the previous run. It replies with the number of groups of identical
+// messages.
+func (l *LSP) DuplicateMessages(ctx context.Context, reply jsonrpc2.Replier) {
+	views := l.views
+	l.sched.schedule(background, func() {
+		var messages []message
+		seen := make(map[string]bool)
+		for _, v := range views {
+			v.locked(func() {
+				for _, pkg := range v.pkgs {
+					for _, file := range pkg.GunkFiles {
+						if seen[file] {
+							continue
+						}
+						seen[file] = true
+						messages = append(messages, l.fileMessages(v.loader, pkg, file)...)
+					}
+				}
+			})
+		}
+		byFields := make(map[string][]message)
+		for _, m := range messages {
+			if m.fields != "" {
+				byFields[m.fields] = append(byFields[m.fields], m)
+			}
+		}
+		hints := make(map[string][]protocol.Diagnostic)
+		groups := 0
+		for _, same := range byFields {
+			if len(same) < 2 {
+				continue
+			}
+			groups++
+			for _, m := range same {
+				var names []string
+				var related []protocol.DiagnosticRelatedInformation
+				for _, other := range same {
+					if other == m {
+						continue
+					}
+					names = append(names, other.pkg+"."+other.name)
+					related = append(related, protocol.DiagnosticRelatedInformation{
+						Location: other.loc,
+						Message:  "identical message " + other.name,
+					})
+				}
+				sort.Strings(names)
+				hints[m.file] = append(hints[m.file], protocol.Diagnostic{
+					Range:              m.loc.Range,
+					Severity:           protocol.DiagnosticSeverityHint,
+					Code:               "duplicatemessage",
+					Source:             loader.DiagnosticSource,
+					Message:            fmt.Sprintf("message %s has the same fields as %s", m.name, strings.Join(names, ", ")),
+					RelatedInformation: related,
+				})
+			}
+		}
+		// Analyze the packages of the files with new or outdated hints
+		// again, so that the hints are published with their diagnostics.
+		changed := l.duplicates.set(hints)
+		for _, v := range views {
+			v.locked(func() {
+				for _, pkg := range v.pkgs {
+					for _, file := range pkg.GunkFiles {
+						if changed[file] {
+							pkg.State = loader.Dirty
+							break
+						}
+					}
+				}
+			})
+		}
+		l.log(ctx, fmt.Sprintf("Found %d groups of identical messages", groups))
+		reply(ctx, groups, nil)
+		l.doDiagnostics(ctx)
+	})
+}
+
+// fileMessages returns the messages declared in a gunk file of a package.
+// Files that can't be parsed have none. The view of the loader must be
+// locked.
+func (l *LSP) fileMessages(ldr *loader.Loader, pkg *loader.GunkPackage, file string) []message {
+	src, err := ldr.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = p
+	}
+	var messages []message
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			var fields []string
+			for _, field := range st.Fields.List {
+				var tag string
+				if field.Tag != nil {
+					tag, _ = strconv.Unquote(field.Tag.Value)
+				}
+				number, _ := strconv.Atoi(reflect.StructTag(tag).Get("pb"))
+				for _, name := range field.Names {
+					fields = append(fields, fmt.Sprintf("%08d %s %s", number, name.Name, typeKey(field.Type, pkg.PkgPath, imports)))
+				}
+			}
+			sort.Strings(fields)
+			messages = append(messages, message{
+				name: ts.Name.Name,
+				pkg:  pkg.PkgPath,
+				file: file,
+				loc: protocol.Location{
+					URI:   l.fileURI(file),
+					Range: nodeRange(fset, ts.Name),
+				},
+				fields: strings.Join(fields, "; "),
+			})
+		}
+	}
+	return messages
+}
+
+// typeKey returns a type expression of a file of the package at pkgPath with
+// the types qualified by the path of their package, so that the same types
+// have the same key in all packages. imports are the paths of the packages
+// imported by the file, by name.
+func typeKey(expr ast.Expr, pkgPath string, imports map[string]string) string {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if types.Universe.Lookup(expr.Name) != nil {
+			return expr.Name
+		}
+		return pkgPath + "." + expr.Name
+	case *ast.SelectorExpr:
+		if x, ok := expr.X.(*ast.Ident); ok && imports[x.Name] != "" {
+			return imports[x.Name] + "." + expr.Sel.Name
+		}
+	case *ast.StarExpr:
+		return "*" + typeKey(expr.X, pkgPath, imports)
+	case *ast.ArrayType:
+		if expr.Len == nil {
+			return "[]" + typeKey(expr.Elt, pkgPath, imports)
+		}
+	case *ast.MapType:
+		return "map[" + typeKey(expr.Key, pkgPath, imports) + "]" + typeKey(expr.Value, pkgPath, imports)
+	}
+	return types.ExprString(expr)
+}
@@ -0,0 +1,91 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+const (
+	// evictInterval is how often the heap is checked against the memory
+	// budget.
+	evictInterval = time.Minute
+	// evictIdle is how long a package must not have been used to be
+	// evicted.
+	evictIdle = 5 * time.Minute
+)
+
+// evictLoop periodically schedules evicting unused packages and compacting
+// the file sets, until ctx is done.
+func (l *LSP) evictLoop(ctx context.Context) {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.sched.schedule(background, func() {
+				l.evict(ctx)
+			})
+			l.sched.schedule(background, func() {
+				l.compact(ctx)
+			})
+		}
+	}
+}
+
+// evict drops the syntax trees and type information of the packages without
+// open files that haven't been used recently, if the heap is larger than the
+// memory budget.
+func (l *LSP) evict(ctx context.Context) {
+	l.mu.RLock()
+	views := append([]*view(nil), l.views...)
+	budget := l.settings.MemoryBudget
+	l.mu.RUnlock()
+	if budget <= 0 {
+		return
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc <= uint64(budget)<<20 {
+		return
+	}
+	var evicted int
+	for _, v := range views {
+		v.locked(func() {
+			pkgs := v.loader.Evict(evictIdle)
+			if len(pkgs) > 0 {
+				v.publish()
+			}
+			evicted += len(pkgs)
+		})
+	}
+	if evicted > 0 {
+		l.log(ctx, fmt.Sprintf("Heap of %d MB is over the memory budget, evicted %d packages", stats.HeapAlloc>>20, evicted))
+	}
+}
+
+// compact replaces the file sets of the views that mostly hold outdated
+// files, and analyzes their tracked packages again.
+func (l *LSP) compact(ctx context.Context) {
+	l.mu.RLock()
+	views := append([]*view(nil), l.views...)
+	l.mu.RUnlock()
+	var compacted bool
+	for _, v := range views {
+		v.locked(func() {
+			if v.loader.CompactFileSet(v.pkgs) {
+				// The analyzed packages use positions of the
+				// old file set, so they can't be kept until
+				// analyzed again.
+				v.snap.Store(&snapshot{fset: v.loader.Fset, symbols: v.loader.Symbols()})
+				compacted = true
+			}
+		})
+	}
+	if compacted {
+		l.doDiagnostics(ctx)
+	}
+}
@@ -0,0 +1,145 @@
+package lsp
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.lsp.dev/protocol"
+)
+
+// extractMessage returns the edits moving the fields of a message selected by
+// rng into a new message declared after it, which the message then refers to
+// with a single field in place of the first moved one. The moved fields are
+// numbered from 1 in the new message, and the new field takes the lowest
+// number of the moved fields. The new message is named NewMessage, with a
+// number if the name is declared in the file or free reports that it is
+// taken. It returns nil if rng doesn't select fields of a single message.
+func extractMessage(file string, src []byte, rng protocol.Range, free func(string) bool) []protocol.TextEdit {
+	if rng.Start == rng.End {
+		return nil
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	tf := fset.File(f.Pos())
+	offset := func(pos protocol.Position) int {
+		if int(pos.Line) >= tf.LineCount() {
+			return tf.Size()
+		}
+		return tf.Offset(tf.LineStart(int(pos.Line)+1)) + int(pos.Character)
+	}
+	from, to := offset(rng.Start), offset(rng.End)
+	// fieldRange returns the offsets of a field, along with its comments.
+	fieldRange := func(field *ast.Field) (int, int) {
+		start, end := field.Pos(), field.End()
+		if field.Doc != nil {
+			start = field.Doc.Pos()
+		}
+		if field.Comment != nil {
+			end = field.Comment.End()
+		}
+		return tf.Offset(start), tf.Offset(end)
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok || tf.Offset(st.Fields.Opening) > from || tf.Offset(st.Fields.Closing) < to {
+				continue
+			}
+			var fields []*ast.Field
+			for _, field := range st.Fields.List {
+				if start, end := fieldRange(field); start < to && end > from {
+					fields = append(fields, field)
+				}
+			}
+			if len(fields) == 0 {
+				return nil
+			}
+			name := "NewMessage"
+			for i := 1; f.Scope.Lookup(name) != nil || !free(name); i++ {
+				name = fmt.Sprintf("NewMessage%d", i)
+			}
+			nl := "\n"
+			if crlf(src) {
+				nl = "\r\n"
+			}
+			var b strings.Builder
+			b.WriteString(nl + nl + placeholderDoc(name) + nl + "type " + name + " struct {" + nl)
+			number := 0
+			for i, field := range fields {
+				if len(field.Names) != 1 {
+					// Embedded fields and fields declared
+					// together have no number of their own.
+					return nil
+				}
+				start, end := fieldRange(field)
+				tag, pb, ok := retag(field.Tag, i+1)
+				if !ok {
+					return nil
+				}
+				if pb > 0 && (number == 0 || pb < number) {
+					number = pb
+				}
+				typeEnd := tf.Offset(field.Type.End())
+				tagEnd := typeEnd
+				if field.Tag != nil {
+					tagEnd = tf.Offset(field.Tag.End())
+				}
+				b.WriteString("\t" + string(src[start:typeEnd]) + " " + tag + string(src[tagEnd:end]) + nl)
+			}
+			b.WriteString("}")
+			if number == 0 {
+				number = 1
+			}
+			start, _ := fieldRange(fields[0])
+			_, end := fieldRange(fields[len(fields)-1])
+			return []protocol.TextEdit{
+				{
+					Range:   posRange(fset, tf.Pos(start), tf.Pos(end)),
+					NewText: fmt.Sprintf("%s %s `pb:\"%d\"`", name, name, number),
+				},
+				{
+					Range:   posRange(fset, gd.End(), gd.End()),
+					NewText: b.String(),
+				},
+			}
+		}
+	}
+	return nil
+}
+
+// retag returns a field tag with its pb number replaced by number, or added
+// if it has none, and the number it had.
+func retag(lit *ast.BasicLit, number int) (string, int, bool) {
+	var tag string
+	if lit != nil {
+		var err error
+		if tag, err = strconv.Unquote(lit.Value); err != nil {
+			return "", 0, false
+		}
+	}
+	keys, values, err := parseTag(tag)
+	if err != nil {
+		return "", 0, false
+	}
+	pb, _ := strconv.Atoi(reflect.StructTag(tag).Get("pb"))
+	entries := []string{fmt.Sprintf("pb:%q", strconv.Itoa(number))}
+	for _, k := range keys {
+		if k != "pb" {
+			entries = append(entries, fmt.Sprintf("%s:%q", k, values[k]))
+		}
+	}
+	return "`" + strings.Join(entries, " ") + "`", pb, true
+}
@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"go/ast"
 	"go/format"
+	"go/parser"
 	"go/printer"
+	"go/scanner"
 	"go/token"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/gunk/gunk/config"
 	"github.com/gunk/gunkls/lsp/loader"
@@ -21,15 +26,30 @@ import (
 )
 
 func (l *LSP) Format(ctx context.Context, params protocol.DocumentFormattingParams, reply jsonrpc2.Replier) {
-	file := params.TextDocument.URI.Filename()
-	pkg, err := l.filePkg(file)
+	file, err := l.filePath(params.TextDocument.URI)
 	if err != nil {
 		reply(ctx, nil, err)
 		return
 	}
-	config, err := config.Load(pkg.Dir)
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	pkg, err := v.filePkg(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	config, err := v.loader.Config(filepath.Dir(file))
+	if err != nil {
+		reply(ctx, nil, fmt.Errorf("could not load config: %v", err))
+		return
+	}
 	if len(pkg.GunkSyntax) == 0 {
-		l.loader.ParsePackage(pkg, false)
+		v.loader.ParsePackage(pkg, false)
 	}
 	// does this file have errors, or another file?
 	var fileErr bool
@@ -43,31 +63,19 @@ func (l *LSP) Format(ctx context.Context, params protocol.DocumentFormattingPara
 		reply(ctx, nil, fmt.Errorf("file %s has errors", file))
 		return
 	}
-	// find the file
-	var f *ast.File
-	for i, path := range pkg.GunkFiles {
-		if path == file {
-			f = pkg.GunkSyntax[i]
-			break
-		}
-	}
-	if f == nil {
-		reply(ctx, nil, fmt.Errorf("could not find file %s", file))
-		return
-	}
-	// format file
-	fmter, err := New(config)
+	// Parse a copy of the file, as formatting modifies the syntax tree,
+	// which is shared with the loader.
+	src, err := v.loader.ReadFile(file)
 	if err != nil {
-		reply(ctx, nil, fmt.Errorf("could not create formatter: %v", err))
+		reply(ctx, nil, fmt.Errorf("could not find file %s", file))
 		return
 	}
-	formatted, err := fmter.formatFile(l.loader.Fset, f)
+	formatted, err := formatSource(config, l.settings.Format, v.loader.ModulePath(filepath.Dir(file)), file, src)
 	if err != nil {
-		reply(ctx, nil, fmt.Errorf("could not format file: %v", err))
+		reply(ctx, nil, err)
 		return
 	}
-	contents := l.loader.InMemoryFiles[file]
-	lines := strings.Split(contents, "\n")
+	lines := strings.Split(string(src), "\n")
 	reply(ctx, []protocol.TextEdit{
 		{
 			Range: protocol.Range{
@@ -85,10 +93,163 @@ func (l *LSP) Format(ctx context.Context, params protocol.DocumentFormattingPara
 	}, nil)
 }
 
+// RangeFormat formats the declarations overlapping a range of a file. Only
+// these declarations have to be free of syntax errors, so that one of them
+// can be cleaned up while another part of the file is being edited.
+func (l *LSP) RangeFormat(ctx context.Context, params protocol.DocumentRangeFormattingParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	config, err := v.loader.Config(filepath.Dir(file))
+	if err != nil {
+		reply(ctx, nil, fmt.Errorf("could not load config: %v", err))
+		return
+	}
+	src, err := v.loader.ReadFile(file)
+	if err != nil {
+		reply(ctx, nil, fmt.Errorf("could not find file %s", file))
+		return
+	}
+	edits, err := formatRange(config, l.settings.Format, v.loader.ModulePath(filepath.Dir(file)), file, src, params.Range)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	reply(ctx, edits, nil)
+}
+
+// formatRange returns the edits formatting the declarations of a gunk file of
+// module overlapping rng, each formatted on its own.
+func formatRange(cfg *config.Config, opts FormatOptions, module, file string, src []byte, rng protocol.Range) ([]protocol.TextEdit, error) {
+	fset := token.NewFileSet()
+	// Syntax errors are only reported if they are in the range, so
+	// parse as much of the file as possible.
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if f == nil {
+		return nil, fmt.Errorf("file %s has errors", file)
+	}
+	var errs scanner.ErrorList
+	if list, ok := err.(scanner.ErrorList); ok {
+		errs = list
+	}
+	tf := fset.File(f.Pos())
+	offset := func(pos protocol.Position) int {
+		if int(pos.Line) >= tf.LineCount() {
+			return tf.Size()
+		}
+		return tf.Offset(tf.LineStart(int(pos.Line)+1)) + int(pos.Character)
+	}
+	from, to := offset(rng.Start), offset(rng.End)
+	edits := make([]protocol.TextEdit, 0)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		start, end := gd.Pos(), gd.End()
+		if gd.Doc != nil {
+			start = gd.Doc.Pos()
+		}
+		if tf.Offset(start) > to || tf.Offset(end) < from {
+			continue
+		}
+		for _, e := range errs {
+			if e.Pos.Offset >= tf.Offset(start) && e.Pos.Offset <= tf.Offset(end) {
+				return nil, fmt.Errorf("declaration at line %d has errors", fset.Position(gd.Pos()).Line)
+			}
+		}
+		// Format the declaration as a file of its own, and keep what
+		// follows the package clause.
+		nl := "\n"
+		if crlf(src) {
+			nl = "\r\n"
+		}
+		header := "package " + f.Name.Name + nl + nl
+		formatted, err := formatSource(cfg, opts, module, file, []byte(header+string(src[tf.Offset(start):tf.Offset(end)])+nl))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(formatted, []byte(header)) {
+			return nil, fmt.Errorf("could not format declaration at line %d", fset.Position(gd.Pos()).Line)
+		}
+		formatted = bytes.TrimSuffix(formatted[len(header):], []byte(nl))
+		edits = append(edits, protocol.TextEdit{
+			Range:   posRange(fset, start, end),
+			NewText: string(formatted),
+		})
+	}
+	return edits, nil
+}
+
+// formatSource formats the source of a gunk file of module with a gunk
+// configuration.
+func formatSource(cfg *config.Config, opts FormatOptions, module, file string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("file %s has errors", file)
+	}
+	fmter, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create formatter: %v", err)
+	}
+	fmter.Options = opts
+	fmter.Module = module
+	formatted, err := fmter.formatFile(fset, f)
+	if err != nil {
+		return nil, fmt.Errorf("could not format file: %v", err)
+	}
+	// The printer always ends lines with "\n". Keep the line endings of
+	// files written on Windows, so that formatting them doesn't change
+	// every line.
+	if crlf(src) {
+		formatted = bytes.ReplaceAll(bytes.ReplaceAll(formatted, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	}
+	return formatted, nil
+}
+
+// crlf reports whether most lines of src end with "\r\n".
+func crlf(src []byte) bool {
+	n := bytes.Count(src, []byte("\r\n"))
+	return n > 0 && 2*n >= bytes.Count(src, []byte("\n"))
+}
+
+// FormatOptions are the formatting options that are not part of the gunk
+// configuration, as .gunkconfig rejects unknown keys.
+type FormatOptions struct {
+	// AlignTags aligns the names, types and tags of all the fields of a
+	// struct in columns, even across blank lines and comments.
+	AlignTags bool `json:"alignTags"`
+	// GroupImports sorts imports into groups of standard library packages,
+	// annotation packages, third-party packages and packages of the module
+	// of the file, separated by blank lines.
+	GroupImports bool `json:"groupImports"`
+	// WrapWidth is the column doc comments are wrapped at. Gunk tags are
+	// never wrapped. Zero disables wrapping.
+	WrapWidth int `json:"wrapWidth"`
+	// ManualPB leaves fields without a pb number untouched, instead of
+	// assigning them the next free number, and disables reorder_pb.
+	// Enable the pbmissing lint rule to have them reported instead.
+	ManualPB bool `json:"manualPB"`
+}
+
 // Formatter is a struct that holds the state of the formatter.
 // A new formatter should be initialized when using different config.
 type Formatter struct {
-	Config *config.Config
+	Config  *config.Config
+	Options FormatOptions
+	// Module is the path of the module of the formatted file, whose
+	// packages are grouped last when grouping imports.
+	Module string
 
 	snaker *snaker.Initialisms
 }
@@ -120,12 +281,25 @@ func (f *Formatter) formatFile(fset *token.FileSet, file *ast.File) (_ []byte, f
 			}
 		}
 	}()
+	// Doc comments are visited after the node they document.
+	docs := map[*ast.CommentGroup]bool{file.Doc: true}
 	ast.Inspect(file, func(node ast.Node) bool {
 		switch node := node.(type) {
+		case *ast.GenDecl:
+			docs[node.Doc] = true
+		case *ast.TypeSpec:
+			docs[node.Doc] = true
+		case *ast.ValueSpec:
+			docs[node.Doc] = true
+		case *ast.Field:
+			docs[node.Doc] = true
 		case *ast.CommentGroup:
 			if err := f.formatComment(fset, node); err != nil {
 				panic(inspectError{err})
 			}
+			if docs[node] && f.Options.WrapWidth > 0 {
+				wrapComment(fset, node, f.Options.WrapWidth)
+			}
 		case *ast.StructType:
 			if err := f.formatStruct(fset, node); err != nil {
 				panic(inspectError{err})
@@ -137,7 +311,182 @@ func (f *Formatter) formatFile(fset *token.FileSet, file *ast.File) (_ []byte, f
 	if err := format.Node(&buf, fset, file); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	src := buf.Bytes()
+	if f.Options.GroupImports {
+		var err error
+		if src, err = groupImports(src, f.Module); err != nil {
+			return nil, err
+		}
+	}
+	if f.Options.AlignTags {
+		return alignStructs(src)
+	}
+	return src, nil
+}
+
+// importGroup returns the group an import path is sorted in: the standard
+// library, gunk annotations, third-party packages, and then the packages of
+// module, if it isn't empty.
+func importGroup(path, module string) int {
+	switch {
+	case module != "" && (path == module || strings.HasPrefix(path, module+"/")):
+		return 3
+	case !strings.Contains(strings.SplitN(path, "/", 2)[0], "."):
+		return 0
+	case path == "github.com/gunk/opt" || strings.HasPrefix(path, "github.com/gunk/opt/"):
+		return 1
+	}
+	return 2
+}
+
+// groupImports rewrites the parenthesized import declarations of src so that
+// imports are sorted by group and path, with a blank line between groups.
+// module is the path of the module of the file. Declarations with comments
+// that don't belong to an import are left alone.
+func groupImports(src []byte, module string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	// Rewrite from the end, so that earlier offsets stay valid.
+	for i := len(file.Decls) - 1; i >= 0; i-- {
+		gd, ok := file.Decls[i].(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT || !gd.Lparen.IsValid() {
+			continue
+		}
+		owned := make(map[*ast.CommentGroup]bool)
+		type imp struct {
+			group      int
+			path, text string
+		}
+		imps := make([]imp, 0, len(gd.Specs))
+		for _, spec := range gd.Specs {
+			spec := spec.(*ast.ImportSpec)
+			from, to := spec.Pos(), spec.End()
+			if spec.Doc != nil {
+				owned[spec.Doc] = true
+				from = spec.Doc.Pos()
+			}
+			if spec.Comment != nil {
+				owned[spec.Comment] = true
+				to = spec.Comment.End()
+			}
+			path, _ := strconv.Unquote(spec.Path.Value)
+			imps = append(imps, imp{
+				group: importGroup(path, module),
+				path:  path,
+				text:  string(src[offset(from):offset(to)]),
+			})
+		}
+		floating := false
+		for _, c := range file.Comments {
+			if c.Pos() > gd.Lparen && c.End() < gd.Rparen && !owned[c] {
+				floating = true
+				break
+			}
+		}
+		if floating || len(imps) == 0 {
+			continue
+		}
+		sort.SliceStable(imps, func(i, j int) bool {
+			if imps[i].group != imps[j].group {
+				return imps[i].group < imps[j].group
+			}
+			return imps[i].path < imps[j].path
+		})
+		var block strings.Builder
+		block.WriteString("(\n")
+		for i, imp := range imps {
+			if i > 0 && imp.group != imps[i-1].group {
+				block.WriteString("\n")
+			}
+			block.WriteString("\t" + imp.text + "\n")
+		}
+		block.WriteString(")")
+		src = append(src[:offset(gd.Lparen):offset(gd.Lparen)], append([]byte(block.String()), src[offset(gd.Rparen)+1:]...)...)
+	}
+	return format.Source(src)
+}
+
+// alignStructs aligns the fields of every struct in src in three columns of
+// names, types and tags. Unlike gofmt, which restarts alignment after each
+// blank line or comment, a column spans the whole struct. Fields that span
+// multiple lines are left alone.
+func alignStructs(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(src), "\n")
+	text := func(from, to token.Pos) string {
+		return string(src[fset.Position(from).Offset:fset.Position(to).Offset])
+	}
+	ast.Inspect(file, func(node ast.Node) bool {
+		st, ok := node.(*ast.StructType)
+		if !ok || st.Fields == nil {
+			return true
+		}
+		type row struct {
+			line                      int
+			indent, name, typ, tag, c string
+		}
+		var rows []row
+		var nameWidth, typeWidth, tagWidth int
+		for _, field := range st.Fields.List {
+			line := fset.Position(field.Pos()).Line
+			if len(field.Names) == 0 || fset.Position(field.End()).Line != line {
+				continue
+			}
+			// Leave fields sharing a line with the braces alone.
+			if fset.Position(st.Fields.Opening).Line == line || fset.Position(st.Fields.Closing).Line == line {
+				continue
+			}
+			r := row{line: line - 1}
+			r.indent = lines[r.line][:len(lines[r.line])-len(strings.TrimLeft(lines[r.line], "\t "))]
+			r.name = text(field.Names[0].Pos(), field.Names[len(field.Names)-1].End())
+			r.typ = text(field.Type.Pos(), field.Type.End())
+			if field.Tag != nil {
+				r.tag = field.Tag.Value
+			}
+			if field.Comment != nil {
+				if fset.Position(field.Comment.End()).Line != line {
+					continue
+				}
+				r.c = text(field.Comment.Pos(), field.Comment.End())
+			}
+			nameWidth = max(nameWidth, utf8.RuneCountInString(r.name))
+			typeWidth = max(typeWidth, utf8.RuneCountInString(r.typ))
+			tagWidth = max(tagWidth, utf8.RuneCountInString(r.tag))
+			rows = append(rows, r)
+		}
+		for _, r := range rows {
+			line := r.indent + pad(r.name, nameWidth) + " " + pad(r.typ, typeWidth)
+			if tagWidth > 0 {
+				line += " " + pad(r.tag, tagWidth)
+			}
+			if r.c != "" {
+				line += " " + r.c
+			}
+			lines[r.line] = strings.TrimRight(line, " ")
+		}
+		return true
+	})
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// pad pads s with spaces to width.
+func pad(s string, width int) string {
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 func (f *Formatter) formatComment(fset *token.FileSet, group *ast.CommentGroup) error {
@@ -172,6 +521,89 @@ func (f *Formatter) formatComment(fset *token.FileSet, group *ast.CommentGroup)
 	return nil
 }
 
+// directive matches comment directives, such as "//gunkls:ignore", which
+// must be kept on their own line and without a leading space.
+var directive = regexp.MustCompile(`^//[a-z0-9]+:[a-z0-9]`)
+
+// wrapComment re-wraps the paragraphs of a doc comment so that its lines end
+// before width. Gunk tags, directives, and paragraphs that look preformatted,
+// such as lists and indented blocks, are kept as they are.
+func wrapComment(fset *token.FileSet, group *ast.CommentGroup, width int) {
+	var lines []string
+	for _, c := range group.List {
+		if strings.HasPrefix(c.Text, "/*") {
+			return
+		}
+		lines = append(lines, c.Text)
+	}
+	// The comment text starts after the indentation and "// ".
+	width -= fset.Position(group.Pos()).Column - 1 + len("// ")
+	if width <= 0 {
+		return
+	}
+	var out, para []string
+	flush := func() {
+		preformatted := false
+		for _, line := range para {
+			text := strings.TrimPrefix(line, "// ")
+			if line == "//" || !strings.HasPrefix(line, "// ") || strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t") ||
+				strings.HasPrefix(text, "- ") || strings.HasPrefix(text, "* ") {
+				preformatted = true
+				break
+			}
+		}
+		if preformatted {
+			out = append(out, para...)
+			para = nil
+			return
+		}
+		var words []string
+		for _, line := range para {
+			words = append(words, strings.Fields(strings.TrimPrefix(line, "//"))...)
+		}
+		line := ""
+		for _, word := range words {
+			if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+				out = append(out, "// "+line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		if line != "" {
+			out = append(out, "// "+line)
+		}
+		para = nil
+	}
+	for i, line := range lines {
+		if strings.HasPrefix(line, "// +gunk ") {
+			// Everything after the first gunk tag is part of the tags.
+			flush()
+			out = append(out, lines[i:]...)
+			break
+		}
+		if strings.TrimSpace(strings.TrimPrefix(line, "//")) == "" || directive.MatchString(line) {
+			flush()
+			out = append(out, line)
+			continue
+		}
+		para = append(para, line)
+	}
+	flush()
+	list := make([]*ast.Comment, len(out))
+	for i, text := range out {
+		list[i] = &ast.Comment{Text: text}
+	}
+	// Keep the group on the same lines, like loader.CommentFromText.
+	list[0].Slash = group.List[0].Slash
+	if len(list) > 1 {
+		list[len(list)-1].Slash = group.List[len(group.List)-1].Slash
+	}
+	group.List = list
+}
+
 func (f *Formatter) formatStruct(fset *token.FileSet, st *ast.StructType) error {
 	if st.Fields == nil {
 		return nil
@@ -230,11 +662,11 @@ func (f *Formatter) formatStruct(fset *token.FileSet, st *ast.StructType) error
 		}
 		// Insert JSON and protobuf key.
 		entries := make([]string, 0, len(key))
-		if f.Config.Format.PB {
+		if f.Config.Format.PB && !f.Options.ManualPB {
 			entries = append(entries, fmt.Sprintf("pb:%q", strconv.Itoa(i+1)))
 		} else if _, ok := value["pb"]; ok {
 			entries = append(entries, fmt.Sprintf("pb:%q", value["pb"]))
-		} else {
+		} else if !f.Options.ManualPB {
 			// Default behaviour: Add missing entries.
 			entries = append(entries, fmt.Sprintf("pb:%q", strconv.Itoa(missingNum[0])))
 			missingNum = missingNum[1:]
@@ -0,0 +1,426 @@
+package lsp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.uber.org/zap"
+)
+
+// commandGenerate runs gunk generate for the package of the file given as its
+// argument, as gunk generate would in the directory of the package, and for
+// the packages importing it. Without an argument, it generates the packages of
+// the workspace that changed since they were last generated.
+const commandGenerate = "gunkls.generate"
+
+// generations records the runs of gunk generate, to show their results in the
+// code lenses of the packages.
+type generations struct {
+	// run serializes the runs, which may share output files through the
+	// gunk configuration.
+	run sync.Mutex
+
+	// mu guards results, pending and hashes.
+	mu      sync.Mutex
+	results map[string]generation
+	// pending are the generations waiting for saves to settle, by
+	// package directory.
+	pending map[string]*time.Timer
+	// hashes are the hashes of the sources of the packages when they
+	// were last generated successfully, by package directory.
+	hashes map[string]string
+}
+
+// generation is the last run of gunk generate for a package directory.
+type generation struct {
+	running bool
+	end     time.Time
+	err     error
+}
+
+func (g *generations) get(dir string) generation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.results[dir]
+}
+
+func (g *generations) set(dir string, gen generation) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.results == nil {
+		g.results = make(map[string]generation)
+	}
+	g.results[dir] = gen
+}
+
+func (g *generations) setHash(dir, hash string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.hashes == nil {
+		g.hashes = make(map[string]string)
+	}
+	g.hashes[dir] = hash
+}
+
+// changed reports whether the sources of the package in dir changed since
+// it was last generated successfully.
+func (g *generations) changed(dir string) bool {
+	hash, err := sourceHash(dir)
+	if err != nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.hashes[dir] != hash
+}
+
+// debounce calls f with dir once no other call for dir was made for
+// generateOnSaveDelay.
+func (g *generations) debounce(dir string, f func(dir string)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.pending[dir]; ok {
+		t.Stop()
+	}
+	if g.pending == nil {
+		g.pending = make(map[string]*time.Timer)
+	}
+	var t *time.Timer
+	t = time.AfterFunc(generateOnSaveDelay, func() {
+		g.mu.Lock()
+		if g.pending[dir] != t {
+			g.mu.Unlock()
+			return
+		}
+		delete(g.pending, dir)
+		g.mu.Unlock()
+		f(dir)
+	})
+	g.pending[dir] = t
+}
+
+// title is the title of the code lens of a package, with the result of its
+// last generation.
+func (gen generation) title() string {
+	switch {
+	case gen.running:
+		return "gunk generate (running)"
+	case gen.err != nil:
+		return "gunk generate (failed, see message)"
+	case !gen.end.IsZero():
+		return "gunk generate (done at " + gen.end.Format("15:04:05") + ")"
+	}
+	return "gunk generate"
+}
+
+// CodeLens replies with a code lens on the package clause of a gunk file,
+// which runs gunk generate for its package.
+func (l *LSP) CodeLens(ctx context.Context, params protocol.CodeLensParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	if filepath.Ext(file) != ".gunk" || isRemote(file) {
+		reply(ctx, []protocol.CodeLens{}, nil)
+		return
+	}
+	// Without an analysis of the package, the lens goes on the first line.
+	var rng protocol.Range
+	if v := l.findView(file); v != nil {
+		s := v.snapshot()
+		if pkg, ok := s.filePkg(file); ok {
+			for i, path := range pkg.GunkFiles {
+				if path == file && i < len(pkg.GunkSyntax) {
+					rng = nodeRange(s.fset, pkg.GunkSyntax[i].Name)
+					rng.Start.Character = 0
+					break
+				}
+			}
+		}
+	}
+	gen := l.generations.get(filepath.Dir(file))
+	reply(ctx, []protocol.CodeLens{{
+		Range: rng,
+		Command: &protocol.Command{
+			Title:     gen.title(),
+			Command:   commandGenerate,
+			Arguments: []interface{}{string(params.TextDocument.URI)},
+		},
+	}}, nil)
+}
+
+// Generate runs gunk generate for the package of the file given as the
+// argument of the command and the packages importing it, and replies once it
+// is done. Without an argument, the packages of the workspace that changed
+// since they were last generated are generated instead. The results are shown
+// as messages and in the code lenses of the packages.
+func (l *LSP) Generate(ctx context.Context, params protocol.ExecuteCommandParams, reply jsonrpc2.Replier) {
+	var u string
+	if len(params.Arguments) > 0 {
+		u, _ = params.Arguments[0].(string)
+	}
+	if u == "" {
+		views := append([]*view(nil), l.views...)
+		// Generating takes seconds, and the server keeps answering
+		// meanwhile.
+		l.goReply(ctx, commandGenerate, reply, func() (interface{}, error) {
+			for _, v := range views {
+				var changed []string
+				v.locked(func() {
+					for _, pkg := range v.pkgs {
+						if len(pkg.GunkFiles) > 0 && l.generations.changed(pkg.Dir) {
+							changed = append(changed, pkg.Dir)
+						}
+					}
+				})
+				if err := l.generatePackages(ctx, v, changed); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		return
+	}
+	file, err := l.filePath(protocol.DocumentURI(u))
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	if isRemote(file) {
+		reply(ctx, nil, fmt.Errorf("cannot generate %s: not on the local file system", u))
+		return
+	}
+	v := l.findView(file)
+	l.goReply(ctx, commandGenerate, reply, func() (interface{}, error) {
+		return nil, l.generatePackages(ctx, v, []string{filepath.Dir(file)})
+	})
+}
+
+// generatePackages generates the packages in dirs and the packages of v
+// importing them, directly or not. v may be nil, in which case only the
+// packages in dirs are generated.
+func (l *LSP) generatePackages(ctx context.Context, v *view, dirs []string) error {
+	if v != nil {
+		v.locked(func() {
+			dirs = generationOrder(v.loader, v.pkgs, dirs)
+		})
+	}
+	return l.generate(ctx, dirs)
+}
+
+// generationOrder returns the directories in roots and the directories of
+// the packages of pkgs importing them, directly or not, with each package
+// before the packages importing it. v.mu must be held.
+func generationOrder(ldr *loader.Loader, pkgs []*loader.GunkPackage, roots []string) []string {
+	// Packages that aren't open haven't been parsed, so the imports are
+	// read from their files.
+	importers := make(map[string][]string)
+	for _, pkg := range pkgs {
+		if len(pkg.GunkFiles) == 0 {
+			continue
+		}
+		for _, path := range ldr.ImportPaths([]*loader.GunkPackage{pkg}) {
+			importers[path] = append(importers[path], pkg.Dir)
+		}
+	}
+	paths := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		paths[pkg.Dir] = pkg.PkgPath
+	}
+	// A reverse postorder of the importers graph puts packages before
+	// their importers.
+	var order []string
+	seen := make(map[string]bool)
+	var visit func(dir string)
+	visit = func(dir string) {
+		if seen[dir] {
+			return
+		}
+		seen[dir] = true
+		if path, ok := paths[dir]; ok {
+			for _, importer := range importers[path] {
+				visit(importer)
+			}
+		}
+		order = append(order, dir)
+	}
+	for i := len(roots) - 1; i >= 0; i-- {
+		visit(roots[i])
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// generate runs gunk generate for the packages in dirs, in order, reporting
+// its progress and results. It stops at the first package that fails, or when
+// cancelled from the progress shown by the client. It
+// must not be called with l.mu held, as it waits for the other generations
+// to finish.
+func (l *LSP) generate(ctx context.Context, dirs []string) error {
+	if len(dirs) == 0 {
+		return nil
+	}
+	prev := make([]generation, len(dirs))
+	for i, dir := range dirs {
+		prev[i] = l.generations.get(dir)
+		l.generations.set(dir, generation{running: true})
+	}
+	l.refreshCodeLens(ctx)
+	defer l.refreshCodeLens(ctx)
+	// Cancelling stops before the next package. Notifications are still
+	// sent with ctx.
+	work, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p := l.startProgress(ctx, "gunk generate", cancel)
+	l.generations.run.Lock()
+	defer l.generations.run.Unlock()
+	start := time.Now()
+	for i, dir := range dirs {
+		if err := work.Err(); err != nil {
+			for j := i; j < len(dirs); j++ {
+				l.generations.set(dirs[j], prev[j])
+			}
+			p.end("cancelled")
+			l.msg(ctx, protocol.MessageTypeInfo, fmt.Sprintf("Cancelled gunk generate after %d of %d packages", i, len(dirs)))
+			return err
+		}
+		p.report(fmt.Sprintf("%s (%d/%d)", dir, i+1, len(dirs)), uint32(100*i/len(dirs)))
+		// The sources are hashed before generating, so that a change
+		// made meanwhile is generated again.
+		hash, hashErr := sourceHash(dir)
+		err := runGenerate(dir)
+		l.generations.set(dir, generation{end: time.Now(), err: err})
+		if err != nil {
+			for j := i + 1; j < len(dirs); j++ {
+				l.generations.set(dirs[j], prev[j])
+			}
+			p.end("failed")
+			l.logger.Warn("gunk generate failed", zap.String("dir", dir), zap.Error(err))
+			l.msg(ctx, protocol.MessageTypeError, fmt.Sprintf("gunk generate failed for %s: %v", dir, err))
+			return err
+		}
+		if hashErr == nil {
+			l.generations.setHash(dir, hash)
+		}
+	}
+	p.end("done")
+	l.logger.Debug("generated packages", zap.Strings("dirs", dirs), zap.Duration("latency", time.Since(start)))
+	if len(dirs) == 1 {
+		l.msg(ctx, protocol.MessageTypeInfo, "Generated "+dirs[0])
+	} else {
+		l.msg(ctx, protocol.MessageTypeInfo, fmt.Sprintf("Generated %d packages", len(dirs)))
+	}
+	return nil
+}
+
+// sourceHash hashes the gunk files of the package in dir and the gunk
+// configuration files of dir and its parents, which together with the
+// imported packages are the inputs of gunk generate.
+func sourceHash(dir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.gunk"))
+	if err != nil {
+		return "", err
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		files = append(files, filepath.Join(d, ".gunkconfig"))
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	h := sha256.New()
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// generateOnSaveDelay is how long to wait after a gunk file is saved before
+// generating its package, so that saving several files generates it once.
+const generateOnSaveDelay = 500 * time.Millisecond
+
+// SaveFile generates the package of a saved gunk file and the packages
+// importing it if the generateOnSave setting is set, once its package has been
+// analyzed without errors. Nothing is generated if its sources didn't change
+// since it was last generated.
+func (l *LSP) SaveFile(ctx context.Context, params protocol.DidSaveTextDocumentParams) {
+	if !l.settings.GenerateOnSave {
+		return
+	}
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil || filepath.Ext(file) != ".gunk" || isRemote(file) {
+		return
+	}
+	v := l.findView(file)
+	if v == nil {
+		return
+	}
+	l.generations.debounce(filepath.Dir(file), func(dir string) {
+		l.generateSaved(ctx, v, dir)
+	})
+}
+
+// generateSaved generates the saved package in dir, if it has no errors. If
+// the package hasn't been analyzed since its last change, it waits for the
+// analysis first.
+func (l *LSP) generateSaved(ctx context.Context, v *view, dir string) {
+	l.sched.schedule(background, func() {
+		var pkg *loader.GunkPackage
+		v.locked(func() {
+			for _, p := range v.pkgs {
+				if p.Dir == dir {
+					pkg = p
+					break
+				}
+			}
+			if pkg != nil && pkg.State == loader.Dirty {
+				pkg = nil
+				l.generations.debounce(dir, func(dir string) {
+					l.generateSaved(ctx, v, dir)
+				})
+			} else if pkg != nil && len(pkg.Errors) > 0 {
+				l.logger.Debug("not generating package with errors", zap.String("dir", dir))
+				pkg = nil
+			}
+		})
+		if pkg == nil {
+			return
+		}
+		if !l.generations.changed(dir) {
+			l.logger.Debug("package is already generated", zap.String("dir", dir))
+			return
+		}
+		l.goRecover("generate on save", func() {
+			l.generatePackages(ctx, v, []string{dir})
+		})
+	})
+}
+
+// refreshCodeLens asks the client to request the code lenses again, if it
+// can.
+func (l *LSP) refreshCodeLens(ctx context.Context) {
+	if !l.codeLensRefresh {
+		return
+	}
+	// The client may send requests before replying, which would wait for
+	// the lock held by the caller.
+	go l.conn.Call(ctx, protocol.MethodCodeLensRefresh, nil, nil)
+}
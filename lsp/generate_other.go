@@ -0,0 +1,10 @@
+//go:build !windows
+
+package lsp
+
+import "github.com/gunk/gunk/generate"
+
+// runGenerate runs gunk generate for the package in dir.
+func runGenerate(dir string) error {
+	return generate.Run(dir, ".")
+}
@@ -0,0 +1,9 @@
+package lsp
+
+import "errors"
+
+// runGenerate fails, as the generate package of gunk doesn't build on
+// Windows. gunk generate can still be run from a terminal.
+func runGenerate(dir string) error {
+	return errors.New("gunk generate is not supported by gunkls on Windows, run it from a terminal instead")
+}
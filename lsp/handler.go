@@ -1 +1,98 @@
 package lsp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gunk/gunkls/lsp/metrics"
+	"go.lsp.dev/jsonrpc2"
+	"go.uber.org/zap"
+)
+
+// trace logs the handling of a request at debug level and records it in the
+// metrics, with its latency: the time until it is replied to for calls, which
+// may be after Handle returns, or until it is handled for notifications. The
+// returned function must be called once the request is handled.
+func (l *LSP) trace(reply jsonrpc2.Replier, r jsonrpc2.Request) (jsonrpc2.Replier, func()) {
+	start := time.Now()
+	call, ok := r.(*jsonrpc2.Call)
+	if !ok {
+		l.logger.Debug("notification", zap.String("method", r.Method()))
+		return reply, func() {
+			metrics.Request(r.Method(), time.Since(start))
+			l.logger.Debug("handled notification",
+				zap.String("method", r.Method()),
+				zap.Duration("latency", time.Since(start)))
+		}
+	}
+	id := fmt.Sprint(call.ID())
+	l.logger.Debug("request", zap.String("method", r.Method()), zap.String("id", id))
+	return func(ctx context.Context, result interface{}, err error) error {
+		metrics.Request(r.Method(), time.Since(start))
+		l.logger.Debug("replied",
+			zap.String("method", r.Method()),
+			zap.String("id", id),
+			zap.Duration("latency", time.Since(start)),
+			zap.NamedError("reply", err))
+		return reply(ctx, result, err)
+	}, func() {}
+}
+
+// recoverPanic returns a replier that records whether the request was replied
+// to, and a function to be deferred by the handler, which recovers from a
+// panic while handling the request so that a bug doesn't stop the server. The
+// panic is logged with its stack, and the request is replied to with an
+// internal error unless it already was.
+func (l *LSP) recoverPanic(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Request) (jsonrpc2.Replier, func()) {
+	var replied bool
+	return func(ctx context.Context, result interface{}, err error) error {
+			replied = true
+			return reply(ctx, result, err)
+		}, func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+			l.logger.Error("panic handling request",
+				zap.String("method", r.Method()),
+				zap.Any("panic", x),
+				zap.StackSkip("stack", 1))
+			if _, ok := r.(*jsonrpc2.Call); ok && !replied {
+				reply(ctx, nil, jsonrpc2.Errorf(jsonrpc2.InternalError, "gunkls: panic handling %s: %v", r.Method(), x))
+			}
+		}
+}
+
+// goRecover runs fn in a new goroutine, for the work that handlers and jobs
+// leave running, recovering from a panic in it as the scheduler does. what
+// names the work in the log.
+func (l *LSP) goRecover(what string, fn func()) {
+	go func() {
+		defer func() {
+			if x := recover(); x != nil {
+				l.logger.Error("panic in "+what, zap.Any("panic", x), zap.StackSkip("stack", 1))
+			}
+		}()
+		fn()
+	}()
+}
+
+// goReply runs fn in a new goroutine, and replies to a request with its
+// result once it returns. As with recoverPanic, a panic in fn is logged, and
+// the request is replied to with an internal error.
+func (l *LSP) goReply(ctx context.Context, method string, reply jsonrpc2.Replier, fn func() (interface{}, error)) {
+	go func() {
+		defer func() {
+			if x := recover(); x != nil {
+				l.logger.Error("panic handling request",
+					zap.String("method", method),
+					zap.Any("panic", x),
+					zap.StackSkip("stack", 1))
+				reply(ctx, nil, jsonrpc2.Errorf(jsonrpc2.InternalError, "gunkls: panic handling %s: %v", method, x))
+			}
+		}()
+		result, err := fn()
+		reply(ctx, result, err)
+	}()
+}
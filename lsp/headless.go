@@ -0,0 +1,136 @@
+package lsp
+
+import (
+	"context"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/lint"
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// The functions below run the analysis and formatting of the server outside
+// of an editor, such as from the command line.
+
+// loadPatterns loads the packages matching patterns with a new loader for dir.
+// Relative patterns, such as ./..., are relative to dir.
+func loadPatterns(dir string, patterns []string) (*loader.Loader, []*loader.GunkPackage, error) {
+	ldr := &loader.Loader{
+		Dir:  dir,
+		Fset: token.NewFileSet(),
+	}
+	var pkgs []*loader.GunkPackage
+	seen := make(map[*loader.GunkPackage]bool)
+	for _, pattern := range patterns {
+		if pattern == "." || pattern == ".." || strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../") {
+			// The loader expects directories to be absolute.
+			recursive := strings.HasSuffix(pattern, "/...")
+			pattern = filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(pattern, "/...")))
+			if recursive {
+				pattern += "/..."
+			}
+		}
+		loaded, err := ldr.Load(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, pkg := range loaded {
+			if !seen[pkg] {
+				seen[pkg] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return ldr, pkgs, nil
+}
+
+// Lint loads the packages matching patterns, relative to dir, and returns the
+// diagnostics of their gunk files as the server publishes them: their errors,
+// and the findings of the lint rules for the packages without errors.
+func Lint(ctx context.Context, dir string, patterns []string, cfg lint.Config) (map[string][]protocol.Diagnostic, error) {
+	ldr, pkgs, err := loadPatterns(dir, patterns)
+	if err != nil {
+		return nil, err
+	}
+	diags := make(map[string][]protocol.Diagnostic)
+	for _, pkg := range pkgs {
+		pkg.State = loader.Dirty
+		pkgDiags, err := ldr.Errors(pkgs, pkg)
+		if err != nil {
+			return nil, err
+		}
+		for file, d := range pkgDiags {
+			diags[file] = append(diags[file], d...)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	// All packages are type checked before linting, so that the rules
+	// see the uses of a package by all the others.
+	refs := lint.NewReferences()
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			continue
+		}
+		for file, d := range lint.LintPkg(ctx, pkg, pkgs, refs, ldr, cfg) {
+			diags[file] = append(diags[file], d...)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return diags, nil
+}
+
+// Warmup type-checks the packages imported by the packages matching patterns,
+// relative to dir, which fills the caches of the go command and of the module
+// versions with gunk files. It returns the number of packages type-checked.
+func Warmup(ctx context.Context, dir string, patterns []string) (int, error) {
+	ldr, pkgs, err := loadPatterns(dir, patterns)
+	if err != nil {
+		return 0, err
+	}
+	var checked int
+	for _, path := range ldr.ImportPaths(pkgs) {
+		if err := ctx.Err(); err != nil {
+			return checked, err
+		}
+		if _, err := ldr.Import(path); err == nil {
+			checked++
+		}
+	}
+	return checked, nil
+}
+
+// GunkFiles returns the gunk files of the packages matching patterns,
+// relative to dir.
+func GunkFiles(dir string, patterns []string) ([]string, error) {
+	_, pkgs, err := loadPatterns(dir, patterns)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, pkg := range pkgs {
+		files = append(files, pkg.GunkFiles...)
+	}
+	return files, nil
+}
+
+// FormatFile formats the source of a gunk file, with the gunk configuration
+// of its directory.
+func FormatFile(file string, src []byte, opts FormatOptions) ([]byte, error) {
+	ldr := &loader.Loader{Dir: filepath.Dir(file)}
+	cfg, err := ldr.Config(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	// Finding the module runs the go command, so only do so if needed.
+	var module string
+	if opts.GroupImports {
+		module = ldr.ModulePath(filepath.Dir(file))
+	}
+	return formatSource(cfg, opts, module, file, src)
+}
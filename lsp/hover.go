@@ -0,0 +1,135 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/types"
+	"strings"
+
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// Hover shows the Go client and server methods generated for the service
+// method at the position, with the stream interfaces of streaming methods.
+func (l *LSP) Hover(ctx context.Context, params protocol.HoverParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok || pkg.TypesInfo == nil {
+		reply(ctx, nil, nil)
+		return
+	}
+	f := pkgFile(pkg, file)
+	if f == nil {
+		reply(ctx, nil, nil)
+		return
+	}
+	ident, obj := identAt(s.fset, pkg, f, params.Position)
+	service, fn := serviceOf(pkg, obj)
+	if fn == nil {
+		reply(ctx, nil, nil)
+		return
+	}
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok {
+		reply(ctx, nil, nil)
+		return
+	}
+	value := "```go\n" + grpcSignatures(pkg.Types, service.Name(), fn.Name(), sig) + "```"
+	if pkg.Stale {
+		value += "\n\n*The package has errors: this is from its last successful analysis.*"
+	}
+	r := nodeRange(s.fset, ident)
+	reply(ctx, &protocol.Hover{
+		Contents: protocol.MarkupContent{
+			Kind:  protocol.Markdown,
+			Value: value,
+		},
+		Range: &r,
+	}, nil)
+}
+
+// grpcSignatures returns the methods generated by protoc-gen-go-grpc for a
+// service method, in the client and server interfaces. Streams are chan
+// parameters and results in gunk.
+func grpcSignatures(pkg *types.Package, service, method string, sig *types.Signature) string {
+	// Messages of other packages are qualified by the name of their
+	// generated Go package, and methods without a request or a response
+	// use the well-known empty message.
+	qualifier := func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+	message := func(tuple *types.Tuple) (string, bool) {
+		if tuple.Len() == 0 {
+			return "*emptypb.Empty", false
+		}
+		typ := tuple.At(0).Type()
+		if ch, ok := typ.(*types.Chan); ok {
+			return "*" + types.TypeString(ch.Elem(), qualifier), true
+		}
+		return "*" + types.TypeString(typ, qualifier), false
+	}
+	req, clientStream := message(sig.Params())
+	resp, serverStream := message(sig.Results())
+	stream := service + "_" + method
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %sClient\n", service)
+	switch {
+	case clientStream:
+		fmt.Fprintf(&b, "%s(ctx context.Context, opts ...grpc.CallOption) (%sClient, error)\n", method, stream)
+	case serverStream:
+		fmt.Fprintf(&b, "%s(ctx context.Context, in %s, opts ...grpc.CallOption) (%sClient, error)\n", method, req, stream)
+	default:
+		fmt.Fprintf(&b, "%s(ctx context.Context, in %s, opts ...grpc.CallOption) (%s, error)\n", method, req, resp)
+	}
+	fmt.Fprintf(&b, "\n// %sServer\n", service)
+	switch {
+	case clientStream:
+		fmt.Fprintf(&b, "%s(%sServer) error\n", method, stream)
+	case serverStream:
+		fmt.Fprintf(&b, "%s(%s, %sServer) error\n", method, req, stream)
+	default:
+		fmt.Fprintf(&b, "%s(context.Context, %s) (%s, error)\n", method, req, resp)
+	}
+	if !clientStream && !serverStream {
+		return b.String()
+	}
+
+	var client, server []string
+	switch {
+	case clientStream && serverStream:
+		client = []string{"Send(" + req + ") error", "Recv() (" + resp + ", error)"}
+		server = []string{"Send(" + resp + ") error", "Recv() (" + req + ", error)"}
+	case clientStream:
+		client = []string{"Send(" + req + ") error", "CloseAndRecv() (" + resp + ", error)"}
+		server = []string{"SendAndClose(" + resp + ") error", "Recv() (" + req + ", error)"}
+	default:
+		client = []string{"Recv() (" + resp + ", error)"}
+		server = []string{"Send(" + resp + ") error"}
+	}
+	fmt.Fprintf(&b, "\ntype %sClient interface {\n", stream)
+	for _, m := range client {
+		fmt.Fprintf(&b, "\t%s\n", m)
+	}
+	b.WriteString("\tgrpc.ClientStream\n}\n")
+	fmt.Fprintf(&b, "\ntype %sServer interface {\n", stream)
+	for _, m := range server {
+		fmt.Fprintf(&b, "\t%s\n", m)
+	}
+	b.WriteString("\tgrpc.ServerStream\n}\n")
+	return b.String()
+}
@@ -0,0 +1,266 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"runtime"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"golang.org/x/tools/go/packages"
+)
+
+// implementationMode loads the metadata of the Go packages of a module and
+// of their dependencies, which are then type checked by checkGoPackages.
+const implementationMode = packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps
+
+// Implementation replies with the Go types of the module implementing the
+// server interface generated for the gunk service at the position, or with
+// their methods if the position is on a method of the service. The Go
+// packages are loaded in the background, as it may take a while.
+func (l *LSP) Implementation(ctx context.Context, params protocol.ImplementationParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok || pkg.TypesInfo == nil {
+		reply(ctx, nil, fmt.Errorf("package of %s has not been analyzed yet", file))
+		return
+	}
+	f := pkgFile(pkg, file)
+	if f == nil {
+		reply(ctx, nil, fmt.Errorf("could not find file %s", file))
+		return
+	}
+	_, obj := identAt(s.fset, pkg, f, params.Position)
+	service, fn := serviceOf(pkg, obj)
+	if service == nil {
+		// Not on a service.
+		reply(ctx, nil, nil)
+		return
+	}
+	var method string
+	if fn != nil {
+		method = fn.Name()
+	}
+	dir := moduleRoot(pkg.Dir)
+	if dir == "" {
+		dir = v.loader.Dir
+	}
+	cfg := &packages.Config{
+		Context: ctx,
+		Dir:     dir,
+		Env:     loader.GoEnv(append(os.Environ(), l.settings.environ()...), l.settings.GoCommand),
+		Mode:    implementationMode,
+	}
+	p := l.requestProgress(ctx, params.WorkDoneProgressParams, "Finding implementations")
+	l.goReply(ctx, protocol.MethodTextDocumentImplementation, reply, func() (interface{}, error) {
+		p.report("loading the Go packages of "+dir, 0)
+		roots, err := packages.Load(cfg, "./...")
+		if err != nil {
+			p.end("failed")
+			return nil, fmt.Errorf("could not load the Go packages of %s: %v", dir, err)
+		}
+		p.report(fmt.Sprintf("type checking %d packages", len(roots)), 50)
+		fset := token.NewFileSet()
+		checked := checkGoPackages(fset, roots)
+		locs := l.goImplementations(fset, roots, checked, pkg.PkgPath, service.Name()+"Server", method)
+		p.end(fmt.Sprintf("found %d implementations", len(locs)))
+		return locs, nil
+	})
+}
+
+// identAt returns the identifier at a position in a file, and the object it
+// defines or refers to, if any. There is no object if the package could not
+// be type checked.
+func identAt(fset *token.FileSet, pkg *loader.GunkPackage, f *ast.File, pos protocol.Position) (*ast.Ident, types.Object) {
+	// LSP positions are 0 indexed.
+	pos.Line++
+	pos.Character++
+	var ident *ast.Ident
+	ast.Inspect(f, func(node ast.Node) bool {
+		if ident != nil || node == nil || !contains(fset, node, pos) {
+			return false
+		}
+		if id, ok := node.(*ast.Ident); ok {
+			ident = id
+		}
+		return true
+	})
+	if ident == nil {
+		return nil, nil
+	}
+	if pkg.TypesInfo == nil {
+		return ident, nil
+	}
+	return ident, pkg.TypesInfo.ObjectOf(ident)
+}
+
+// serviceOf returns the service declared by obj, or the service and its
+// method if obj is a method of a service of pkg.
+func serviceOf(pkg *loader.GunkPackage, obj types.Object) (*types.TypeName, *types.Func) {
+	if obj == nil || obj.Pkg() != pkg.Types {
+		return nil, nil
+	}
+	if tn, ok := obj.(*types.TypeName); ok && types.IsInterface(tn.Type()) {
+		return tn, nil
+	}
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil, nil
+	}
+	// Find the service declaring the method.
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		iface, ok := tn.Type().Underlying().(*types.Interface)
+		if !ok {
+			continue
+		}
+		for i := 0; i < iface.NumExplicitMethods(); i++ {
+			if iface.ExplicitMethod(i) == fn {
+				return tn, fn
+			}
+		}
+	}
+	return nil, nil
+}
+
+// pkgFile returns the syntax of a file of a package, or nil if it could not
+// be parsed.
+func pkgFile(pkg *loader.GunkPackage, file string) *ast.File {
+	for i, path := range pkg.GunkFiles {
+		if path == file && i < len(pkg.GunkSyntax) {
+			return pkg.GunkSyntax[i]
+		}
+	}
+	return nil
+}
+
+// checkGoPackages type checks Go packages and their dependencies from source,
+// ignoring function bodies, and returns their type information by ID. Type
+// errors are ignored, as most declarations are still known.
+func checkGoPackages(fset *token.FileSet, roots []*packages.Package) map[string]*types.Package {
+	checked := make(map[string]*types.Package)
+	sizes := types.SizesFor("gc", runtime.GOARCH)
+	var check func(p *packages.Package) *types.Package
+	check = func(p *packages.Package) *types.Package {
+		if p.PkgPath == "unsafe" {
+			return types.Unsafe
+		}
+		if tpkg, ok := checked[p.ID]; ok {
+			return tpkg
+		}
+		// Import cycles are errors, and end with an empty package.
+		checked[p.ID] = types.NewPackage(p.PkgPath, p.Name)
+		var files []*ast.File
+		for _, name := range p.GoFiles {
+			if f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution); f != nil && err == nil {
+				files = append(files, f)
+			}
+		}
+		conf := &types.Config{
+			Importer: importerFunc(func(path string) (*types.Package, error) {
+				imp, ok := p.Imports[path]
+				if !ok {
+					return nil, fmt.Errorf("%s is not imported by %s", path, p.PkgPath)
+				}
+				return check(imp), nil
+			}),
+			Sizes:            sizes,
+			IgnoreFuncBodies: true,
+			Error:            func(error) {},
+		}
+		tpkg, _ := conf.Check(p.PkgPath, fset, files, nil)
+		checked[p.ID] = tpkg
+		return tpkg
+	}
+	for _, p := range roots {
+		check(p)
+	}
+	return checked
+}
+
+// importerFunc implements types.Importer with a function.
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// goImplementations returns the locations of the types of the root packages
+// implementing the interface named server generated in the package at path,
+// or of their method if method is not empty.
+func (l *LSP) goImplementations(fset *token.FileSet, roots []*packages.Package, checked map[string]*types.Package, path, server, method string) []protocol.Location {
+	locs := make([]protocol.Location, 0)
+	var iface *types.Interface
+	for _, tpkg := range checked {
+		if tpkg == nil || tpkg.Path() != path {
+			continue
+		}
+		if tn, ok := tpkg.Scope().Lookup(server).(*types.TypeName); ok {
+			iface, _ = tn.Type().Underlying().(*types.Interface)
+		}
+	}
+	if iface == nil {
+		// Not generated yet.
+		return locs
+	}
+	for _, p := range roots {
+		tpkg := checked[p.ID]
+		if tpkg == nil {
+			continue
+		}
+		scope := tpkg.Scope()
+		for _, name := range scope.Names() {
+			impl, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || types.IsInterface(impl.Type()) || name == "Unimplemented"+server {
+				// The generated type embedded for forward
+				// compatibility implements nothing.
+				continue
+			}
+			typ := impl.Type()
+			if !types.Implements(typ, iface) && !types.Implements(types.NewPointer(typ), iface) {
+				continue
+			}
+			if method == "" {
+				locs = append(locs, l.goLocation(fset, impl.Pos(), impl.Name()))
+				continue
+			}
+			obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(typ), false, tpkg, method)
+			// Methods promoted from another package, such as the
+			// generated Unimplemented type, are not implementations.
+			if fn, ok := obj.(*types.Func); ok && fn.Pkg() == tpkg {
+				locs = append(locs, l.goLocation(fset, fn.Pos(), fn.Name()))
+			}
+		}
+	}
+	return locs
+}
+
+// goLocation returns the location of a name declared in a Go file.
+func (l *LSP) goLocation(fset *token.FileSet, pos token.Pos, name string) protocol.Location {
+	p := fset.Position(pos)
+	start := protocol.Position{Line: uint32(p.Line - 1), Character: uint32(p.Column - 1)}
+	end := start
+	end.Character += uint32(len(name))
+	return protocol.Location{
+		URI:   l.fileURI(p.Filename),
+		Range: protocol.Range{Start: start, End: end},
+	}
+}
@@ -0,0 +1,86 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// methodIndexingStatus is sent to the client when the state of the background
+// indexing of the workspace changes, so that it can show whether features
+// such as workspace symbols cover the whole workspace yet. The same states are
+// reported as work done progress.
+const methodIndexingStatus = "gunkls/indexingStatus"
+
+type indexingStatus struct {
+	// State is "scanning" while the modules of the workspace are found
+	// and loaded, "indexing" while its packages are parsed, and "idle"
+	// once they all are.
+	State   string `json:"state"`
+	Message string `json:"message"`
+	Done    int    `json:"done,omitempty"`
+	Total   int    `json:"total,omitempty"`
+}
+
+// indexing tracks the packages queued for indexing in the background.
+type indexing struct {
+	mu       sync.Mutex
+	done     int
+	total    int
+	progress *progress
+}
+
+// scanning reports that the modules of the workspace are being loaded.
+func (l *LSP) scanning(ctx context.Context) {
+	ix := &l.indexing
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.progress == nil {
+		ix.progress = l.startProgress(ctx, "Indexing", nil)
+	}
+	ix.progress.report("scanning modules", 0)
+	l.conn.Notify(ctx, methodIndexingStatus, indexingStatus{State: "scanning", Message: "scanning modules"})
+}
+
+// queueIndexing reports that n more packages are to be indexed. With no
+// packages left to index, the indexing is reported as idle.
+func (l *LSP) queueIndexing(ctx context.Context, n int) {
+	ix := &l.indexing
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.progress == nil && n > 0 {
+		ix.progress = l.startProgress(ctx, "Indexing", nil)
+	}
+	if n == 0 && ix.done < ix.total {
+		// Already reported.
+		return
+	}
+	ix.total += n
+	l.reportIndexing(ctx)
+}
+
+// packageIndexed reports that a queued package was indexed.
+func (l *LSP) packageIndexed(ctx context.Context) {
+	ix := &l.indexing
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.done++
+	l.reportIndexing(ctx)
+}
+
+// reportIndexing sends the state of the indexing. l.indexing.mu must be held.
+func (l *LSP) reportIndexing(ctx context.Context) {
+	ix := &l.indexing
+	if ix.done < ix.total {
+		msg := fmt.Sprintf("parsing %d/%d packages", ix.done, ix.total)
+		ix.progress.report(msg, uint32(100*ix.done/ix.total))
+		l.conn.Notify(ctx, methodIndexingStatus, indexingStatus{State: "indexing", Message: msg, Done: ix.done, Total: ix.total})
+		return
+	}
+	if ix.progress != nil {
+		ix.progress.end("done")
+		ix.progress = nil
+	}
+	ix.done, ix.total = 0, 0
+	l.conn.Notify(ctx, methodIndexingStatus, indexingStatus{State: "idle", Message: "idle"})
+}
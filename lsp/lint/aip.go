@@ -0,0 +1,179 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// method is a method of a service, along with its request and response
+// messages, if they are declared in the package.
+type method struct {
+	file string
+	name *ast.Ident
+	req  *ast.Ident
+	resp *ast.Ident
+}
+
+// findMethods returns all the methods of all services in a package.
+func findMethods(pkg *loader.GunkPackage) []method {
+	var methods []method
+	one := func(fields *ast.FieldList) *ast.Ident {
+		if fields == nil || len(fields.List) != 1 {
+			return nil
+		}
+		return localType(pkg, fields.List[0].Type)
+	}
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				it, ok := spec.(*ast.TypeSpec).Type.(*ast.InterfaceType)
+				if !ok {
+					continue
+				}
+				for _, m := range it.Methods.List {
+					ft, ok := m.Type.(*ast.FuncType)
+					if !ok || len(m.Names) != 1 {
+						continue
+					}
+					methods = append(methods, method{
+						file: file,
+						name: m.Names[0],
+						req:  one(ft.Params),
+						resp: one(ft.Results),
+					})
+				}
+			}
+		}
+	}
+	return methods
+}
+
+// structOf returns the struct type of the message ident refers to.
+func structOf(pkg *loader.GunkPackage, ident *ast.Ident) *types.Struct {
+	if ident == nil || pkg.Types == nil {
+		return nil
+	}
+	obj := pkg.Types.Scope().Lookup(ident.Name)
+	if obj == nil {
+		return nil
+	}
+	st, _ := obj.Type().Underlying().(*types.Struct)
+	return st
+}
+
+// hasField reports whether st has a field with the given snake case name,
+// and if typ is not empty, whether the field is of a message named typ.
+func hasField(st *types.Struct, name, typ string) bool {
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if name != "" && !strings.EqualFold(strings.ReplaceAll(name, "_", ""), f.Name()) {
+			continue
+		}
+		if typ == "" {
+			return true
+		}
+		if named, ok := f.Type().(*types.Named); ok && named.Obj().Name() == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// aipMethod checks that standard methods follow the shapes of the API
+// Improvement Proposals: Get and Delete take the resource name, Create and
+// Update take the resource, Update takes an update mask, Get returns the
+// resource and List returns a list of resources.
+func aipMethod(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for _, m := range findMethods(pkg) {
+		report := func(n ast.Node, msg string) {
+			diagnostics[m.file] = append(diagnostics[m.file], lintWarning(m.file, fset, n, msg, "aipmethod"))
+		}
+		var verb string
+		for _, v := range []string{"Get", "List", "Create", "Update", "Delete"} {
+			if hasNamePrefix(m.name.Name, v) {
+				verb = v
+				break
+			}
+		}
+		if verb == "" {
+			continue
+		}
+		resource := strings.TrimPrefix(m.name.Name, verb)
+		req, resp := structOf(pkg, m.req), structOf(pkg, m.resp)
+		switch verb {
+		case "Get", "Delete":
+			if req != nil && !hasField(req, "name", "") {
+				report(m.req, "request of "+m.name.Name+" should have a name field")
+			}
+			if verb == "Get" && m.resp != nil && m.resp.Name != resource {
+				report(m.resp, m.name.Name+" should return the resource "+resource)
+			}
+		case "Create", "Update":
+			if req != nil && !hasField(req, "", resource) {
+				report(m.req, "request of "+m.name.Name+" should have a field of type "+resource)
+			}
+			if verb == "Update" && req != nil && !hasField(req, "update_mask", "") {
+				report(m.req, "request of "+m.name.Name+" should have an update_mask field")
+			}
+		case "List":
+			if resp == nil {
+				break
+			}
+			repeated := false
+			for i := 0; i < resp.NumFields(); i++ {
+				if _, ok := resp.Field(i).Type().(*types.Slice); ok {
+					repeated = true
+					break
+				}
+			}
+			if !repeated {
+				report(m.resp, "response of "+m.name.Name+" should have a repeated field of resources")
+			}
+		}
+	}
+	return diagnostics
+}
+
+// aipResource checks that resources, the messages returned by Get methods,
+// have a name field.
+func aipResource(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	resources := make(map[string]bool)
+	for _, m := range findMethods(pkg) {
+		if hasNamePrefix(m.name.Name, "Get") && m.resp != nil {
+			resources[m.resp.Name] = true
+		}
+	}
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if !resources[ts.Name.Name] {
+					continue
+				}
+				if st := structOf(pkg, ts.Name); st != nil && !hasField(st, "name", "") {
+					msg := "resource " + ts.Name.Name + " should have a name field"
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ts.Name, msg, "aipresource"))
+				}
+			}
+		}
+	}
+	return diagnostics
+}
@@ -10,7 +10,7 @@ import (
 	"go.lsp.dev/protocol"
 )
 
-func commentStart(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet) map[string][]protocol.Diagnostic {
+func commentStart(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
 	diagnostics := make(map[string][]protocol.Diagnostic)
 	for i, f := range pkg.GunkSyntax {
 		file := pkg.GunkFiles[i]
@@ -73,3 +73,53 @@ func toFirstWord(n *ast.Comment) ast.Node {
 		end: n.Slash + token.Pos(strings.IndexRune(str, ' ')+missing),
 	}
 }
+
+// commentPeriod checks that the documentation of types and fields is made of
+// full sentences, ending with a period. Gunk tags are not part of the
+// documentation, and are ignored.
+func commentPeriod(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			var doc *ast.CommentGroup
+			switch v := n.(type) {
+			default:
+				return false
+			case *ast.GenDecl, *ast.StructType, *ast.InterfaceType, *ast.FieldList, *ast.File:
+				return true
+			case *ast.TypeSpec:
+				doc = v.Doc
+			case *ast.Field:
+				doc = v.Doc
+			}
+			if doc == nil {
+				return true
+			}
+			text, _, err := loader.SplitGunkTag(nil, fset, doc)
+			if err != nil || text == "" {
+				return true
+			}
+			if !strings.ContainsAny(text[len(text)-1:], ".!?") {
+				diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, lastDocLine(doc), "comment should end with a period", "commentperiod"))
+			}
+			return true
+		})
+	}
+	return diagnostics
+}
+
+// lastDocLine returns the last comment line of a group that is part of the
+// documentation, before any gunk tags.
+func lastDocLine(doc *ast.CommentGroup) *ast.Comment {
+	last := doc.List[0]
+	for _, c := range doc.List {
+		if strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(c.Text, "//")), "+gunk") {
+			break
+		}
+		if strings.TrimSpace(strings.TrimPrefix(c.Text, "//")) != "" {
+			last = c
+		}
+	}
+	return last
+}
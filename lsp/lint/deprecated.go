@@ -0,0 +1,92 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// deprecated reports uses of messages, enums and enum values that are
+// marked as deprecated, in the package or in the packages it imports.
+func deprecated(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	if pkg.TypesInfo == nil {
+		return diagnostics
+	}
+	notes := make(map[types.Object]string)
+	decls := make(map[types.Object]ast.Node)
+	collect := func(syntax []*ast.File, info *types.Info) {
+		if info == nil {
+			return
+		}
+		for _, f := range syntax {
+			for _, decl := range f.Decls {
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok {
+					continue
+				}
+				for _, spec := range gd.Specs {
+					var doc *ast.CommentGroup
+					var names []*ast.Ident
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						doc, names = spec.Doc, []*ast.Ident{spec.Name}
+					case *ast.ValueSpec:
+						doc, names = spec.Doc, spec.Names
+					default:
+						continue
+					}
+					if doc == nil && len(gd.Specs) == 1 {
+						doc = gd.Doc
+					}
+					note, ok := loader.Deprecation(doc)
+					if !ok {
+						continue
+					}
+					for _, name := range names {
+						if obj := info.Defs[name]; obj != nil {
+							notes[obj] = note
+							decls[obj] = spec
+						}
+					}
+				}
+			}
+		}
+	}
+	collect(pkg.GunkSyntax, pkg.TypesInfo)
+	for _, imp := range pkg.Imports {
+		collect(imp.GunkSyntax, imp.TypesInfo)
+	}
+	if len(notes) == 0 {
+		return diagnostics
+	}
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			ident, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			obj := pkg.TypesInfo.Uses[ident]
+			note, ok := notes[obj]
+			if !ok {
+				return true
+			}
+			// Uses within the deprecated declaration itself are fine.
+			if d := decls[obj]; ident.Pos() >= d.Pos() && ident.End() <= d.End() {
+				return true
+			}
+			msg := obj.Name() + " is deprecated"
+			if note != "" {
+				msg += ": " + note
+			}
+			diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ident, msg, "deprecated"))
+			return true
+		})
+	}
+	return diagnostics
+}
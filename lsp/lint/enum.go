@@ -0,0 +1,124 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// enum is an enum declared in a gunk package, along with its values.
+type enum struct {
+	file   string
+	name   *ast.Ident
+	typ    *types.Named
+	values []enumValue
+}
+
+type enumValue struct {
+	file  string
+	name  *ast.Ident
+	value constant.Value
+}
+
+// findEnums returns all enums declared in a package, in declaration order.
+// It requires the package to be type checked.
+func findEnums(pkg *loader.GunkPackage) []*enum {
+	if pkg.TypesInfo == nil {
+		return nil
+	}
+	var enums []*enum
+	byType := make(map[*types.Named]*enum)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				obj, ok := pkg.TypesInfo.Defs[ts.Name].(*types.TypeName)
+				if !ok {
+					continue
+				}
+				named, ok := obj.Type().(*types.Named)
+				if !ok {
+					continue
+				}
+				basic, ok := named.Underlying().(*types.Basic)
+				if !ok || basic.Info()&types.IsInteger == 0 {
+					continue
+				}
+				e := &enum{file: file, name: ts.Name, typ: named}
+				enums = append(enums, e)
+				byType[named] = e
+			}
+		}
+	}
+	// Values can be declared in a different file to their type, so they
+	// are only collected once all types are known.
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					obj, ok := pkg.TypesInfo.Defs[name].(*types.Const)
+					if !ok {
+						continue
+					}
+					named, ok := obj.Type().(*types.Named)
+					if !ok || byType[named] == nil {
+						continue
+					}
+					e := byType[named]
+					e.values = append(e.values, enumValue{
+						file:  file,
+						name:  name,
+						value: obj.Val(),
+					})
+				}
+			}
+		}
+	}
+	return enums
+}
+
+// enumZero checks that every enum has a zero value, and optionally that the
+// zero value is named with the configured suffix, such as "Unspecified".
+func enumZero(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for _, e := range findEnums(pkg) {
+		var zero *enumValue
+		for i, v := range e.values {
+			if constant.Sign(v.value) == 0 {
+				zero = &e.values[i]
+				break
+			}
+		}
+		if zero == nil {
+			msg := "enum " + e.name.Name + " has no zero value"
+			diagnostics[e.file] = append(diagnostics[e.file], lintWarning(e.file, fset, e.name, msg, "enumzero"))
+			continue
+		}
+		suffix := cfg.EnumZeroSuffix
+		if suffix == "" {
+			continue
+		}
+		name := strings.ReplaceAll(strings.ToLower(zero.name.Name), "_", "")
+		if !strings.HasSuffix(name, strings.ReplaceAll(strings.ToLower(suffix), "_", "")) {
+			msg := "zero value of enum " + e.name.Name + " should end with '" + suffix + "'"
+			diagnostics[zero.file] = append(diagnostics[zero.file], lintWarning(zero.file, fset, zero.name, msg, "enumzero"))
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,64 @@
+package lint
+
+import (
+	"context"
+	"go/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// enumPrefix checks that every enum value is prefixed with the name of its
+// enum type, and that the identifier generated for it doesn't collide with
+// one of a value of another enum in the package.
+func enumPrefix(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	enums := findEnums(pkg)
+	// The generated Go code names values after their enum, such as
+	// Status_StatusActive, so that A.B_C and A_B.C both become A_B_C.
+	owners := make(map[string][]*enum)
+	for _, e := range enums {
+		for _, v := range e.values {
+			ident := generatedValueName(e, v)
+			owners[ident] = append(owners[ident], e)
+		}
+	}
+	for _, e := range enums {
+		for _, v := range e.values {
+			var msg string
+			if !hasNamePrefix(v.name.Name, e.name.Name) {
+				msg = "enum value " + v.name.Name + " should be prefixed with '" + e.name.Name + "'"
+			}
+			ident := generatedValueName(e, v)
+			for _, o := range owners[ident] {
+				if o != e {
+					msg = "enum value " + v.name.Name + " of " + e.name.Name + " collides with a value of enum " + o.name.Name + " as " + ident + " in the generated code"
+					break
+				}
+			}
+			if msg != "" {
+				diagnostics[v.file] = append(diagnostics[v.file], lintWarning(v.file, fset, v.name, msg, "enumprefix"))
+			}
+		}
+	}
+	return diagnostics
+}
+
+// generatedValueName returns the identifier of an enum value in the generated
+// Go code.
+func generatedValueName(e *enum, v enumValue) string {
+	return e.name.Name + "_" + v.name.Name
+}
+
+// hasNamePrefix reports whether name starts with prefix as a whole word, such
+// as StatusActive or Status_ACTIVE for Status, but not Statusactive.
+func hasNamePrefix(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return r == '_' || unicode.IsUpper(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,70 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"github.com/kenshaw/snaker"
+	"go.lsp.dev/protocol"
+)
+
+// fieldName checks that struct fields are exported, don't contain
+// underscores, and use the configured initialisms.
+func fieldName(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	ini := initialisms(cfg, pkg.Dir)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch v := n.(type) {
+			default:
+				return false
+			case *ast.File, *ast.GenDecl, *ast.TypeSpec, *ast.StructType, *ast.FieldList:
+				return true
+			case *ast.Field:
+				for _, name := range v.Names {
+					var msg string
+					switch {
+					case !ast.IsExported(name.Name):
+						msg = "field " + name.Name + " should be exported"
+					case strings.Contains(name.Name, "_"):
+						msg = "field " + name.Name + " should not contain underscores"
+					default:
+						if want := fixInitialisms(ini, name.Name); want != name.Name {
+							msg = "field " + name.Name + " should be " + want
+						}
+					}
+					if msg != "" {
+						diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, name, msg, "fieldname"))
+					}
+				}
+				// Walk nested structs.
+				return true
+			}
+		})
+	}
+	return diagnostics
+}
+
+// titleWord matches a capitalised word in a CamelCase name, such as "Id" in
+// "UserId".
+var titleWord = regexp.MustCompile(`[A-Z][a-z0-9]+`)
+
+// fixInitialisms returns name with all capitalised words that are
+// initialisms converted to upper case, such as UserID for UserId. A plural
+// "s" is kept lower case, as in UserIDs.
+func fixInitialisms(ini *snaker.Initialisms, name string) string {
+	return titleWord.ReplaceAllStringFunc(name, func(w string) string {
+		if ini.IsInitialism(w) {
+			return strings.ToUpper(w)
+		}
+		if base := strings.TrimSuffix(w, "s"); len(base) > 1 && base != w && ini.IsInitialism(base) {
+			return strings.ToUpper(base) + "s"
+		}
+		return w
+	})
+}
@@ -0,0 +1,49 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// floatMoney checks for floating point fields whose names suggest they hold
+// currency or exact quantities, which should be stored as integer minor units
+// or a decimal message instead.
+func floatMoney(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			field, ok := n.(*ast.Field)
+			if !ok || !isFloat(field.Type) {
+				return true
+			}
+			for _, name := range field.Names {
+				lower := strings.ToLower(name.Name)
+				for _, word := range cfg.MoneyWords {
+					if !strings.Contains(lower, strings.ToLower(word)) {
+						continue
+					}
+					msg := "field " + name.Name + " looks like an exact amount; use integer minor units or a decimal message instead of a float"
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, field.Type, msg, "floatmoney"))
+					break
+				}
+			}
+			return true
+		})
+	}
+	return diagnostics
+}
+
+// isFloat reports whether expr is a float type, or a slice of them.
+func isFloat(expr ast.Expr) bool {
+	if arr, ok := expr.(*ast.ArrayType); ok {
+		expr = arr.Elt
+	}
+	ident, ok := expr.(*ast.Ident)
+	return ok && (ident.Name == "float32" || ident.Name == "float64")
+}
@@ -0,0 +1,79 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// jsonName checks that json tags are the snake case form of the field name
+// that the formatter would produce, and that no two fields in a message
+// generate the same protobuf JSON name.
+func jsonName(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	ini := initialisms(cfg, pkg.Dir)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			st, ok := n.(*ast.StructType)
+			if !ok || st.Fields == nil {
+				return true
+			}
+			seen := make(map[string]string)
+			for _, field := range st.Fields.List {
+				if field.Tag == nil || len(field.Names) != 1 {
+					continue
+				}
+				tag, err := strconv.Unquote(field.Tag.Value)
+				if err != nil {
+					continue
+				}
+				json, ok := reflect.StructTag(tag).Lookup("json")
+				if !ok || json == "" {
+					continue
+				}
+				if want := ini.CamelToSnake(field.Names[0].Name); json != want {
+					msg := "json tag " + strconv.Quote(json) + " should be " + strconv.Quote(want)
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, field.Tag, msg, "jsonname"))
+				}
+				// Exact duplicates are reported by validation.
+				camel := protoJSONName(json)
+				if other, ok := seen[camel]; ok && other != json {
+					msg := "json tag " + strconv.Quote(json) + " generates the same JSON name " +
+						strconv.Quote(camel) + " as " + strconv.Quote(other)
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, field.Tag, msg, "jsonname"))
+					continue
+				}
+				seen[camel] = json
+			}
+			return true
+		})
+	}
+	return diagnostics
+}
+
+// protoJSONName returns the JSON name protoc generates for a field name, by
+// removing underscores and capitalising the letter after them.
+func protoJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// reservedNames are the names that collide with keywords or generated
+// identifiers, per target. Names are matched case-insensitively for the proto
+// target, and exactly otherwise.
+var reservedNames = map[string]struct {
+	// messages are reserved message names.
+	messages []string
+	// fields are reserved field names.
+	fields []string
+}{
+	"proto": {
+		messages: protoKeywords,
+		fields:   protoKeywords,
+	},
+	"go": {
+		// Generated Go messages have these methods, and their fields
+		// generate getters which would collide with them.
+		fields: []string{"Descriptor", "ProtoMessage", "ProtoReflect", "Reset", "String"},
+	},
+	"java": {
+		messages: []string{"Class", "Deprecated", "Object", "Override", "String"},
+		fields:   []string{"Class"},
+	},
+}
+
+// protoKeywords are the protobuf keywords and scalar types. Keywords only
+// used inside ranges, such as "to" and "max", are left out.
+var protoKeywords = []string{
+	"bool", "bytes", "double", "enum", "extend", "extensions", "false",
+	"fixed32", "fixed64", "float", "import", "int32", "int64", "map",
+	"message", "oneof", "option", "optional", "package", "public",
+	"repeated", "required", "reserved", "returns", "rpc", "service",
+	"sfixed32", "sfixed64", "sint32", "sint64", "stream", "string",
+	"syntax", "true", "uint32", "uint64", "weak",
+}
+
+// keyword checks that message and field names don't collide with protobuf
+// keywords, or identifiers generated for the configured targets. It is off
+// by default, as protoc accepts most keywords as names and common names such
+// as Message or String would be reported.
+func keyword(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	check := func(file string, ident *ast.Ident, field bool) {
+		for _, target := range cfg.KeywordTargets {
+			names := reservedNames[target].messages
+			if field {
+				names = reservedNames[target].fields
+			}
+			for _, name := range names {
+				match := ident.Name == name
+				if target == "proto" {
+					match = strings.EqualFold(ident.Name, name)
+				}
+				if match {
+					msg := ident.Name + " collides with a reserved name in " + target
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ident, msg, "keyword"))
+					return
+				}
+			}
+		}
+	}
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch v := n.(type) {
+			default:
+				return false
+			case *ast.File, *ast.GenDecl, *ast.StructType, *ast.FieldList:
+				return true
+			case *ast.TypeSpec:
+				if _, ok := v.Type.(*ast.StructType); ok {
+					check(file, v.Name, false)
+				}
+				return true
+			case *ast.Field:
+				for _, name := range v.Names {
+					check(file, name, true)
+				}
+				return true
+			}
+		})
+	}
+	return diagnostics
+}
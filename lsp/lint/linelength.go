@@ -0,0 +1,60 @@
+package lint
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// lineLength checks that no line of documentation or declarations is longer
+// than the configured maximum. Imports are not checked, as they can't be
+// wrapped.
+func lineLength(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	if cfg.MaxLineLength <= 0 || cfg.readFile == nil {
+		return diagnostics
+	}
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		src, err := cfg.readFile(file)
+		if err != nil {
+			continue
+		}
+		tf := fset.File(f.Pos())
+		if tf == nil || tf.Size() != len(src) {
+			// The file changed since it was parsed.
+			continue
+		}
+		imports := make(map[int]bool)
+		for _, decl := range f.Decls {
+			if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+				for l := tf.Line(gd.Pos()); l <= tf.Line(gd.End()); l++ {
+					imports[l] = true
+				}
+			}
+		}
+		offset := 0
+		for n, line := range strings.Split(string(src), "\n") {
+			start := offset
+			offset += len(line) + 1
+			line = strings.TrimSuffix(line, "\r")
+			length := utf8.RuneCountInString(line)
+			if length <= cfg.MaxLineLength || imports[n+1] {
+				continue
+			}
+			msg := fmt.Sprintf("line is %d characters long, longer than %d", length, cfg.MaxLineLength)
+			d := lintWarning(file, fset, node{
+				pos: tf.Pos(start),
+				end: tf.Pos(start + len(line)),
+			}, msg, "linelength")
+			diagnostics[file] = append(diagnostics[file], d)
+		}
+	}
+	return diagnostics
+}
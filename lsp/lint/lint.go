@@ -4,20 +4,238 @@ import (
 	"context"
 	"go/ast"
 	"go/token"
+	"go/types"
+	"strings"
 
+	"github.com/gunk/gunk/config"
 	"github.com/gunk/gunkls/lsp/loader"
+	"github.com/kenshaw/snaker"
 	"go.lsp.dev/protocol"
 )
 
-func LintPkg(ctx context.Context, pkg *loader.GunkPackage, loader *loader.Loader) map[string][]protocol.Diagnostic {
+// Config configures the linter.
+type Config struct {
+	// Enabled is whether linting is run at all.
+	Enabled bool `json:"enabled"`
+	// Rules enables or disables individual rules, or groups of rules, by
+	// name. A setting for a rule takes precedence over a setting for its
+	// group. Rules not present use their default.
+	Rules map[string]bool `json:"rules"`
+
+	// EnumZeroSuffix is the suffix the zero value of every enum must be
+	// named with, such as "Unspecified". If empty, only the existence of a
+	// zero value is checked.
+	EnumZeroSuffix string `json:"enumZeroSuffix"`
+	// ServiceSuffix is the suffix all service interfaces must be named with.
+	ServiceSuffix string `json:"serviceSuffix"`
+	// RequestSuffix and ResponseSuffix are the suffixes appended to a
+	// method's name to name its request and response messages.
+	RequestSuffix  string `json:"requestSuffix"`
+	ResponseSuffix string `json:"responseSuffix"`
+	// KeywordTargets are the targets checked for reserved names, any of
+	// "proto", "go" and "java".
+	KeywordTargets []string `json:"keywordTargets"`
+	// MoneyWords are the words in field names that suggest the field holds
+	// currency or an exact quantity.
+	MoneyWords []string `json:"moneyWords"`
+	// PageSizeField, PageTokenField and NextPageTokenField are the snake
+	// case names of the fields List methods use for pagination. An empty
+	// name is not checked.
+	PageSizeField      string `json:"pageSizeField"`
+	PageTokenField     string `json:"pageTokenField"`
+	NextPageTokenField string `json:"nextPageTokenField"`
+	// MaxLineLength is the maximum length of a line, in characters.
+	MaxLineLength int `json:"maxLineLength"`
+
+	// refs is the reference index of all loaded packages, set by LintPkg
+	// for the unused rule, which needs to know about uses in other
+	// packages. refsComplete is set if all the packages importing the
+	// linted package are type checked, so that the index has their uses.
+	refs         loader.References
+	refsComplete bool
+	// readFile returns the current contents of a file, set by LintPkg.
+	readFile func(path string) ([]byte, error)
+	// gunkConfig returns the gunk configuration of a directory, set by
+	// LintPkg.
+	gunkConfig func(dir string) (*config.Config, error)
+}
+
+// DefaultConfig returns the default configuration of the linter.
+func DefaultConfig() Config {
+	return Config{
+		ServiceSuffix:  "Service",
+		RequestSuffix:  "Request",
+		ResponseSuffix: "Response",
+		KeywordTargets: []string{"proto", "go"},
+		MoneyWords:     []string{"amount", "balance", "cost", "fee", "price", "quantity", "total"},
+
+		PageSizeField:      "page_size",
+		PageTokenField:     "page_token",
+		NextPageTokenField: "next_page_token",
+
+		MaxLineLength: 100,
+	}
+}
+
+// ruleFunc is a lint rule, returning the diagnostics found in a package,
+// grouped by file.
+type ruleFunc func(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic
+
+type rule struct {
+	name  string
+	group string
+	// def is whether the rule is run when not explicitly configured.
+	def bool
+	run ruleFunc
+}
+
+// rules is the list of all lint rules, in the order they are run.
+var rules = []rule{
+	{name: "commentstart", group: "comment", def: true, run: commentStart},
+	{name: "commentperiod", group: "comment", def: false, run: commentPeriod},
+	{name: "enumzero", group: "enum", def: true, run: enumZero},
+	{name: "enumprefix", group: "enum", def: false, run: enumPrefix},
+	{name: "servicename", group: "naming", def: true, run: serviceName},
+	{name: "messagename", group: "naming", def: true, run: messageName},
+	{name: "fieldname", group: "naming", def: true, run: fieldName},
+	{name: "jsonname", group: "naming", def: true, run: jsonName},
+	{name: "pkgname", group: "naming", def: true, run: pkgName},
+	{name: "keyword", group: "naming", def: false, run: keyword},
+	{name: "wellknown", group: "naming", def: true, run: wellKnown},
+	{name: "floatmoney", group: "design", def: true, run: floatMoney},
+	{name: "pagination", group: "design", def: false, run: pagination},
+	{name: "aipmethod", group: "aip", def: false, run: aipMethod},
+	{name: "aipresource", group: "aip", def: false, run: aipResource},
+	{name: "todo", group: "todo", def: false, run: todo},
+	{name: "linelength", group: "style", def: false, run: lineLength},
+	{name: "deprecated", group: "deprecated", def: true, run: deprecated},
+	{name: "pbnumber", group: "pb", def: false, run: pbNumber},
+	{name: "pbmissing", group: "pb", def: false, run: pbMissing},
+	{name: "unused", group: "unused", def: false, run: unused},
+}
+
+// enabled reports whether the rule should be run for the given config.
+func (c Config) enabled(r rule) bool {
+	if v, ok := c.Rules[r.name]; ok {
+		return v
+	}
+	if v, ok := c.Rules[r.group]; ok {
+		return v
+	}
+	return r.def
+}
+
+// References is the reference index of the packages of an analysis pass,
+// which LintPkg builds when a rule first needs it, and shares across the
+// packages of the pass. The packages type checked since it was last used are
+// added to it.
+type References struct {
+	refs    loader.References
+	indexed map[*types.Info]bool
+}
+
+// NewReferences returns an empty reference index, for a new analysis pass.
+func NewReferences() *References {
+	return &References{refs: make(loader.References), indexed: make(map[*types.Info]bool)}
+}
+
+// update adds the packages of pkgs type checked since the last call to the
+// index.
+func (r *References) update(fset *token.FileSet, pkgs []*loader.GunkPackage) loader.References {
+	for _, pkg := range pkgs {
+		if pkg.TypesInfo != nil && !r.indexed[pkg.TypesInfo] {
+			r.indexed[pkg.TypesInfo] = true
+			r.refs.Add(fset, pkg)
+		}
+	}
+	return r.refs
+}
+
+// LintPkg runs all enabled lint rules on a package. pkgs are all loaded
+// packages, used by rules that look at how the package is used, through refs,
+// the reference index of the analysis pass.
+func LintPkg(ctx context.Context, pkg *loader.GunkPackage, pkgs []*loader.GunkPackage, refs *References, l *loader.Loader, cfg Config) map[string][]protocol.Diagnostic {
 	diagnostics := make(map[string][]protocol.Diagnostic)
-	// commentstart
-	for k, v := range commentStart(ctx, pkg, loader.Fset) {
-		diagnostics[k] = append(diagnostics[k], v...)
+	if !cfg.Enabled {
+		return diagnostics
+	}
+	cfg.readFile = l.ReadFile
+	cfg.gunkConfig = l.Config
+	for _, r := range rules {
+		if ctx.Err() != nil {
+			return diagnostics
+		}
+		if !cfg.enabled(r) {
+			continue
+		}
+		if r.name == "unused" {
+			// Indexing the references of all packages is only
+			// worth it for the rule using them.
+			cfg.refs = refs.update(l.Fset, pkgs)
+			cfg.refsComplete = importersChecked(l, pkg, pkgs)
+		}
+		for k, v := range r.run(ctx, pkg, l.Fset, cfg) {
+			diagnostics[k] = append(diagnostics[k], v...)
+		}
 	}
 	return diagnostics
 }
 
+// importersChecked reports whether all the packages directly or indirectly
+// importing pkg are type checked against its current contents. The importers
+// that aren't, such as the ones not analyzed yet, have no uses in the index.
+func importersChecked(l *loader.Loader, pkg *loader.GunkPackage, pkgs []*loader.GunkPackage) bool {
+	importers := l.Importers(pkg.PkgPath)
+	for _, p := range pkgs {
+		if importers[p.PkgPath] && (p.Types == nil || p.TypesInfo == nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// initialisms returns the default initialisms, along with the ones
+// configured in the .gunkconfig of dir, if any.
+func initialisms(cfg Config, dir string) *snaker.Initialisms {
+	ini := snaker.NewDefaultInitialisms()
+	if cfg.gunkConfig == nil {
+		return ini
+	}
+	gunkCfg, err := cfg.gunkConfig(dir)
+	if err != nil {
+		return ini
+	}
+	// Invalid initialisms are reported by the formatter.
+	ini.Add(gunkCfg.Format.Initialisms...)
+	return ini
+}
+
+// ignored reports whether the documentation of a type declaration contains a
+// "gunkls:ignore" directive for the rule, such as:
+//
+//	//gunkls:ignore pbnumber
+func ignored(gd *ast.GenDecl, ts *ast.TypeSpec, rule string) bool {
+	for _, doc := range []*ast.CommentGroup{gd.Doc, ts.Doc} {
+		if doc == nil {
+			continue
+		}
+		for _, c := range doc.List {
+			// Directives are not included in CommentGroup.Text, so look
+			// at the raw comments.
+			text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+			if !strings.HasPrefix(text, "gunkls:ignore") {
+				continue
+			}
+			for _, r := range strings.Fields(strings.TrimPrefix(text, "gunkls:ignore")) {
+				if r == rule {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 type node struct {
 	pos token.Pos
 	end token.Pos
@@ -46,7 +264,7 @@ func lintWarning(file string, fset *token.FileSet, node ast.Node, msg string, co
 			},
 		},
 		Severity: 2,
-		Source:   "gunkls",
+		Source:   loader.DiagnosticSource,
 		Message:  msg,
 		Code:     code,
 	}
@@ -0,0 +1,93 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// RenameFix is attached as the data of diagnostics that can be fixed by
+// renaming a type declared in the package.
+type RenameFix struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// messageName checks that the request and response messages of a method are
+// named after the method, such as GetUserRequest and GetUserResponse for
+// GetUser. Messages used by several methods, or as both a request and a
+// response, can't be named after each of them, and are left alone.
+func messageName(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	uses := make(map[string]int)
+	inspectMethods(pkg, func(file, method string, ft *ast.FuncType) {
+		for _, fields := range []*ast.FieldList{ft.Params, ft.Results} {
+			if fields == nil || len(fields.List) != 1 {
+				continue
+			}
+			if ident := localType(pkg, fields.List[0].Type); ident != nil {
+				uses[ident.Name]++
+			}
+		}
+	})
+	inspectMethods(pkg, func(file, method string, ft *ast.FuncType) {
+		check := func(fields *ast.FieldList, suffix, kind string) {
+			if suffix == "" || fields == nil || len(fields.List) != 1 {
+				return
+			}
+			ident := localType(pkg, fields.List[0].Type)
+			if ident == nil || ident.Name == method+suffix || uses[ident.Name] > 1 {
+				return
+			}
+			want := method + suffix
+			msg := kind + " of " + method + " should be named " + want
+			d := lintWarning(file, fset, ident, msg, "messagename")
+			d.Data = RenameFix{From: ident.Name, To: want}
+			diagnostics[file] = append(diagnostics[file], d)
+		}
+		check(ft.Params, cfg.RequestSuffix, "request")
+		check(ft.Results, cfg.ResponseSuffix, "response")
+	})
+	return diagnostics
+}
+
+// inspectMethods calls fn with the name and signature of each method of the
+// services of a package, and the file it is in.
+func inspectMethods(pkg *loader.GunkPackage, fn func(file, method string, ft *ast.FuncType)) {
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch v := n.(type) {
+			default:
+				return false
+			case *ast.File, *ast.GenDecl, *ast.TypeSpec, *ast.InterfaceType, *ast.FieldList:
+				return true
+			case *ast.Field:
+				if ft, ok := v.Type.(*ast.FuncType); ok && len(v.Names) == 1 {
+					fn(file, v.Names[0].Name, ft)
+				}
+				return false
+			}
+		})
+	}
+}
+
+// localType returns the identifier of a message type declared in the package
+// that expr refers to, looking through streams. It returns nil if expr is not
+// such a type.
+func localType(pkg *loader.GunkPackage, expr ast.Expr) *ast.Ident {
+	if ch, ok := expr.(*ast.ChanType); ok {
+		expr = ch.Value
+	}
+	ident, ok := expr.(*ast.Ident)
+	if !ok || pkg.Types == nil {
+		return nil
+	}
+	if obj := pkg.Types.Scope().Lookup(ident.Name); obj == nil {
+		return nil
+	}
+	return ident
+}
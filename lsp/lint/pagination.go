@@ -0,0 +1,86 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"github.com/kenshaw/snaker"
+	"go.lsp.dev/protocol"
+)
+
+// pagination checks that List methods take a page size and page token in
+// their request, and return the next page token in their response.
+func pagination(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	ini := initialisms(cfg, pkg.Dir)
+	check := func(file string, fields *ast.FieldList, want []string, kind, method string) {
+		if fields == nil || len(fields.List) != 1 {
+			return
+		}
+		ident := localType(pkg, fields.List[0].Type)
+		if ident == nil {
+			return
+		}
+		names, ok := fieldNames(pkg, ini, ident)
+		if !ok {
+			return
+		}
+		var missing []string
+		for _, name := range want {
+			if name != "" && !names[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			msg := kind + " of " + method + " should have the pagination fields " + strings.Join(missing, ", ")
+			diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ident, msg, "pagination"))
+		}
+	}
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch v := n.(type) {
+			default:
+				return false
+			case *ast.File, *ast.GenDecl, *ast.TypeSpec, *ast.InterfaceType, *ast.FieldList:
+				return true
+			case *ast.Field:
+				ft, ok := v.Type.(*ast.FuncType)
+				if !ok || len(v.Names) != 1 || !hasNamePrefix(v.Names[0].Name, "List") {
+					return false
+				}
+				method := v.Names[0].Name
+				check(file, ft.Params, []string{cfg.PageSizeField, cfg.PageTokenField}, "request", method)
+				check(file, ft.Results, []string{cfg.NextPageTokenField}, "response", method)
+				return false
+			}
+		})
+	}
+	return diagnostics
+}
+
+// fieldNames returns the snake case names and json tags of the fields of the
+// message ident refers to.
+func fieldNames(pkg *loader.GunkPackage, ini *snaker.Initialisms, ident *ast.Ident) (map[string]bool, bool) {
+	obj := pkg.Types.Scope().Lookup(ident.Name)
+	if obj == nil {
+		return nil, false
+	}
+	st, ok := obj.Type().Underlying().(*types.Struct)
+	if !ok {
+		return nil, false
+	}
+	names := make(map[string]bool, st.NumFields())
+	for i := 0; i < st.NumFields(); i++ {
+		names[ini.CamelToSnake(st.Field(i).Name())] = true
+		if json, ok := reflect.StructTag(st.Tag(i)).Lookup("json"); ok {
+			names[json] = true
+		}
+	}
+	return names, true
+}
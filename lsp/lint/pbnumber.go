@@ -0,0 +1,128 @@
+package lint
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// pbNumber checks that the pb numbers of a message are in declaration order,
+// and that there are no gaps between them. Messages with intentionally
+// reserved numbers can be excluded with a "gunkls:ignore pbnumber" comment.
+func pbNumber(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok || st.Fields == nil {
+					continue
+				}
+				if ignored(gd, ts, "pbnumber") {
+					continue
+				}
+				used := make(map[int]bool)
+				prev := 0
+				for _, field := range st.Fields.List {
+					num, ok := pbTag(field)
+					if !ok {
+						continue
+					}
+					if num < prev {
+						msg := fmt.Sprintf("pb number %d is declared after %d", num, prev)
+						diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, field.Tag, msg, "pbnumber"))
+					}
+					prev = num
+					used[num] = true
+				}
+				// Gaps are listed as ranges, as numbers can be far
+				// apart.
+				nums := make([]int, 0, len(used))
+				for n := range used {
+					nums = append(nums, n)
+				}
+				sort.Ints(nums)
+				var missing []string
+				next := 1
+				for _, n := range nums {
+					switch {
+					case n == next+1:
+						missing = append(missing, strconv.Itoa(next))
+					case n > next+1:
+						missing = append(missing, fmt.Sprintf("%d-%d", next, n-1))
+					}
+					next = n + 1
+				}
+				if len(missing) > 0 {
+					msg := "message " + ts.Name.Name + " has unused pb numbers " + strings.Join(missing, ", ")
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ts.Name, msg, "pbnumber"))
+				}
+			}
+		}
+	}
+	return diagnostics
+}
+
+// pbMissing reports fields without a pb number. It is meant for teams that
+// assign numbers by hand, and so format with the manualPB option.
+func pbMissing(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		ast.Inspect(f, func(n ast.Node) bool {
+			st, ok := n.(*ast.StructType)
+			if !ok || st.Fields == nil {
+				return true
+			}
+			for _, field := range st.Fields.List {
+				if len(field.Names) == 0 {
+					continue
+				}
+				if field.Tag != nil {
+					tag, err := strconv.Unquote(field.Tag.Value)
+					if _, ok := reflect.StructTag(tag).Lookup("pb"); err != nil || ok {
+						continue
+					}
+				}
+				msg := "field " + field.Names[0].Name + " has no pb number"
+				diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, field.Names[0], msg, "pbmissing"))
+			}
+			return true
+		})
+	}
+	return diagnostics
+}
+
+// pbTag returns the pb number in the tag of a field, if it has a valid one.
+func pbTag(field *ast.Field) (int, bool) {
+	if field.Tag == nil {
+		return 0, false
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return 0, false
+	}
+	pb, ok := reflect.StructTag(tag).Lookup("pb")
+	if !ok {
+		return 0, false
+	}
+	num, err := strconv.Atoi(pb)
+	if err != nil {
+		return 0, false
+	}
+	return num, true
+}
@@ -0,0 +1,48 @@
+package lint
+
+import (
+	"context"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// majorVersion matches the major version directory of a module path, such
+// as "v2".
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// pkgName checks that the package name matches the base name of the
+// directory the package is in.
+func pkgName(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	if pkg.Dir == "" {
+		return diagnostics
+	}
+	dir := filepath.Base(pkg.Dir)
+	if majorVersion.MatchString(dir) {
+		dir = filepath.Base(filepath.Dir(pkg.Dir))
+	}
+	want := normalizePkgName(dir)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		if normalizePkgName(f.Name.Name) == want {
+			continue
+		}
+		msg := "package name " + f.Name.Name + " does not match directory " + dir
+		diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, f.Name, msg, "pkgname"))
+	}
+	return diagnostics
+}
+
+// normalizePkgName removes the characters that are commonly dropped when
+// naming a package after its directory, such as in "go-foo" for "foo".
+func normalizePkgName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(name, "-go")
+	return strings.NewReplacer("-", "", "_", "", ".", "").Replace(name)
+}
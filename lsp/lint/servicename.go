@@ -0,0 +1,68 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// serviceName checks that services are named with the configured suffix and
+// that their methods are exported CamelCase names that don't repeat the name
+// of the service.
+func serviceName(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				it, ok := ts.Type.(*ast.InterfaceType)
+				if !ok {
+					continue
+				}
+				name := ts.Name.Name
+				if cfg.ServiceSuffix != "" && !strings.HasSuffix(name, cfg.ServiceSuffix) {
+					msg := "service " + name + " should end with '" + cfg.ServiceSuffix + "'"
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ts.Name, msg, "servicename"))
+				}
+				for _, m := range it.Methods.List {
+					if len(m.Names) != 1 {
+						continue
+					}
+					if msg := checkMethodName(name, cfg.ServiceSuffix, m.Names[0].Name); msg != "" {
+						diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, m.Names[0], msg, "servicename"))
+					}
+				}
+			}
+		}
+	}
+	return diagnostics
+}
+
+// checkMethodName returns a diagnostic message if the method name of a
+// service is badly named, or an empty string if it is fine.
+func checkMethodName(service, suffix, method string) string {
+	switch {
+	case !ast.IsExported(method):
+		return "method " + method + " should start with an uppercase letter"
+	case strings.Contains(method, "_"):
+		return "method " + method + " should be CamelCase without underscores"
+	case strings.Contains(method, service):
+		return "method " + method + " stutters; it should not repeat the service name " + service
+	}
+	// Also catch UserService.UserList, where the service name is repeated
+	// without its suffix.
+	if base := strings.TrimSuffix(service, suffix); base != service && base != "" &&
+		strings.HasPrefix(method, base) && len(method) > len(base) && ast.IsExported(method[len(base):]) {
+		return "method " + method + " stutters; it should not start with the service name " + base
+	}
+	return ""
+}
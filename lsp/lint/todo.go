@@ -0,0 +1,47 @@
+package lint
+
+import (
+	"context"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// todoMarker matches a TODO, FIXME or XXX marker in a comment, with an
+// optional author, such as "TODO(alice): add more fields".
+var todoMarker = regexp.MustCompile(`\b(TODO|FIXME|XXX)(?:\(([^)]*)\))?:?[ \t]*([^\n]*)`)
+
+// todo reports TODO, FIXME and XXX comments as hints, so that unfinished
+// work is visible.
+func todo(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, group := range f.Comments {
+			for _, c := range group.List {
+				for _, m := range todoMarker.FindAllStringSubmatchIndex(c.Text, -1) {
+					marker, author := c.Text[m[2]:m[3]], ""
+					if m[4] >= 0 {
+						author = c.Text[m[4]:m[5]]
+					}
+					text := strings.TrimSpace(strings.TrimSuffix(c.Text[m[6]:m[7]], "*/"))
+					msg := marker
+					if author != "" {
+						msg += " (" + author + ")"
+					}
+					if text != "" {
+						msg += ": " + text
+					}
+					n := node{pos: c.Slash + token.Pos(m[0]), end: c.Slash + token.Pos(m[1])}
+					d := lintWarning(file, fset, n, msg, "todo")
+					d.Severity = protocol.DiagnosticSeverityHint
+					diagnostics[file] = append(diagnostics[file], d)
+				}
+			}
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,72 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// unused reports messages and enums that are not referenced by any service,
+// message, or importing package that has been loaded. Nothing is reported
+// until all the importers of the package are type checked, as a type may only
+// be used by one of them.
+func unused(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	if pkg.TypesInfo == nil || cfg.refs == nil || !cfg.refsComplete {
+		return diagnostics
+	}
+	// The type of enum values declares the enum's values, it doesn't
+	// use the enum.
+	valueTypes := make(map[*ast.Ident]bool)
+	for _, f := range pkg.GunkSyntax {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				if ident, ok := spec.(*ast.ValueSpec).Type.(*ast.Ident); ok {
+					valueTypes[ident] = true
+				}
+			}
+		}
+	}
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if _, ok := ts.Type.(*ast.InterfaceType); ok {
+					// Services are the roots of the API.
+					continue
+				}
+				obj, ok := pkg.TypesInfo.Defs[ts.Name].(*types.TypeName)
+				if !ok || ignored(gd, ts, "unused") {
+					continue
+				}
+				used := false
+				for _, ref := range cfg.refs[obj] {
+					// Recursive messages don't count as a use either.
+					self := ref.Ident.Pos() >= ts.Pos() && ref.Ident.End() <= ts.End()
+					if !valueTypes[ref.Ident] && !self {
+						used = true
+						break
+					}
+				}
+				if !used {
+					msg := ts.Name.Name + " is unused"
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ts.Name, msg, "unused"))
+				}
+			}
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,59 @@
+package lint
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// wellKnownTypes are the protobuf well-known types, mapped to the package
+// they are declared in.
+var wellKnownTypes = map[string]string{
+	"Any":         "google.protobuf",
+	"BoolValue":   "google.protobuf",
+	"BytesValue":  "google.protobuf",
+	"DoubleValue": "google.protobuf",
+	"Duration":    "google.protobuf",
+	"Empty":       "google.protobuf",
+	"FieldMask":   "google.protobuf",
+	"FloatValue":  "google.protobuf",
+	"Int32Value":  "google.protobuf",
+	"Int64Value":  "google.protobuf",
+	"ListValue":   "google.protobuf",
+	"NullValue":   "google.protobuf",
+	"StringValue": "google.protobuf",
+	"Struct":      "google.protobuf",
+	"Timestamp":   "google.protobuf",
+	"UInt32Value": "google.protobuf",
+	"UInt64Value": "google.protobuf",
+	"Value":       "google.protobuf",
+}
+
+// wellKnown checks that no message or enum is named the same as a protobuf
+// well-known type, which makes generated imports ambiguous.
+func wellKnown(ctx context.Context, pkg *loader.GunkPackage, fset *token.FileSet, cfg Config) map[string][]protocol.Diagnostic {
+	diagnostics := make(map[string][]protocol.Diagnostic)
+	for i, f := range pkg.GunkSyntax {
+		file := pkg.GunkFiles[i]
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if _, ok := ts.Type.(*ast.InterfaceType); ok {
+					continue
+				}
+				if pkgName, ok := wellKnownTypes[ts.Name.Name]; ok {
+					msg := ts.Name.Name + " shadows the well-known type " + pkgName + "." + ts.Name.Name
+					diagnostics[file] = append(diagnostics[file], lintWarning(file, fset, ts.Name, msg, "wellknown"))
+				}
+			}
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,119 @@
+package loader
+
+import (
+	"go/token"
+	"go/types"
+	"sync"
+
+	"github.com/gunk/gunkls/lsp/metrics"
+)
+
+// Cache holds what doesn't change between loaders, so that it can be shared
+// by the loaders of all sessions of a server: the type information of the
+// standard library and of the annotation packages, and which directories of
+// module versions have Gunk files. It is safe for concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	stdTypes map[stdKey]*types.Package
+	optTypes map[optKey]optTypes
+	modules  *moduleCache
+}
+
+// optKey identifies an annotation package by its module version.
+type optKey struct {
+	version string
+	path    string
+}
+
+// optTypes is the type information of an annotation package, with the file
+// set its positions refer to. Gunk packages are type checked from source, so
+// it can only be used by loaders with the same file set.
+type optTypes struct {
+	fset *token.FileSet
+	tpkg *types.Package
+}
+
+// stdKey identifies a standard library package by the GOROOT it was loaded
+// from, as sessions may use different go commands.
+type stdKey struct {
+	goroot string
+	path   string
+}
+
+// NewCache returns an empty cache, with the module versions persisted in the
+// user's cache directory.
+func NewCache() *Cache {
+	return &Cache{
+		stdTypes: make(map[stdKey]*types.Package),
+		optTypes: make(map[optKey]optTypes),
+		modules:  loadModuleCache(),
+	}
+}
+
+// shared returns the cache of the loader, which has a cache of its own if it
+// isn't given one.
+func (l *Loader) shared() *Cache {
+	if l.Shared == nil {
+		l.Shared = NewCache()
+	}
+	return l.Shared
+}
+
+func (c *Cache) std(goroot, path string) (*types.Package, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tpkg, ok := c.stdTypes[stdKey{goroot, path}]
+	metrics.CacheLookup("stdTypes", ok)
+	return tpkg, ok
+}
+
+func (c *Cache) storeStd(goroot, path string, tpkg *types.Package) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stdTypes[stdKey{goroot, path}] = tpkg
+}
+
+// opt returns the type information of an annotation package of a module
+// version, if it was checked with fset.
+func (c *Cache) opt(fset *token.FileSet, version, path string) (*types.Package, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.optTypes[optKey{version, path}]
+	ok = ok && cached.fset == fset
+	metrics.CacheLookup("optTypes", ok)
+	return cached.tpkg, ok
+}
+
+// storeOpt records the type information of an annotation package of a module
+// version, replacing the one checked with another file set, if any.
+func (c *Cache) storeOpt(fset *token.FileSet, version, path string, tpkg *types.Package) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.optTypes[optKey{version, path}] = optTypes{fset: fset, tpkg: tpkg}
+}
+
+// lookupModule returns whether the directory of a module version has Gunk
+// files, if known.
+func (c *Cache) lookupModule(version, dir string) (anyGunk, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	anyGunk, ok = c.modules.lookup(version, dir)
+	metrics.CacheLookup("modules", ok)
+	return anyGunk, ok
+}
+
+// storeModule records whether the directory of a module version has Gunk
+// files. The module versions are only written by Save.
+func (c *Cache) storeModule(version, dir string, anyGunk bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modules.store(version, dir, anyGunk)
+}
+
+// Save writes the module versions to the user's cache directory, if they
+// changed since they were last loaded or saved.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.modules.save()
+}
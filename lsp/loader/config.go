@@ -0,0 +1,99 @@
+package loader
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+)
+
+// Config returns the gunk configuration of dir, which merges the .gunkconfig
+// files of dir and its parent directories. Configurations are cached until
+// ReloadConfig is called for one of the files they were loaded from.
+func (l *Loader) Config(dir string) (*config.Config, error) {
+	if l.Remote != nil {
+		return l.remoteConfig(dir)
+	}
+	if cfg, ok := l.configs[dir]; ok {
+		return cfg, nil
+	}
+	cfg, err := config.Load(dir)
+	if err != nil {
+		// Don't cache errors, so they are reported until fixed.
+		return nil, err
+	}
+	if l.configs == nil {
+		l.configs = make(map[string]*config.Config)
+	}
+	l.configs[dir] = cfg
+	return cfg, nil
+}
+
+// ReloadConfig drops the cached configurations affected by a change to the
+// .gunkconfig at path, and marks the tracked packages using it as dirty.
+func (l *Loader) ReloadConfig(pkgs []*GunkPackage, path string) {
+	dir := filepath.Dir(path)
+	for cached := range l.configs {
+		if InDir(dir, cached) {
+			delete(l.configs, cached)
+		}
+	}
+	for _, pkg := range pkgs {
+		if pkg.State == Untracked {
+			continue
+		}
+		for _, pkgDir := range pkg.Dirs {
+			if InDir(dir, pkgDir) {
+				pkg.State = Dirty
+				break
+			}
+		}
+	}
+}
+
+// InDir reports whether path is dir or is inside dir.
+func InDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Excluded reports whether dir, inside root, is excluded by one of patterns:
+// if one of the directories between root and dir, or a sequence of them,
+// matches a pattern with path.Match, such as "testdata" or "api/gen".
+// Patterns are slash-separated.
+func Excluded(root, dir string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || !InDir(root, dir) {
+		return false
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+	for _, pattern := range patterns {
+		n := strings.Count(strings.Trim(pattern, "/"), "/") + 1
+		for i := 0; i+n <= len(elems); i++ {
+			if ok, _ := path.Match(strings.Trim(pattern, "/"), strings.Join(elems[i:i+n], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excluded reports whether dir is excluded from the packages discovered in
+// the workspace, by l.Exclude or by an ignore file.
+func (l *Loader) excluded(dir string) bool {
+	if l.ignores == nil {
+		l.ignores = NewIgnores(l.Dir, l.fs().ReadFile)
+	}
+	return Excluded(l.Dir, dir, l.Exclude) || l.ignores.Ignored(dir)
+}
+
+// IsModule reports whether dir is the root of a Go module.
+func IsModule(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "go.mod"))
+	return err == nil
+}
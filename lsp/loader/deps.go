@@ -0,0 +1,343 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// setImports records the import paths of pkg in the reverse dependency
+// graph, replacing the ones recorded when it was last parsed.
+func (l *Loader) setImports(pkg *GunkPackage, paths []string) {
+	if l.imports == nil {
+		l.imports = make(map[string][]string)
+		l.importers = make(map[string]map[string]bool)
+	}
+	for _, path := range l.imports[pkg.PkgPath] {
+		delete(l.importers[path], pkg.PkgPath)
+	}
+	l.imports[pkg.PkgPath] = paths
+	for _, path := range paths {
+		if l.importers[path] == nil {
+			l.importers[path] = make(map[string]bool)
+		}
+		l.importers[path][pkg.PkgPath] = true
+	}
+}
+
+// ImportPaths returns the paths imported by the gunk files of pkgs, sorted.
+// Files that can't be parsed are skipped.
+func (l *Loader) ImportPaths(pkgs []*GunkPackage) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, pkg := range pkgs {
+		for _, file := range pkg.GunkFiles {
+			src, err := l.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			f, err := l.parseFile(file, src)
+			if err != nil {
+				continue
+			}
+			for _, spec := range f.Imports {
+				path, err := strconv.Unquote(spec.Path.Value)
+				if err != nil || seen[path] {
+					continue
+				}
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// invalidate marks the open packages directly or indirectly importing pkg as
+// dirty, so that their diagnostics are sent again, and drops their type
+// information so that they are type checked again when imported. Direct
+// importers are also updated to point to pkg. The importers of a package with
+// open files are marked as dirty even if they weren't analyzed yet, so that
+// the effects of edits are reported.
+func (l *Loader) invalidate(pkgs []*GunkPackage, pkg *GunkPackage) {
+	stale := l.Importers(pkg.PkgPath)
+	if len(stale) == 0 {
+		return
+	}
+	open := l.hasOpenFiles(pkg)
+	for path := range stale {
+		if cached := l.cache[path]; cached != nil {
+			cached.Types = nil
+		}
+	}
+	for _, p := range pkgs {
+		if !stale[p.PkgPath] {
+			continue
+		}
+		if _, ok := p.Imports[pkg.PkgPath]; ok {
+			p.Imports[pkg.PkgPath] = pkg.GunkPackage
+		}
+		p.Types = nil
+		if p.State == Open || open || l.AnalyzeWorkspace {
+			p.State = Dirty
+		}
+	}
+}
+
+// Importers returns the import paths of the packages directly or indirectly
+// importing the package at path, as recorded when they were parsed or
+// indexed.
+func (l *Loader) Importers(path string) map[string]bool {
+	importers := make(map[string]bool)
+	queue := []string{path}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		for importer := range l.importers[path] {
+			if !importers[importer] {
+				importers[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+	return importers
+}
+
+// changed marks a package affected by a change as dirty, if it was already
+// analyzed or all packages of the workspace are.
+func (l *Loader) changed(pkg *GunkPackage) {
+	if pkg.State != Untracked || l.AnalyzeWorkspace {
+		pkg.State = Dirty
+	}
+}
+
+// hasOpenFiles reports whether any file of pkg is open in the editor.
+func (l *Loader) hasOpenFiles(pkg *GunkPackage) bool {
+	for _, file := range pkg.GunkFiles {
+		if _, ok := l.InMemoryFiles[file]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FileChanged updates the loader after a gunk file was created, changed or
+// removed on disk by another program, such as git. Files open in the editor
+// are ignored, as their contents are managed by the language server. A
+// package left without gunk files is dropped.
+func (l *Loader) FileChanged(pkgs []*GunkPackage, path string) []*GunkPackage {
+	if _, ok := l.InMemoryFiles[path]; ok {
+		return pkgs
+	}
+	dir := filepath.Dir(path)
+	var pkg *GunkPackage
+	for _, p := range pkgs {
+		if p.HasDir(dir) {
+			pkg = p
+			break
+		}
+	}
+	if pkg == nil && l.excluded(dir) {
+		return pkgs
+	}
+	l.IndexFile(path)
+	if pkg == nil {
+		// A new package; load it so that it can be imported. Its
+		// directory may have been checked for gunk files before it
+		// had any.
+		delete(l.fakeChecked, dir)
+		newPkgs, err := l.Load(dir)
+		if err != nil || len(newPkgs) != 1 {
+			return pkgs
+		}
+		if len(newPkgs[0].GunkFiles) == 0 {
+			// Nothing to load, such as for a file removed from
+			// a package already dropped.
+			l.dropPackage(pkgs, newPkgs[0])
+			return pkgs
+		}
+		l.changed(newPkgs[0])
+		return append(pkgs, newPkgs[0])
+	}
+	l.findGunkFiles(pkg)
+	if len(pkg.GunkFiles) == 0 {
+		// The last file of the package was removed or moved away.
+		return l.removePackage(pkgs, pkg)
+	}
+	delete(l.cache, pkg.PkgPath)
+	pkg.Types = nil
+	l.changed(pkg)
+	l.invalidate(pkgs, pkg)
+	return pkgs
+}
+
+// ReloadModules drops everything derived from the module graph, after a
+// go.mod or go.sum change. The module roots and fake files are found again on
+// the next load, and all tracked packages are type checked again, so that
+// imports are resolved with the new dependencies. The symbols of the
+// dependencies are dropped until they are loaded again.
+func (l *Loader) ReloadModules(pkgs []*GunkPackage) {
+	l.unindexFiles(l.dependencies)
+	l.dependencies = nil
+	l.fakeFiles = nil
+	l.roots = nil
+	l.modules = nil
+	l.cache = make(map[string]*GunkPackage)
+	for _, pkg := range pkgs {
+		pkg.Types = nil
+		if pkg.State != Untracked {
+			pkg.State = Dirty
+		}
+	}
+}
+
+// DirRemoved updates the loader after a directory was removed or moved away,
+// dropping the packages in it and its subdirectories along with everything
+// cached for them. The packages importing them are invalidated. It returns
+// the packages left, and the dropped ones.
+func (l *Loader) DirRemoved(pkgs []*GunkPackage, dir string) (kept, removed []*GunkPackage) {
+	for _, pkg := range pkgs {
+		if pkg.Dir != "" && InDir(dir, pkg.Dir) {
+			removed = append(removed, pkg)
+		} else {
+			kept = append(kept, pkg)
+		}
+	}
+	for _, pkg := range removed {
+		l.dropPackage(kept, pkg)
+	}
+	for path := range l.configs {
+		if InDir(dir, path) {
+			delete(l.configs, path)
+		}
+	}
+	for path := range l.fakeFiles {
+		if InDir(dir, path) {
+			delete(l.fakeFiles, path)
+		}
+	}
+	for path := range l.fakeChecked {
+		if InDir(dir, path) {
+			delete(l.fakeChecked, path)
+		}
+	}
+	return kept, removed
+}
+
+// dropPackage drops everything cached for a package removed from pkgs, and
+// invalidates the packages of pkgs importing it.
+func (l *Loader) dropPackage(pkgs []*GunkPackage, pkg *GunkPackage) {
+	l.invalidate(pkgs, pkg)
+	l.setImports(pkg, nil)
+	// The cache has entries by import path and by directory.
+	for key, cached := range l.cache {
+		if cached == pkg {
+			delete(l.cache, key)
+		}
+	}
+	for _, file := range pkg.GunkFiles {
+		delete(l.parsed, file)
+		l.IndexFile(file)
+	}
+}
+
+// removePackage removes a package left without gunk files from pkgs, and
+// drops it as if its directory was removed.
+func (l *Loader) removePackage(pkgs []*GunkPackage, pkg *GunkPackage) []*GunkPackage {
+	var kept []*GunkPackage
+	for _, p := range pkgs {
+		if p != pkg {
+			kept = append(kept, p)
+		}
+	}
+	l.dropPackage(kept, pkg)
+	if pkg.Dir != "" {
+		delete(l.fakeFiles, filepath.Join(pkg.Dir, "gunkpkg.go"))
+		delete(l.fakeChecked, pkg.Dir)
+	}
+	return kept
+}
+
+// DirAdded loads the gunk packages in dir and its subdirectories, after the
+// directory was created or moved there, and returns pkgs with them. Nested
+// modules are left to their own loader.
+func (l *Loader) DirAdded(pkgs []*GunkPackage, dir string) []*GunkPackage {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if path != dir && IsModule(path) || l.excluded(path) {
+			return filepath.SkipDir
+		}
+		delete(l.fakeChecked, path)
+		for _, pkg := range pkgs {
+			if pkg.HasDir(path) {
+				return nil
+			}
+		}
+		entries, err := l.fs().ReadDir(path)
+		if err != nil {
+			return nil
+		}
+		anyGunk := false
+		for _, entry := range entries {
+			file := filepath.Join(path, entry.Name())
+			if !entry.IsDir() && filepath.Ext(file) == ".gunk" && !l.constrained(file) {
+				anyGunk = true
+				l.IndexFile(file)
+			}
+		}
+		if !anyGunk {
+			return nil
+		}
+		if newPkgs, err := l.Load(path); err == nil && len(newPkgs) == 1 {
+			l.changed(newPkgs[0])
+			pkgs = append(pkgs, newPkgs[0])
+		}
+		return nil
+	})
+	return pkgs
+}
+
+// UpdateExclusions updates the packages of the workspace after l.Exclude or
+// the ignore files changed: the packages in excluded directories are
+// dropped, unless they have open files, and the packages that are no longer
+// excluded are loaded. It returns the packages left, and the dropped ones.
+func (l *Loader) UpdateExclusions(pkgs []*GunkPackage) (kept, removed []*GunkPackage) {
+	kept = pkgs
+	for _, pkg := range pkgs {
+		if pkg.Dir == "" || !l.excluded(pkg.Dir) || l.hasOpenFiles(pkg) || !ContainsPkg(kept, pkg) {
+			continue
+		}
+		var dropped []*GunkPackage
+		kept, dropped = l.DirRemoved(kept, pkg.Dir)
+		for _, p := range dropped {
+			l.unindexFiles(p.GunkFiles)
+		}
+		removed = append(removed, dropped...)
+	}
+	if l.fakeFiles == nil {
+		// Nothing was loaded yet.
+		return kept, removed
+	}
+	return l.DirAdded(kept, l.Dir), removed
+}
+
+// IgnoreFileChanged reads the ignore files of the workspace again, after one
+// of them changed on disk, and updates its packages like UpdateExclusions.
+func (l *Loader) IgnoreFileChanged(pkgs []*GunkPackage) (kept, removed []*GunkPackage) {
+	l.ignores = nil
+	return l.UpdateExclusions(pkgs)
+}
+
+// ContainsPkg reports whether pkg is one of pkgs.
+func ContainsPkg(pkgs []*GunkPackage, pkg *GunkPackage) bool {
+	for _, p := range pkgs {
+		if p == pkg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package loader
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/gunk/gunk/loader"
+	"golang.org/x/tools/go/packages"
+)
+
+// testChain returns a loader with the packages a, b and c, where c imports b
+// and b imports a, all type checked and open, and an unrelated package d.
+func testChain() (*Loader, []*GunkPackage) {
+	l := &Loader{cache: make(map[string]*GunkPackage)}
+	var pkgs []*GunkPackage
+	byPath := make(map[string]*GunkPackage)
+	for _, path := range []string{"a", "b", "c", "d"} {
+		pkg := NewGunkPackage(packages.Package{PkgPath: path}, Open)
+		pkg.Types = types.NewPackage(path, path)
+		pkg.Imports = make(map[string]*loader.GunkPackage)
+		l.cache[path] = pkg
+		byPath[path] = pkg
+		pkgs = append(pkgs, pkg)
+	}
+	for importer, path := range map[string]string{"b": "a", "c": "b"} {
+		byPath[importer].Imports[path] = byPath[path].GunkPackage
+		l.setImports(byPath[importer], []string{path})
+	}
+	return l, pkgs
+}
+
+func TestInvalidateChain(t *testing.T) {
+	l, pkgs := testChain()
+	a, b, c, d := pkgs[0], pkgs[1], pkgs[2], pkgs[3]
+	// a is parsed again, as after an edit.
+	a.GunkPackage = &loader.GunkPackage{Package: packages.Package{PkgPath: "a"}}
+	l.invalidate(pkgs, a)
+	for _, pkg := range []*GunkPackage{b, c} {
+		if pkg.State != Dirty {
+			t.Errorf("%s: got state %v, want Dirty", pkg.PkgPath, pkg.State)
+		}
+		if pkg.Types != nil {
+			t.Errorf("%s: type information wasn't dropped", pkg.PkgPath)
+		}
+	}
+	if b.Imports["a"] != a.GunkPackage {
+		t.Errorf("b still imports the old a")
+	}
+	if d.State != Open || d.Types == nil {
+		t.Errorf("d doesn't import a, but was invalidated")
+	}
+}
+
+func TestInvalidateUntracked(t *testing.T) {
+	l, pkgs := testChain()
+	a, b, c := pkgs[0], pkgs[1], pkgs[2]
+	b.State, c.State = Untracked, Untracked
+	l.invalidate(pkgs, a)
+	if b.State != Untracked || c.State != Untracked {
+		t.Errorf("untracked importers were marked dirty: b %v, c %v", b.State, c.State)
+	}
+	if b.Types != nil || c.Types != nil {
+		t.Errorf("untracked importers kept their type information")
+	}
+
+	l, pkgs = testChain()
+	a, b, c = pkgs[0], pkgs[1], pkgs[2]
+	b.State, c.State = Untracked, Untracked
+	l.AnalyzeWorkspace = true
+	l.invalidate(pkgs, a)
+	if b.State != Dirty || c.State != Dirty {
+		t.Errorf("importers weren't marked dirty when analyzing the workspace: b %v, c %v", b.State, c.State)
+	}
+}
+
+func TestInvalidateRemovedImport(t *testing.T) {
+	l, pkgs := testChain()
+	a, b, c := pkgs[0], pkgs[1], pkgs[2]
+	// b no longer imports a.
+	l.setImports(b, nil)
+	l.invalidate(pkgs, a)
+	for _, pkg := range []*GunkPackage{b, c} {
+		if pkg.State != Open || pkg.Types == nil {
+			t.Errorf("%s: invalidated through a removed import", pkg.PkgPath)
+		}
+	}
+	// Invalidating b still reaches c.
+	l.invalidate(pkgs, b)
+	if c.State != Dirty || c.Types != nil {
+		t.Errorf("c wasn't invalidated by b")
+	}
+}
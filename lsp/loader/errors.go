@@ -5,7 +5,6 @@ import (
 	"go/scanner"
 	"go/token"
 	"go/types"
-	"log"
 	"strconv"
 	"strings"
 
@@ -35,7 +34,13 @@ const (
 	ValidateError = packages.TypeError + 10 + iota
 )
 
-func (g *GunkPackage) parseError(file string, err error) {
+// DiagnosticSource is the source of the diagnostics of errors and lint
+// warnings, unless configured otherwise.
+const DiagnosticSource = "gunkls"
+
+// parseError adds the errors of parsing a file to the package, and returns err
+// if it isn't one that can be positioned in the file.
+func (g *GunkPackage) parseError(file string, err error) error {
 	// errors.As is intentionally unused to prevent losing context.
 	switch v := err.(type) {
 	case packages.Error:
@@ -63,8 +68,19 @@ func (g *GunkPackage) parseError(file string, err error) {
 			})
 		}
 	default:
-		log.Printf("unexpected error: %T: %v", err, err)
+		return err
+	}
+	return nil
+}
+
+// HasParseErrors reports whether any file of the package has syntax errors.
+func (g *GunkPackage) HasParseErrors() bool {
+	for _, e := range g.Errors {
+		if e.Kind == ParseError {
+			return true
+		}
 	}
+	return false
 }
 
 func (g *GunkPackage) error(file string, from token.Pos, to token.Pos, fset *token.FileSet, msg string, typ packages.ErrorKind) {
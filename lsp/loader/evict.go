@@ -0,0 +1,78 @@
+package loader
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// Evict drops the syntax trees and type information of the cached packages
+// that have no open files and haven't been used for idle, as long as no
+// package that is kept imports them. Only their metadata is kept, so they are
+// parsed again the next time they are needed. Evict returns the evicted
+// packages.
+func (l *Loader) Evict(idle time.Duration) []*GunkPackage {
+	// The cache has entries by import path and by directory.
+	pkgs := make(map[*GunkPackage]bool)
+	for _, pkg := range l.cache {
+		pkgs[pkg] = true
+	}
+	// keepDirs are the directories of the kept packages, starting with
+	// the ones with open files.
+	keepDirs := make(map[string]bool)
+	for path := range l.InMemoryFiles {
+		keepDirs[filepath.Dir(path)] = true
+	}
+	// Keep the open and recently used packages, and everything they
+	// import, so that type information is never mixed with the one of a
+	// package parsed again.
+	keep := make(map[string]bool)
+	var queue []string
+	for pkg := range pkgs {
+		if anyDir(keepDirs, pkg.Dirs) || pkg.State == Dirty || time.Since(pkg.used) < idle {
+			keep[pkg.PkgPath] = true
+			queue = append(queue, pkg.PkgPath)
+		}
+	}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		for _, imp := range l.imports[path] {
+			if !keep[imp] {
+				keep[imp] = true
+				queue = append(queue, imp)
+			}
+		}
+	}
+	var evicted []*GunkPackage
+	for pkg := range pkgs {
+		if keep[pkg.PkgPath] {
+			for _, dir := range pkg.Dirs {
+				keepDirs[dir] = true
+			}
+			continue
+		}
+		if pkg.GunkSyntax == nil && pkg.Types == nil {
+			continue
+		}
+		resetPackage(pkg)
+		evicted = append(evicted, pkg)
+	}
+	// Also drop the syntax trees of the files that were only parsed to be
+	// indexed.
+	for path := range l.parsed {
+		if !keepDirs[filepath.Dir(path)] {
+			delete(l.parsed, path)
+		}
+	}
+	return evicted
+}
+
+// anyDir reports whether any of dirs is in set.
+func anyDir(set map[string]bool, dirs []string) bool {
+	for _, dir := range dirs {
+		if set[dir] {
+			return true
+		}
+	}
+	return false
+}
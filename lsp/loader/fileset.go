@@ -0,0 +1,43 @@
+package loader
+
+import "go/token"
+
+// compactSlack is how many bytes of outdated files the file set may hold
+// before it is compacted, so that small workspaces are never compacted.
+const compactSlack = 1 << 20
+
+// CompactFileSet replaces the file set once it mostly holds outdated files.
+// Every parse adds a file to the file set that is never removed, including
+// each version of an edited file and each gunk tag, so it would otherwise
+// grow for as long as the server runs.
+//
+// All the syntax trees and type information are dropped, as their positions
+// refer to the old file set, and the tracked packages in pkgs are marked as
+// dirty to be analyzed again. CompactFileSet reports whether the file set was
+// replaced.
+func (l *Loader) CompactFileSet(pkgs []*GunkPackage) bool {
+	var live int
+	for _, p := range l.parsed {
+		if p.file == nil {
+			continue
+		}
+		if f := l.Fset.File(p.file.Pos()); f != nil {
+			live += f.Size()
+		}
+	}
+	if l.Fset.Base() <= 2*live+compactSlack {
+		return false
+	}
+	l.Fset = token.NewFileSet()
+	l.parsed = nil
+	for _, pkg := range l.cache {
+		resetPackage(pkg)
+	}
+	for _, pkg := range pkgs {
+		resetPackage(pkg)
+		if pkg.State != Untracked {
+			pkg.State = Dirty
+		}
+	}
+	return true
+}
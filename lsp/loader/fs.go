@@ -0,0 +1,67 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gunk/gunk/config"
+	"golang.org/x/tools/go/packages"
+)
+
+// FS is a file system that gunk files and package directories are read from.
+type FS interface {
+	ReadFile(path string) ([]byte, error)
+	ReadDir(dir string) ([]fs.DirEntry, error)
+}
+
+// osFS is the local file system.
+type osFS struct{}
+
+func (osFS) ReadFile(path string) ([]byte, error)      { return os.ReadFile(path) }
+func (osFS) ReadDir(dir string) ([]fs.DirEntry, error) { return os.ReadDir(dir) }
+
+// fs returns the file system of the loader.
+func (l *Loader) fs() FS {
+	if l.Remote != nil {
+		return l.Remote
+	}
+	return osFS{}
+}
+
+// loadRemote loads the package in the directory path of the remote file
+// system. Remote packages aren't known to the go command, so each directory
+// is a package of its own, which may only import the standard library.
+func (l *Loader) loadRemote(path string) ([]*GunkPackage, error) {
+	if !filepath.IsAbs(path) || strings.HasSuffix(path, "/...") {
+		return nil, fmt.Errorf("cannot load %s in a remote workspace", path)
+	}
+	if l.cache == nil {
+		l.cache = make(map[string]*GunkPackage)
+	}
+	pkg := NewGunkPackage(packages.Package{
+		ID:      path,
+		PkgPath: filepath.ToSlash(path),
+	}, Untracked)
+	pkg.Dir = path
+	pkg.used = time.Now()
+	l.findGunkFiles(pkg)
+	l.cache[pkg.PkgPath] = pkg
+	l.cache[path] = pkg
+	return []*GunkPackage{pkg}, nil
+}
+
+// remoteConfig returns the gunk configuration of a remote directory, which
+// only comes from its own .gunkconfig. It isn't cached, as the file may not
+// have been read from the remote file system yet.
+func (l *Loader) remoteConfig(dir string) (*config.Config, error) {
+	b, err := l.Remote.ReadFile(filepath.Join(dir, ".gunkconfig"))
+	if err != nil {
+		b = nil
+	}
+	return config.LoadSingle(bytes.NewReader(b), dir)
+}
@@ -0,0 +1,91 @@
+package loader
+
+import (
+	"encoding/json"
+	"go/types"
+	"os"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// GoDriver is the executable run as the packages driver of the loaders with a
+// GoCommand, which must call RunGoDriver when IsGoDriver is true. go/packages
+// always runs the go command found in the PATH of the process, which all
+// sessions share, so the packages of a session using another go binary are
+// listed by a driver process with its own PATH. It is empty unless set by the
+// program, in which case GoCommand only applies to the go commands run by the
+// loader itself.
+var GoDriver string
+
+// goDriverEnv is set in the environment of the packages driver run for a
+// GoCommand.
+const goDriverEnv = "GUNKLS_GO_DRIVER"
+
+// IsGoDriver reports whether the process was run as the packages driver of a
+// loader with a GoCommand.
+func IsGoDriver() bool {
+	return os.Getenv(goDriverEnv) != ""
+}
+
+// driverRequest and driverResponse are the messages of the driver protocol of
+// go/packages, which reads the response of the driver from its JSON form.
+type driverRequest struct {
+	Mode       packages.LoadMode `json:"mode"`
+	Env        []string          `json:"env"`
+	BuildFlags []string          `json:"build_flags"`
+	Tests      bool              `json:"tests"`
+	Overlay    map[string][]byte `json:"overlay"`
+}
+
+type driverResponse struct {
+	Sizes    *types.StdSizes
+	Roots    []string `json:",omitempty"`
+	Packages []*packages.Package
+}
+
+// RunGoDriver lists the packages matching patterns for the request read from
+// the standard input, and writes the response to the standard output. It is
+// run with the environment built by GoEnv, where the go binary of the loader
+// comes first in PATH, so that go/packages runs it. Only the metadata of the
+// packages is listed: types and syntax are loaded by the caller.
+func RunGoDriver(patterns []string) error {
+	var req driverRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		return err
+	}
+	mode := req.Mode
+	if mode&packages.NeedTypes != 0 {
+		// The caller type checks the dependencies from their export
+		// data, as it would with the go command.
+		mode |= packages.NeedTypesSizes
+		if mode&packages.NeedDeps == 0 {
+			mode |= packages.NeedExportsFile
+		}
+	}
+	if mode&(packages.NeedSyntax|packages.NeedTypes|packages.NeedTypesInfo) != 0 {
+		mode |= packages.NeedCompiledGoFiles
+	}
+	mode &^= packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo
+	cfg := &packages.Config{
+		Mode:       mode,
+		Env:        append(req.Env, "GOPACKAGESDRIVER=off"),
+		BuildFlags: req.BuildFlags,
+		Tests:      req.Tests,
+		Overlay:    req.Overlay,
+	}
+	roots, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return err
+	}
+	var resp driverResponse
+	for _, pkg := range roots {
+		resp.Roots = append(resp.Roots, pkg.ID)
+	}
+	packages.Visit(roots, nil, func(pkg *packages.Package) {
+		if sizes, ok := pkg.TypesSizes.(*types.StdSizes); ok {
+			resp.Sizes = sizes
+		}
+		resp.Packages = append(resp.Packages, pkg)
+	})
+	return json.NewEncoder(os.Stdout).Encode(resp)
+}
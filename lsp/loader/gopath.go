@@ -0,0 +1,56 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gopathSrcDir returns the GOPATH source directory containing the loader
+// directory, and the GOPATH entries, if the directory is not in a module.
+// Otherwise, it returns an empty string.
+func (l *Loader) gopathSrcDir() (string, []string) {
+	out, err := l.goCommand("env", "GOMOD", "GOPATH").Output()
+	if err != nil {
+		return "", nil
+	}
+	// GOMOD is an empty line outside of modules, so only the final
+	// newline is trimmed.
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != 2 {
+		return "", nil
+	}
+	if gomod := strings.TrimSpace(lines[0]); gomod != "" && gomod != os.DevNull {
+		return "", nil
+	}
+	gopath := filepath.SplitList(strings.TrimSpace(lines[1]))
+	for _, entry := range gopath {
+		src := filepath.Join(entry, "src")
+		if InDir(src, l.Dir) {
+			return src, gopath
+		}
+	}
+	return "", nil
+}
+
+// gopathImportDir returns the directory of an import path in GOPATH mode.
+// Like the go command, the vendor directories of dir and its parents inside
+// src are searched first, and then each GOPATH entry.
+func gopathImportDir(src string, gopath []string, dir, path string) string {
+	for d := dir; InDir(src, d); d = filepath.Dir(d) {
+		vendored := filepath.Join(d, "vendor", filepath.FromSlash(path))
+		if _, err := os.Stat(vendored); err == nil {
+			return vendored
+		}
+		if d == src {
+			break
+		}
+	}
+	for _, entry := range gopath {
+		dir := filepath.Join(entry, "src", filepath.FromSlash(path))
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
@@ -0,0 +1,24 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGopathSrcDir(t *testing.T) {
+	gopath := t.TempDir()
+	dir := filepath.Join(gopath, "src", "example.com", "p")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	l := &Loader{Dir: dir}
+	l.env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	src, entries := l.gopathSrcDir()
+	if want := filepath.Join(gopath, "src"); src != want {
+		t.Errorf("got source directory %q, want %q", src, want)
+	}
+	if len(entries) != 1 || entries[0] != gopath {
+		t.Errorf("got GOPATH %q, want [%q]", entries, gopath)
+	}
+}
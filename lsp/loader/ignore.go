@@ -0,0 +1,211 @@
+package loader
+
+import (
+	"bytes"
+	"go/build/constraint"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ignoreFiles are the names of the files listing the directories ignored in
+// the directory they are in and its subdirectories, in the order they apply.
+var ignoreFiles = []string{".gitignore", ".gunklsignore"}
+
+// IsIgnoreFile reports whether path is a .gitignore or .gunklsignore file.
+func IsIgnoreFile(path string) bool {
+	base := filepath.Base(path)
+	for _, name := range ignoreFiles {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRule is a pattern of an ignore file.
+type ignoreRule struct {
+	// elems are the slash-separated elements of the pattern, relative to
+	// the directory of the ignore file. "**" matches any number of
+	// elements.
+	elems  []string
+	negate bool
+}
+
+// Ignores reports the directories ignored by the .gitignore and .gunklsignore
+// files of a root directory and its subdirectories. The ignore files are read
+// once, when first needed.
+type Ignores struct {
+	root  string
+	read  func(path string) ([]byte, error)
+	rules map[string][]ignoreRule
+}
+
+// NewIgnores returns the ignored directories of root, with ignore files read
+// by read.
+func NewIgnores(root string, read func(path string) ([]byte, error)) *Ignores {
+	return &Ignores{root: root, read: read, rules: make(map[string][]ignoreRule)}
+}
+
+// Ignored reports whether dir, inside the root, or one of its parents is
+// ignored. As with git, the last pattern matching a directory decides, and
+// directories inside an ignored one can't be included again.
+func (ig *Ignores) Ignored(dir string) bool {
+	rel, err := filepath.Rel(ig.root, dir)
+	if err != nil || rel == "." || !InDir(ig.root, dir) {
+		return false
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+	for i := 1; i <= len(elems); i++ {
+		if ig.match(elems[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the last rule matching the directory at elems,
+// relative to the root, ignores it. Rules of ignore files in subdirectories
+// come after the ones of their parents.
+func (ig *Ignores) match(elems []string) bool {
+	ignored := false
+	for i := range elems {
+		dir := filepath.Join(ig.root, filepath.FromSlash(strings.Join(elems[:i], "/")))
+		for _, r := range ig.dirRules(dir) {
+			if matchElems(r.elems, elems[i:]) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// dirRules returns the rules of the ignore files of dir.
+func (ig *Ignores) dirRules(dir string) []ignoreRule {
+	rules, ok := ig.rules[dir]
+	if ok {
+		return rules
+	}
+	for _, name := range ignoreFiles {
+		if b, err := ig.read(filepath.Join(dir, name)); err == nil {
+			rules = append(rules, parseIgnoreFile(b)...)
+		}
+	}
+	ig.rules[dir] = rules
+	return rules
+}
+
+// parseIgnoreFile returns the rules of an ignore file. Only directories are
+// matched, so trailing slashes are dropped. Patterns without other slashes
+// match at any depth.
+func parseIgnoreFile(b []byte) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		line = strings.TrimSuffix(line, "/")
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		r.elems = strings.Split(line, "/")
+		if !anchored {
+			r.elems = append([]string{"**"}, r.elems...)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// matchElems reports whether the path elements elems match the elements of a
+// pattern.
+func matchElems(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if matchElems(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], elems[0])
+	return ok && matchElems(pattern[1:], elems[1:])
+}
+
+// constrained reports whether the build constraints of a gunk file exclude
+// it. Constraints are //go:build or // +build lines before the package
+// clause, as in Go files. Only the GOOS and GOARCH of the environment are
+// satisfied, so that files constrained by "ignore" or any other tag are left
+// out, as are files of other platforms.
+func (l *Loader) constrained(path string) bool {
+	src, err := l.ReadFile(path)
+	return err == nil && l.constrainedSource(src)
+}
+
+// constrainedSource reports whether the build constraints of the source of a
+// gunk file exclude it.
+func (l *Loader) constrainedSource(src []byte) bool {
+	var expr constraint.Expr
+	for len(src) > 0 {
+		line := src
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			line, src = src[:i], src[i+1:]
+		} else {
+			src = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !bytes.HasPrefix(line, []byte("//")) {
+			break
+		}
+		text := string(line)
+		if !constraint.IsGoBuild(text) && !constraint.IsPlusBuild(text) {
+			continue
+		}
+		x, err := constraint.Parse(text)
+		if err != nil {
+			continue
+		}
+		switch {
+		case constraint.IsGoBuild(text):
+			// A //go:build line replaces the // +build lines.
+			return !x.Eval(l.buildTag)
+		case expr == nil:
+			expr = x
+		default:
+			expr = &constraint.AndExpr{X: expr, Y: x}
+		}
+	}
+	return expr != nil && !expr.Eval(l.buildTag)
+}
+
+// buildTag reports whether a build tag is satisfied: the GOOS or GOARCH of the
+// environment.
+func (l *Loader) buildTag(tag string) bool {
+	goos, goarch := getenv(l.Env, "GOOS"), getenv(l.Env, "GOARCH")
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	return tag == goos || tag == goarch
+}
@@ -1,6 +1,7 @@
 package loader
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"go/ast"
@@ -11,19 +12,62 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/gunk/gunk/config"
 	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunkls/lsp/metrics"
 	"go.lsp.dev/protocol"
+	"go.uber.org/zap"
 	"golang.org/x/tools/go/packages"
 )
 
 type Loader struct {
 	Dir  string
 	Fset *token.FileSet
-	// If Types is true, we parse and type-check the given packages and all
-	// transitive dependencies, including gunk tags. Otherwise, we only
-	// parse the given packages.
+	// If Types is true, packages are always type checked when parsed,
+	// along with the imports they need, including gunk tags. Otherwise,
+	// they are only type checked when analyzed or imported.
 	Types bool
+	// Driver is the GOPACKAGESDRIVER that provides package metadata, for
+	// build systems such as Bazel. If empty, the GOPACKAGESDRIVER of the
+	// environment is used, and the go command if that is unset too, or
+	// if Driver is "off".
+	Driver string
+	// Env are additional environment variables for the go command and
+	// the packages driver, such as GOFLAGS or GOPRIVATE, in the form
+	// "key=value".
+	Env []string
+	// GoCommand is the go binary to use, as a path or a name found in
+	// PATH, instead of the go command of PATH. go/packages only runs the
+	// go command of the PATH of the process, which all sessions share, so
+	// packages are listed by GoDriver with the environment built by
+	// GoEnv. Without GoDriver, only the go commands run by the loader use
+	// it.
+	GoCommand string
+	// Remote is set for workspaces outside of the local file system, such
+	// as the virtual workspaces of some editors. Files are read from it
+	// instead of the disk, and packages are not loaded with the go
+	// command, which can't read them.
+	Remote FS
+	// Shared is the cache shared with other loaders, such as the ones of
+	// other sessions. If nil, the loader has a cache of its own.
+	Shared *Cache
+	// Logger logs what can't be reported as package errors. If nil, the
+	// loader doesn't log.
+	Logger *zap.Logger
+	// Exclude are the patterns of the directories of the workspace whose
+	// packages aren't discovered, such as "testdata" or "third_party", as
+	// matched by Excluded. They are still loaded when imported, as are the
+	// directories ignored by the .gitignore and .gunklsignore files of the
+	// workspace.
+	Exclude []string
+	// AnalyzeWorkspace marks any package of the workspace affected by a
+	// change as dirty, so that its diagnostics are published even if none
+	// of its files are open. Otherwise, only the packages with open files,
+	// the packages importing them and the packages already analyzed are.
+	AnalyzeWorkspace bool
+
 	cache map[string]*GunkPackage // map from import path to pkg
 
 	// InMemoryFiles is a list of files that are are managed by the language
@@ -36,24 +80,63 @@ type Loader struct {
 	// fakeFiles is a list of fake Go files added to make the Go compiler pick
 	// up gunk files in packages without Go files.
 	fakeFiles map[string][]byte
+
+	// configs caches the gunk configuration of each package directory.
+	configs map[string]*config.Config
+
+	// symbols indexes the symbols declared in each gunk file. It is
+	// replaced rather than modified on updates.
+	symbols SymbolIndex
+	// dependencies are the gunk files of the dependency packages in the
+	// symbol index, which are indexed as they are loaded.
+	dependencies []string
+	// ignores are the directories ignored by the ignore files of the
+	// workspace, read when first needed.
+	ignores *Ignores
+
+	// parsed caches the syntax trees of gunk files, so that only the files
+	// that changed are parsed again.
+	parsed map[string]parsedFile
+
+	// imports and importers are the dependency graph of the parsed
+	// packages, and its reverse, by import path.
+	imports   map[string][]string
+	importers map[string]map[string]bool
+
+	// fakeChecked are the directories checked for needing a fake file.
+	fakeChecked map[string]bool
+
+	// modules is the build list, and roots are the directories of its
+	// modules. Both are nil in GOPATH mode. Until modulesListed is set,
+	// they only have the main modules, as listing the dependencies needs
+	// the whole module graph.
+	modules       []module
+	roots         []string
+	modulesListed bool
+	// gopathSrc is the GOPATH source directory of the workspace, and
+	// gopath the GOPATH entries, in GOPATH mode.
+	gopathSrc string
+	gopath    []string
+	// env is the environment of the go command and the packages driver.
+	env []string
 }
 
 // addFakeFile adds a fake Go file to the loader, if needed.
-// addFakeFile can return nil even if a file isn't added, if there are not
-// any Gunk files in the package, or go files already exist in the package.
-func (l *Loader) addFakeFile(pkgName, dirPath string) error {
+// addFakeFile reports whether the directory has any Gunk files, even if a file
+// isn't added because Go files already exist in the package. Gunk files
+// excluded by their build constraints don't count.
+func (l *Loader) addFakeFile(pkgName, dirPath string) (bool, error) {
 	infos, err := os.ReadDir(dirPath)
 	if err != nil {
-		return err
+		return false, err
 	}
-	anyGunk := false
+	anyGunk, anyGo := false, false
 	for _, info := range infos {
 		name := info.Name()
 		if strings.HasSuffix(name, ".go") {
-			// has Go files; nothing to do
-			return nil
+			anyGo = true
 		}
-		if strings.HasSuffix(name, ".gunk") {
+		if strings.HasSuffix(name, ".gunk") && !anyGunk && !l.constrained(filepath.Join(dirPath, name)) {
 			f, err := parser.ParseFile(token.NewFileSet(),
 				filepath.Join(dirPath, name), nil, parser.PackageClauseOnly)
 			// Ignore errors, since Gunk packages being
@@ -63,53 +146,333 @@ func (l *Loader) addFakeFile(pkgName, dirPath string) error {
 				pkgName = f.Name.Name
 			}
 			anyGunk = true
-			break
 		}
 	}
-	if !anyGunk {
-		return nil
+	if !anyGunk || anyGo {
+		// no Gunk files, or has Go files; nothing to do
+		return anyGunk, nil
 	}
 	tmpPath := filepath.Join(dirPath, "gunkpkg.go")
 	l.fakeFiles[tmpPath] = []byte(`package ` + pkgName)
-	return nil
+	return true, nil
 }
 
-// addFakeFiles iterate over all module dependencies of the specified directory
-// and adds a fake Go file for all directories inside the dependencies that
-// only has Gunk files and no Go files.
+// addFakeFiles finds the modules of the build list, and adds a fake Go file
+// for all directories inside the workspace that only have Gunk files and no
+// Go files. Directories outside of the workspace are only checked once they
+// are loaded, by addFakeFileFor.
 // This allows the loader to process Gunk packages using regular Go package
 // parsing code when fakeFiles is used as an overlay.
 func (l *Loader) addFakeFiles() error {
 	l.fakeFiles = make(map[string][]byte)
-	// use "." if we encountered an error, for e.g. GOPATH mode
-	roots := []string{"."}
-	cmd := exec.Command("go", "list", "-m", "-f={{.Dir}}", "all")
-	cmd.Dir = l.Dir
-	if out, err := cmd.Output(); err == nil {
-		rootOutput := strings.Split(strings.TrimSpace(string(out)), "\n")
-		roots = make([]string, 0, len(rootOutput))
-		for _, v := range rootOutput {
-			roots = append(roots, strings.TrimSpace(v))
-		}
-	}
-	// Walk through all directories and add fake files for all packages that
-	// only have gunk files.
-	for _, root := range roots {
-		if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				return nil
-			}
-			return l.addFakeFile(info.Name(), path)
-		}); err != nil {
+	l.fakeChecked = make(map[string]bool)
+	l.modules = nil
+	l.roots = nil
+	l.gopathSrc = ""
+	l.gopath = nil
+	l.env = GoEnv(append(os.Environ(), l.Env...), l.GoCommand)
+	if l.Driver != "" {
+		// Override the GOPACKAGESDRIVER of the environment, which may
+		// also be "off".
+		l.env = append(l.env, "GOPACKAGESDRIVER="+l.Driver)
+	}
+	l.modulesListed = true
+	driver := l.driver()
+	if driver == "" {
+		l.gopathSrc, l.gopath = l.gopathSrcDir()
+	}
+	switch {
+	case driver != "":
+		// Package metadata comes from the driver alone, which is
+		// given the fake files of the workspace as an overlay.
+	case l.gopathSrc != "":
+		// The go command defaults to module mode even without a
+		// go.mod.
+		l.env = append(l.env, "GO111MODULE=off")
+	default:
+		// The packages of the workspace are found without the
+		// dependencies, which are listed once an import needs them.
+		l.setModules(l.listMainModules())
+		l.modulesListed = false
+	}
+	// Walk through all directories of the workspace and add fake files for
+	// all packages that only have gunk files.
+	return filepath.Walk(l.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
 			return err
 		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != l.Dir && (IsModule(path) || l.excluded(path)) {
+			// Nested modules are loaded by their own loader,
+			// and excluded directories only when imported.
+			return filepath.SkipDir
+		}
+		l.fakeChecked[path] = true
+		_, err = l.addFakeFile(info.Name(), path)
+		return err
+	})
+}
+
+// driver returns the packages driver to use, or an empty string to use the
+// go command.
+func (l *Loader) driver() string {
+	driver := l.Driver
+	if driver == "" {
+		driver = getenv(l.Env, "GOPACKAGESDRIVER")
+	}
+	if driver == "off" {
+		return ""
+	}
+	return driver
+}
+
+// goCommand returns a go command run in the loader directory, with its
+// environment.
+func (l *Loader) goCommand(args ...string) *exec.Cmd {
+	name := "go"
+	if l.GoCommand != "" {
+		name = l.GoCommand
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = l.Dir
+	cmd.Env = l.env
+	return cmd
+}
+
+// GoEnv returns env for running the go binary goCommand, which may be a path
+// or a name found in PATH, or env itself if goCommand is empty. The directory
+// of the binary comes first in PATH. As go/packages runs the go command found
+// in the PATH of the process, packages are listed through GoDriver, which is
+// run with this PATH, unless env has a packages driver already. GOROOT is
+// only set to the root of goCommand then, as the go command of the process
+// can't use the standard library of another version.
+func GoEnv(env []string, goCommand string) []string {
+	if goCommand == "" {
+		return env
+	}
+	path, err := exec.LookPath(goCommand)
+	if err != nil {
+		return env
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return env
+	}
+	env = append(env[:len(env):len(env)], "PATH="+filepath.Dir(path)+string(os.PathListSeparator)+getenv(env, "PATH"))
+	if driver := getenv(env, "GOPACKAGESDRIVER"); GoDriver == "" || driver != "" && driver != "off" {
+		return env
+	}
+	env = append(env, "GOPACKAGESDRIVER="+GoDriver, goDriverEnv+"="+path)
+	cmd := exec.Command(path, "env", "GOROOT")
+	cmd.Env = env
+	if out, err := cmd.Output(); err == nil && len(bytes.TrimSpace(out)) > 0 {
+		env = append(env, "GOROOT="+string(bytes.TrimSpace(out)))
+	}
+	return env
+}
+
+// getenv returns the value of key in env, as set by the client, or in the
+// environment of the process otherwise.
+func getenv(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return strings.TrimPrefix(env[i], key+"=")
+		}
+	}
+	return os.Getenv(key)
+}
+
+// listModules lists the modules of the build list. When the dependencies are
+// vendored, "go list -m all" fails, so the main module is listed alone, and
+// the vendored modules are read from vendor/modules.txt. It returns nil if
+// not in a module.
+func (l *Loader) listModules() []module {
+	cmd := l.goCommand("list", "-m", "-f="+moduleFormat, "all")
+	if out, err := cmd.Output(); err == nil {
+		return parseModules(out)
 	}
+	mods := l.listMainModules()
+	if len(mods) != 1 || mods[0].dir == "" {
+		return mods
+	}
+	vendor := filepath.Join(mods[0].dir, "vendor")
+	if b, err := os.ReadFile(filepath.Join(vendor, "modules.txt")); err == nil {
+		mods = append(mods, parseVendoredModules(b, vendor)...)
+	}
+	return mods
+}
+
+// listMainModules lists the main modules alone, which doesn't need the module
+// graph. It returns nil if not in a module.
+func (l *Loader) listMainModules() []module {
+	out, err := l.goCommand("list", "-m", "-f="+moduleFormat).Output()
+	if err != nil {
+		return nil
+	}
+	return parseModules(out)
+}
+
+// setModules sets the build list, and the directories of its modules.
+func (l *Loader) setModules(mods []module) {
+	l.modules = mods
+	l.roots = make([]string, 0, len(mods))
+	for _, mod := range mods {
+		l.roots = append(l.roots, mod.dir)
+	}
+}
+
+// listDependencies lists the whole build list, if only the main modules were
+// listed so far, and reports whether it did. The main modules are kept if the
+// build list can't be listed.
+func (l *Loader) listDependencies() bool {
+	if l.modulesListed || l.fakeFiles == nil {
+		return false
+	}
+	l.modulesListed = true
+	if mods := l.listModules(); mods != nil {
+		l.setModules(mods)
+	}
+	return true
+}
+
+// addFakeFileFor adds a fake Go file for the package at path, an import path
+// or a directory, if it has not been checked yet.
+//
+// Versioned modules are immutable, so whether their directories contain Gunk
+// files is cached per module version across runs.
+func (l *Loader) addFakeFileFor(path string) error {
+	if strings.HasSuffix(path, "/...") {
+		// Patterns are only used for the workspace, which has been
+		// walked already.
+		return nil
+	}
+	dir, version, rel := path, "", ""
+	if !filepath.IsAbs(path) {
+		dir, version, rel = l.importDir(path)
+	}
+	if dir == "" || l.fakeChecked[dir] {
+		return nil
+	}
+	l.fakeChecked[dir] = true
+	if anyGunk, ok := l.shared().lookupModule(version, rel); ok && !anyGunk {
+		return nil
+	}
+	anyGunk, err := l.addFakeFile(filepath.Base(dir), dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.shared().storeModule(version, rel, anyGunk)
 	return nil
 }
 
+// importDir returns the directory of an import path, from the module with
+// the longest matching path, or from GOPATH when not in module mode. For
+// versioned modules, the module version and the directory relative to the
+// module are also returned. The dependencies are listed the first time an
+// import path isn't a package of the workspace.
+func (l *Loader) importDir(path string) (dir, version, rel string) {
+	if l.gopathSrc != "" {
+		return gopathImportDir(l.gopathSrc, l.gopath, l.Dir, path), "", ""
+	}
+	dir, version, rel = l.moduleImportDir(path)
+	if (dir == "" || !l.fakeChecked[dir]) && l.listDependencies() {
+		// A dependency, or a nested module of the main module.
+		dir, version, rel = l.moduleImportDir(path)
+	}
+	return dir, version, rel
+}
+
+// moduleImportDir returns the directory of an import path from the modules
+// listed so far, as importDir.
+func (l *Loader) moduleImportDir(path string) (dir, version, rel string) {
+	if l.modules == nil {
+		return "", "", ""
+	}
+	var best *module
+	for i, mod := range l.modules {
+		if mod.dir == "" {
+			// Not downloaded.
+			continue
+		}
+		if path != mod.path && !strings.HasPrefix(path, mod.path+"/") {
+			continue
+		}
+		if best == nil || len(mod.path) > len(best.path) {
+			best = &l.modules[i]
+		}
+	}
+	if best == nil {
+		return "", "", ""
+	}
+	rel = strings.TrimPrefix(strings.TrimPrefix(path, best.path), "/")
+	return filepath.Join(best.dir, filepath.FromSlash(rel)), best.version, rel
+}
+
+// ImportPath returns the import path of the package in dir, which may not
+// exist yet, from the module with the longest matching directory, or from
+// GOPATH when not in module mode. It is the reverse of importDir, and returns
+// an empty string if dir is outside of all of them.
+func (l *Loader) ImportPath(dir string) string {
+	if l.gopathSrc != "" {
+		rel, err := filepath.Rel(l.gopathSrc, dir)
+		if err != nil || !InDir(l.gopathSrc, dir) || rel == "." {
+			return ""
+		}
+		return filepath.ToSlash(rel)
+	}
+	best := l.dirModule(dir)
+	if best == nil {
+		if l.listDependencies() {
+			return l.ImportPath(dir)
+		}
+		return ""
+	}
+	rel, err := filepath.Rel(best.dir, dir)
+	if err != nil {
+		return ""
+	}
+	if rel == "." {
+		return best.path
+	}
+	return best.path + "/" + filepath.ToSlash(rel)
+}
+
+// ModulePath returns the path of the module dir is in, or an empty string in
+// GOPATH mode or if dir is outside of the modules listed so far.
+func (l *Loader) ModulePath(dir string) string {
+	if l.Remote != nil {
+		return ""
+	}
+	if l.fakeFiles == nil {
+		if err := l.addFakeFiles(); err != nil {
+			return ""
+		}
+	}
+	if mod := l.dirModule(dir); mod != nil {
+		return mod.path
+	}
+	return ""
+}
+
+// dirModule returns the module of the build list with the longest directory
+// containing dir, or nil if there is none.
+func (l *Loader) dirModule(dir string) *module {
+	var best *module
+	for i, mod := range l.modules {
+		if mod.dir == "" || !InDir(mod.dir, dir) {
+			continue
+		}
+		if best == nil || len(mod.dir) > len(best.dir) {
+			best = &l.modules[i]
+		}
+	}
+	return best
+}
+
 // Loader finds all of the gunk files in path.
 // Cached files are not loaded again.
 // No type checking or parsing is done.
@@ -118,12 +481,21 @@ func (l *Loader) Load(path string) ([]*GunkPackage, error) {
 		l.cache = make(map[string]*GunkPackage)
 	}
 	// use cache, if exists
-	if pkg := l.cache[path]; pkg != nil {
+	pkg := l.cache[path]
+	metrics.CacheLookup("packages", pkg != nil)
+	if pkg != nil {
+		pkg.used = time.Now()
 		if len(pkg.Package.Errors) > 0 {
 			return nil, fmt.Errorf("error loading package %q", path)
 		}
 		return []*GunkPackage{pkg}, nil
 	}
+	if l.Remote != nil {
+		return l.loadRemote(path)
+	}
+	// The module versions checked while loading are saved once done. The
+	// cache is only an optimization.
+	defer l.shared().Save()
 	// Generate fake files if it has not been initialized yet.
 	if l.fakeFiles == nil {
 		err := l.addFakeFiles()
@@ -131,29 +503,62 @@ func (l *Loader) Load(path string) ([]*GunkPackage, error) {
 			return nil, err
 		}
 	}
+	if err := l.addFakeFileFor(path); err != nil {
+		return nil, err
+	}
 	// Load the Gunk packages as Go packages.
 	var pkgs []*GunkPackage
 	cfg := &packages.Config{
 		Dir:     l.Dir,
+		Env:     l.env,
 		Mode:    packages.NeedName | packages.NeedFiles,
 		Overlay: l.fakeFiles,
 	}
-	lpkgs, err := packages.Load(cfg, path)
+	pattern := path
+	if l.gopathSrc != "" && !filepath.IsAbs(path) && !strings.HasSuffix(path, "/...") {
+		// In GOPATH mode, the go command only finds vendored packages
+		// from the package importing them, so load them by directory.
+		if dir := gopathImportDir(l.gopathSrc, l.gopath, l.Dir, path); dir != "" {
+			pattern = dir
+		}
+	}
+	start := time.Now()
+	lpkgs, err := packages.Load(cfg, pattern)
+	metrics.PackageLoad(time.Since(start))
 	if err != nil {
 		return nil, err
 	}
 	for _, lpkg := range lpkgs {
 		pkg := NewGunkPackage(*lpkg, Untracked)
-		findGunkFiles(pkg)
+		pkg.used = time.Now()
+		l.findGunkFiles(pkg)
 		if len(pkg.GunkFiles) == 0 && len(lpkg.Errors) == 0 {
 			// Not a Gunk package. Skip.
 			continue
 		}
+		if root := strings.TrimSuffix(path, "/..."); root != path && filepath.IsAbs(root) && !InDir(root, pkg.Dir) {
+			// Only the packages physically in the directory are
+			// loaded, even if a packages driver finds others.
+			// Dependencies are loaded as they are imported.
+			continue
+		}
+		if strings.HasSuffix(path, "/...") && l.excluded(pkg.Dir) {
+			// Packages with Go files are found in excluded
+			// directories too.
+			continue
+		}
 		pkgs = append(pkgs, pkg)
 	}
 	// Add the Gunk files to each package.
 	for _, pkg := range pkgs {
 		l.cache[pkg.PkgPath] = pkg
+		if pkg.Dir != "" && !InDir(l.Dir, pkg.Dir) {
+			l.indexDependency(pkg)
+		}
+	}
+	if pattern != path && len(pkgs) == 1 {
+		// Vendored packages have a different package path.
+		l.cache[path] = pkgs[0]
 	}
 	return pkgs, nil
 }
@@ -165,20 +570,40 @@ func (l *Loader) AddFile(pkgs []*GunkPackage, path, src string) ([]*GunkPackage,
 		l.InMemoryFiles = make(map[string]string)
 	}
 	l.InMemoryFiles[path] = src
+	l.IndexFile(path)
 	// Find the package that contains the file.
 	var pkg *GunkPackage
 	dir := filepath.Dir(path)
 	for _, p := range pkgs {
-		if dir == p.Dir {
-			if p.State == Untracked {
-				p.State = Dirty
-			}
+		if p.HasDir(dir) {
+			// Analyze the package again even if it was open, as it
+			// may have been evicted since.
+			p.State = Dirty
 			pkg = p
 			break
 		}
 	}
+	if pkg == nil && l.Remote != nil {
+		newPkgs, err := l.loadRemote(dir)
+		if err != nil {
+			return pkgs, nil, err
+		}
+		pkg = newPkgs[0]
+		pkg.State = Dirty
+		pkgs = append(pkgs, pkg)
+	}
 	// It's a new package, we can assume nothing imports it.
 	if pkg == nil {
+		// Nothing may have been loaded yet, for files opened outside of
+		// the workspace.
+		if l.cache == nil {
+			l.cache = make(map[string]*GunkPackage)
+		}
+		if l.fakeFiles == nil {
+			if err := l.addFakeFiles(); err != nil {
+				return pkgs, nil, err
+			}
+		}
 		pkgName := filepath.Base(dir)
 		f, err := parser.ParseFile(token.NewFileSet(), path, src, parser.PackageClauseOnly)
 		// Ignore errors, since Gunk packages being
@@ -217,10 +642,13 @@ func (l *Loader) AddFile(pkgs []*GunkPackage, path, src string) ([]*GunkPackage,
 		// Add new package.
 		cfg := &packages.Config{
 			Dir:     dir,
+			Env:     l.env,
 			Mode:    packages.NeedName | packages.NeedFiles,
 			Overlay: l.fakeFiles,
 		}
+		start := time.Now()
 		lpkgs, err := packages.Load(cfg, path)
+		metrics.PackageLoad(time.Since(start))
 		if err != nil {
 			return pkgs, nil, err
 		}
@@ -228,7 +656,8 @@ func (l *Loader) AddFile(pkgs []*GunkPackage, path, src string) ([]*GunkPackage,
 			return pkgs, nil, fmt.Errorf("unexpected number of packages: %d", len(lpkgs))
 		}
 		pkg = NewGunkPackage(*lpkgs[0], Dirty)
-		findGunkFiles(pkg)
+		l.findGunkFiles(pkg)
+		pkgs = append(pkgs, pkg)
 	}
 	var exists bool
 	for _, file := range pkg.GunkFiles {
@@ -237,26 +666,16 @@ func (l *Loader) AddFile(pkgs []*GunkPackage, path, src string) ([]*GunkPackage,
 			break
 		}
 	}
-	// The Gunk file is currently only in memory.
-	if !exists {
+	// The Gunk file is currently only in memory, unless it's excluded by
+	// its build constraints.
+	if !exists && !l.constrained(path) {
 		pkg.GunkFiles = append(pkg.GunkFiles, path)
 	}
 	// Add the file to the package.
 	// Remove all cached entries and imports that directly or indirectly
 	// import the package of the file.
 	delete(l.cache, pkg.PkgPath)
-	for _, p := range pkgs {
-		for pkgPath := range p.Imports {
-			if pkgPath == pkg.PkgPath {
-				p.Imports[pkgPath] = pkg.GunkPackage
-				// Mark as dirty, resend diagnostics for open packages that
-				// import this package.
-				if p.State == Open {
-					p.State = Dirty
-				}
-			}
-		}
-	}
+	l.invalidate(pkgs, pkg)
 	return pkgs, pkg, nil
 }
 
@@ -265,11 +684,12 @@ func (l *Loader) UpdateFile(pkgs []*GunkPackage, path, src string) ([]*GunkPacka
 		l.InMemoryFiles = make(map[string]string)
 	}
 	l.InMemoryFiles[path] = src
+	l.IndexFile(path)
 	// Find the package that contains the file.
 	var pkg *GunkPackage
 	dir := filepath.Dir(path)
 	for _, p := range pkgs {
-		if dir == p.Dir {
+		if p.HasDir(dir) {
 			p.State = Dirty
 			pkg = p
 			break
@@ -283,7 +703,7 @@ func (l *Loader) UpdateFile(pkgs []*GunkPackage, path, src string) ([]*GunkPacka
 			return pkgs, err
 		}
 	}
-	findGunkFiles(pkg)
+	l.findGunkFiles(pkg)
 	// Add the file to the package.
 	var exists bool
 	for _, file := range pkg.GunkFiles {
@@ -292,39 +712,29 @@ func (l *Loader) UpdateFile(pkgs []*GunkPackage, path, src string) ([]*GunkPacka
 			break
 		}
 	}
-	// The Gunk file is currently only in memory.
-	if !exists {
+	// The Gunk file is currently only in memory, unless it's excluded by
+	// its build constraints.
+	if !exists && !l.constrained(path) {
 		pkg.GunkFiles = append(pkg.GunkFiles, path)
 	}
 	// Remove all cached entries and imports that directly or indirectly
 	// import the package of the file.
 	delete(l.cache, pkg.PkgPath)
-	for _, p := range pkgs {
-		for pkgPath := range p.Imports {
-			if pkgPath == pkg.PkgPath {
-				// Mark as dirty, resend diagnostics for open packages that
-				// import this package.
-				if p.State == Open {
-					p.State = Dirty
-				}
-			}
-		}
-	}
+	l.invalidate(pkgs, pkg)
 	return pkgs, nil
 }
 
 func (l *Loader) CloseFile(pkgs []*GunkPackage, path string) ([]*GunkPackage, error) {
 	delete(l.InMemoryFiles, path)
+	// Index the contents on disk, if the file was saved.
+	l.IndexFile(path)
 	// Find the package that contains the file.
 	var pkg *GunkPackage
-	var index int
-
 	dir := filepath.Dir(path)
-	for i, p := range pkgs {
-		if dir == p.Dir {
+	for _, p := range pkgs {
+		if p.HasDir(dir) {
 			p.State = Dirty
 			pkg = p
-			index = i
 			break
 		}
 	}
@@ -332,49 +742,67 @@ func (l *Loader) CloseFile(pkgs []*GunkPackage, path string) ([]*GunkPackage, er
 		return pkgs, fmt.Errorf("could not find loaded package to close")
 	}
 	resetPackage(pkg)
-	findGunkFiles(pkg)
+	l.findGunkFiles(pkg)
 	if len(pkg.GunkFiles) == 0 {
-		pkgs = append(pkgs[:index], pkgs[index+1:]...)
+		// The file was never saved, or was removed or renamed since.
+		return l.removePackage(pkgs, pkg), nil
 	}
 	delete(l.cache, pkg.PkgPath)
-	for _, p := range pkgs {
-		for pkgPath := range p.Imports {
-			if pkgPath == pkg.PkgPath {
-				// Mark as dirty, resend diagnostics for open packages that
-				// import this package.
-				if p.State == Open {
-					p.State = Dirty
-				}
-			}
-		}
-	}
+	l.invalidate(pkgs, pkg)
 	return pkgs, nil
 }
 
 // findGunkFiles fills a package's GunkFiles field with the gunk files found in
-// the package directory. This is used when loading a Gunk package via an import
-// path or a directory.
+// the package directories. This is used when loading a Gunk package via an
+// import path or a directory.
 //
-// Note that this requires all the source files within the package to be in the
-// same directory, which is true for Go Modules and GOPATH, but not other build
-// systems like Bazel.
-func findGunkFiles(pkg *GunkPackage) {
+// The source files of a package are all in the same directory with Go Modules
+// and GOPATH, but other build systems like Bazel may spread them over several
+// directories, which are all searched. Files excluded by their build
+// constraints are left out.
+func (l *Loader) findGunkFiles(pkg *GunkPackage) {
+	var dirs []string
+	if pkg.Dir != "" {
+		dirs = append(dirs, pkg.Dir)
+	}
 	for _, gofile := range pkg.GoFiles {
 		dir := filepath.Dir(gofile)
-		if pkg.Dir == "" {
-			pkg.Dir = dir
-		} else if dir != pkg.Dir {
-			pkg.errorf(ListError, 0, nil, "multiple dirs for %s: %s %s",
-				pkg.PkgPath, pkg.Dir, dir)
-			return // we can't continue
+		if !containsString(dirs, dir) {
+			dirs = append(dirs, dir)
 		}
 	}
-	matches, err := filepath.Glob(filepath.Join(pkg.Dir, "*.gunk"))
-	if err != nil {
-		// can only be a malformed pattern; should never happen.
-		panic(err.Error())
+	if pkg.Dir == "" && len(dirs) > 0 {
+		pkg.Dir = dirs[0]
+	}
+	pkg.Dirs = dirs
+	var files []string
+	for _, dir := range dirs {
+		entries, err := l.fs().ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !entry.IsDir() && filepath.Ext(path) == ".gunk" && !l.constrained(path) {
+				files = append(files, path)
+			}
+		}
+	}
+	pkg.GunkFiles = files
+}
+
+// HasDir reports whether dir is one of the directories of the package.
+func (pkg *GunkPackage) HasDir(dir string) bool {
+	return containsString(pkg.Dirs, dir)
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
 	}
-	pkg.GunkFiles = matches
+	return false
 }
 
 func (l *Loader) Errors(pkgs []*GunkPackage, pkg *GunkPackage) (map[string][]protocol.Diagnostic, error) {
@@ -386,7 +814,12 @@ func (l *Loader) Errors(pkgs []*GunkPackage, pkg *GunkPackage) (map[string][]pro
 	resetPackage(pkg)
 	// Populate gunk package contents
 	l.ParsePackage(pkg, true)
-	l.validatePackage(pkg)
+	// Partial syntax trees aren't validated, as they are likely to be
+	// incomplete declarations.
+	if !pkg.HasParseErrors() {
+		l.validatePackage(pkg)
+		l.validateProtoNames(pkgs, pkg)
+	}
 
 	diagnostics := make(map[string][]protocol.Diagnostic)
 	for _, f := range pkg.GunkFiles {
@@ -420,7 +853,7 @@ func (l *Loader) Errors(pkgs []*GunkPackage, pkg *GunkPackage) (map[string][]pro
 			},
 			Code:     code,
 			Severity: 1,
-			Source:   "gunkls",
+			Source:   DiagnosticSource,
 			Message:  pErr.Msg,
 		}
 		diagnostics[pErr.File] = append(diagnostics[pErr.File], d)
@@ -438,16 +871,47 @@ func (l *Loader) Errors(pkgs []*GunkPackage, pkg *GunkPackage) (map[string][]pro
 // source.
 func (l *Loader) Import(path string) (*types.Package, error) {
 	if !strings.Contains(path, ".") {
-		cfg := &packages.Config{Mode: packages.LoadTypes}
+		// Standard library packages don't change, so they are only
+		// loaded once for all gunk packages using the same GOROOT.
+		goroot := getenv(l.env, "GOROOT")
+		if tpkg, ok := l.shared().std(goroot, path); ok {
+			return tpkg, nil
+		}
+		cfg := &packages.Config{
+			Dir:  l.Dir,
+			Env:  l.env,
+			Mode: packages.LoadTypes,
+		}
+		start := time.Now()
 		pkgs, err := packages.Load(cfg, path)
+		metrics.PackageLoad(time.Since(start))
 		if err != nil {
 			return nil, err
 		}
 		if len(pkgs) != 1 {
 			panic("expected go/packages.Load to return exactly one package")
 		}
+		l.shared().storeStd(goroot, path, pkgs[0].Types)
 		return pkgs[0].Types, nil
 	}
+	// Neither do the annotation packages of a module version, whose type
+	// information is kept across reloads of the module graph and
+	// evictions.
+	var version string
+	if isOptPath(path) && l.Remote == nil {
+		// The modules are listed again after a reload.
+		if l.fakeFiles == nil {
+			if err := l.addFakeFiles(); err != nil {
+				return nil, err
+			}
+		}
+		_, version, _ = l.importDir(path)
+	}
+	if version != "" {
+		if tpkg, ok := l.shared().opt(l.Fset, version, path); ok {
+			return tpkg, nil
+		}
+	}
 	pkgs, err := l.Load(path)
 	if err != nil {
 		return nil, err
@@ -467,9 +931,18 @@ func (l *Loader) Import(path string) (*types.Package, error) {
 	if pkg.Types == nil {
 		return nil, errors.New("package has errors")
 	}
+	if version != "" && len(pkg.Errors) == 0 {
+		l.shared().storeOpt(l.Fset, version, path, pkg.Types)
+	}
 	return pkg.Types, nil
 }
 
+// isOptPath reports whether path is an annotation package of the
+// github.com/gunk/opt module.
+func isOptPath(path string) bool {
+	return path == "github.com/gunk/opt" || strings.HasPrefix(path, "github.com/gunk/opt/")
+}
+
 type PackageState int
 
 const (
@@ -484,6 +957,18 @@ type GunkPackage struct {
 	Errors []Error
 
 	State PackageState
+
+	// Dirs are the directories of the package files, starting with Dir.
+	// Build systems such as Bazel may spread a package over several
+	// directories.
+	Dirs []string
+
+	// Stale is set on the copies of a package kept from its last
+	// successful analysis, after a later analysis failed.
+	Stale bool
+
+	// used is when the package was last loaded or parsed.
+	used time.Time
 }
 
 func NewGunkPackage(pkg packages.Package, state PackageState) *GunkPackage {
@@ -495,12 +980,37 @@ func NewGunkPackage(pkg packages.Package, state PackageState) *GunkPackage {
 	}
 }
 
+// Clone returns a copy of pkg that isn't affected by later changes to pkg,
+// such as it being parsed again. The syntax trees and type information are
+// shared, as they aren't modified once checked. The packages imported by pkg
+// are copied too, as they may be updated in place.
+func (pkg *GunkPackage) Clone() *GunkPackage {
+	inner := *pkg.GunkPackage
+	if pkg.Imports != nil {
+		inner.Imports = make(map[string]*loader.GunkPackage, len(pkg.Imports))
+		for path, imp := range pkg.Imports {
+			c := *imp
+			inner.Imports[path] = &c
+		}
+	}
+	return &GunkPackage{
+		GunkPackage: &inner,
+		Errors:      pkg.Errors,
+		State:       pkg.State,
+		Dirs:        pkg.Dirs,
+		used:        pkg.used,
+	}
+}
+
 func resetPackage(pkg *GunkPackage) {
 	pkg.GunkNames = nil
 	pkg.GunkSyntax = nil
+	pkg.GunkTags = nil
+	pkg.Imports = nil
 	pkg.ProtoName = ""
 	pkg.Errors = nil
 	pkg.Types = nil
+	pkg.TypesInfo = nil
 	pkg.Package = packages.Package{
 		ID:      pkg.Package.ID,
 		Name:    pkg.Package.Name,
@@ -523,3 +1033,11 @@ func getNode(pos token.Pos, file *ast.File) ast.Node {
 	})
 	return node
 }
+
+// logger returns the logger of the loader.
+func (l *Loader) logger() *zap.Logger {
+	if l.Logger == nil {
+		return zap.NewNop()
+	}
+	return l.Logger
+}
@@ -0,0 +1,76 @@
+package loader
+
+import (
+	"go/ast"
+)
+
+// MemoryStats describes what a loader holds in memory, by subsystem. The heap
+// can't be attributed to its owners, so the sizes are counts of what takes
+// most of it: syntax nodes, entries of type information, and symbols.
+type MemoryStats struct {
+	// Packages is the number of packages known to the loader, and Loaded
+	// the number of them that have syntax trees or type information.
+	Packages int `json:"packages"`
+	Loaded   int `json:"loaded"`
+
+	// Files is the number of parsed files, with the size of their sources
+	// and the number of nodes of their syntax trees.
+	Files       int `json:"files"`
+	SourceBytes int `json:"sourceBytes"`
+	SyntaxNodes int `json:"syntaxNodes"`
+	// OpenFiles is the number of files managed by the language server,
+	// with the size of their contents.
+	OpenFiles     int `json:"openFiles"`
+	OpenFileBytes int `json:"openFileBytes"`
+
+	// TypeInfoEntries is the number of entries in the type information of
+	// the loaded packages.
+	TypeInfoEntries int `json:"typeInfoEntries"`
+
+	// Symbols is the number of symbols in the symbol index.
+	Symbols int `json:"symbols"`
+}
+
+// MemoryStats returns what the loader holds in memory.
+func (l *Loader) MemoryStats() MemoryStats {
+	var s MemoryStats
+	// The cache has entries by import path and by directory.
+	pkgs := make(map[*GunkPackage]bool)
+	for _, pkg := range l.cache {
+		pkgs[pkg] = true
+	}
+	s.Packages = len(pkgs)
+	for pkg := range pkgs {
+		if pkg.GunkSyntax == nil && pkg.Types == nil {
+			continue
+		}
+		s.Loaded++
+		if info := pkg.TypesInfo; info != nil {
+			s.TypeInfoEntries += len(info.Types) + len(info.Defs) + len(info.Uses) +
+				len(info.Implicits) + len(info.Selections) + len(info.Scopes)
+		}
+	}
+	for _, p := range l.parsed {
+		if p.file == nil {
+			continue
+		}
+		s.Files++
+		if f := l.Fset.File(p.file.Pos()); f != nil {
+			s.SourceBytes += f.Size()
+		}
+		ast.Inspect(p.file, func(node ast.Node) bool {
+			if node != nil {
+				s.SyntaxNodes++
+			}
+			return true
+		})
+	}
+	for _, content := range l.InMemoryFiles {
+		s.OpenFiles++
+		s.OpenFileBytes += len(content)
+	}
+	for _, symbols := range l.symbols {
+		s.Symbols += len(symbols)
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+package loader
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// moduleFormat is the format of each module listed by "go list -m", as read
+// by parseModules. Modules replaced by a local directory have no version.
+const moduleFormat = `{{.Path}} {{if .Replace}}{{.Replace.Path}}@{{.Replace.Version}}{{else}}{{.Path}}@{{.Version}}{{end}} {{.Dir}}`
+
+// module is a module in the build list.
+type module struct {
+	path string
+	// version is the module path and version, such as
+	// "github.com/gunk/opt@v0.2.0", or empty if the module can change, as
+	// for the main module and modules replaced by a directory.
+	version string
+	dir     string
+}
+
+// parseModules parses the output of "go list -m" with moduleFormat.
+func parseModules(out []byte) []module {
+	var mods []module
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.SplitN(line, " ", 3)
+		for len(parts) < 3 {
+			parts = append(parts, "")
+		}
+		mod := module{path: parts[0], version: parts[1], dir: strings.TrimSpace(parts[2])}
+		if strings.HasSuffix(mod.version, "@") {
+			mod.version = ""
+		}
+		mods = append(mods, mod)
+	}
+	return mods
+}
+
+// parseVendoredModules parses the modules listed in vendor/modules.txt, which
+// are vendored in the vendor directory. Modules replaced by a local directory
+// have no version.
+func parseVendoredModules(b []byte, vendor string) []module {
+	var mods []module
+	for _, line := range strings.Split(string(b), "\n") {
+		// Module lines are "# path version", optionally followed by
+		// "=> replacement [version]".
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "#" {
+			continue
+		}
+		mod := module{
+			path:    fields[1],
+			version: fields[1] + "@" + fields[2],
+			dir:     filepath.Join(vendor, filepath.FromSlash(fields[1])),
+		}
+		if i := indexOf(fields, "=>"); i >= 0 {
+			switch rest := fields[i+1:]; len(rest) {
+			case 1:
+				mod.version = ""
+			case 2:
+				mod.version = rest[0] + "@" + rest[1]
+			}
+		}
+		mods = append(mods, mod)
+	}
+	return mods
+}
+
+// indexOf returns the index of s in list, or -1.
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// moduleCache persists, for each module version, whether the directories of
+// the module that have been loaded contain Gunk files. Directories are
+// relative to the module root.
+type moduleCache struct {
+	path    string
+	modules map[string]map[string]bool
+	changed bool
+}
+
+// loadModuleCache loads the cache from the user's cache directory. A missing
+// or invalid cache is treated as empty.
+func loadModuleCache() *moduleCache {
+	c := &moduleCache{modules: make(map[string]map[string]bool)}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return c
+	}
+	c.path = filepath.Join(dir, "gunkls", "modules.json")
+	if b, err := os.ReadFile(c.path); err == nil {
+		if err := json.Unmarshal(b, &c.modules); err != nil {
+			c.modules = make(map[string]map[string]bool)
+		}
+	}
+	return c
+}
+
+// lookup returns whether the directory of a module version has Gunk files,
+// if known.
+func (c *moduleCache) lookup(version, dir string) (anyGunk, ok bool) {
+	if version == "" {
+		return false, false
+	}
+	anyGunk, ok = c.modules[version][dir]
+	return anyGunk, ok
+}
+
+// store records whether the directory of a module version has Gunk files.
+// Directories of modules without a version can change, so they aren't
+// recorded.
+func (c *moduleCache) store(version, dir string, anyGunk bool) {
+	if version == "" {
+		return
+	}
+	if known, ok := c.modules[version][dir]; ok && known == anyGunk {
+		return
+	}
+	if c.modules[version] == nil {
+		c.modules[version] = make(map[string]bool)
+	}
+	c.modules[version][dir] = anyGunk
+	c.changed = true
+}
+
+// save writes the cache back to disk, if it changed.
+func (c *moduleCache) save() error {
+	if c.path == "" || !c.changed {
+		return nil
+	}
+	b, err := json.Marshal(c.modules)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	// Write atomically, as other servers may be reading or writing the
+	// cache.
+	f, err := os.CreateTemp(filepath.Dir(c.path), "modules-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		return err
+	}
+	c.changed = false
+	return nil
+}
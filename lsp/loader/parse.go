@@ -1,47 +1,68 @@
 package loader
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/parser"
 	"go/scanner"
 	"go/token"
 	"go/types"
-	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunkls/lsp/metrics"
+	"go.uber.org/zap"
 )
 
+// ReadFile returns the contents of a file, using the in memory contents if
+// the file is managed by the language server.
+func (l *Loader) ReadFile(path string) ([]byte, error) {
+	if contents, ok := l.InMemoryFiles[path]; ok {
+		return []byte(contents), nil
+	}
+	return l.fs().ReadFile(path)
+}
+
 // ParsePackage parses the package's GunkFiles, and type-checks the package
-// if l.Types is set.
+// if checkTypes or l.Types is set.
 func (l *Loader) ParsePackage(pkg *GunkPackage, checkTypes bool) {
 	// Clear the name before parsing to avoid Go files from triggering package
 	// name mismatch
 	pkg.Name = ""
-	l.cache[pkg.Dir] = pkg
+	pkg.used = time.Now()
+	for _, dir := range pkg.Dirs {
+		l.cache[dir] = pkg
+	}
 	var badPkgName bool
 	// parse the gunk files
 	for _, fpath := range pkg.GunkFiles {
-		var src []byte
-		if contents, ok := l.InMemoryFiles[fpath]; ok {
-			src = []byte(contents)
-		} else {
-			var err error
-			src, err = os.ReadFile(fpath)
-			if err != nil {
-				continue
-			}
-		}
-		file, err := parser.ParseFile(l.Fset, fpath, src, parser.ParseComments)
+		src, err := l.ReadFile(fpath)
 		if err != nil {
-			pkg.parseError(fpath, err)
 			continue
 		}
+		file, err := l.parseFile(fpath, src)
+		if err != nil {
+			if err := pkg.parseError(fpath, err); err != nil {
+				l.logger().Warn("unexpected parse error",
+					zap.String("path", fpath),
+					zap.String("type", fmt.Sprintf("%T", err)),
+					zap.Error(err))
+			}
+			// Keep the partial syntax tree of files with syntax
+			// errors, so that features such as completion and
+			// hover keep working while the file is being edited.
+			if file == nil {
+				continue
+			}
+		}
 		// to make the generated code independent of the current directory when
 		// running gunk
 		relPath := pkg.PkgPath + "/" + filepath.Base(fpath)
@@ -54,26 +75,78 @@ func (l *Loader) ParsePackage(pkg *GunkPackage, checkTypes bool) {
 			badPkgName = true
 		}
 	}
+	// The gunk files must also agree with the Go files in the directory.
+	if name := l.dirPkgName(pkg); name != pkg.Name {
+		pkg.Name = name
+		badPkgName = true
+	}
 	if badPkgName {
-		for i, f := range pkg.GunkFiles {
-			ast := pkg.GunkSyntax[i]
-			from := ast.Name.NamePos
-			to := from + token.Pos(len(ast.Name.Name))
-			pkg.error(f, from, to, l.Fset, "found more than one package name", ValidateError)
+		// Only report the files that don't belong, rather than every
+		// file in the package.
+		for _, f := range pkg.GunkSyntax {
+			if f.Name.Name == pkg.Name {
+				continue
+			}
+			msg := fmt.Sprintf("package %s does not match package %s of directory %s", f.Name.Name, pkg.Name, pkg.Dir)
+			pkg.error(l.Fset.Position(f.Pos()).Filename, f.Name.Pos(), f.Name.End(), l.Fset, msg, ValidateError)
+		}
+	}
+	if !l.inModule(pkg.Dir) {
+		for _, f := range pkg.GunkSyntax {
+			msg := fmt.Sprintf("directory %s is not inside any module; gunk files must be part of a Go module", pkg.Dir)
+			pkg.error(l.Fset.Position(f.Pos()).Filename, f.Name.Pos(), f.Name.End(), l.Fset, msg, ValidateError)
 		}
 	}
 	if pkg.ProtoName == "" {
 		pkg.ProtoName = pkg.Name
 	}
-	// the reported error will be handled by Diagnostics
-	if len(pkg.Errors) > 0 || !checkTypes {
+	// Record the imports before type checking, so that a package with type
+	// errors caused by an import is checked again when the import changes.
+	var importPaths []string
+	for _, file := range pkg.GunkSyntax {
+		for _, spec := range file.Imports {
+			pkgPath, _ := strconv.Unquote(spec.Path.Value)
+			importPaths = append(importPaths, pkgPath)
+		}
+	}
+	l.setImports(pkg, importPaths)
+	// the reported error will be handled by Diagnostics. Packages whose
+	// only errors are syntax errors are still type checked as far as they
+	// parsed, but their type errors aren't reported, as most of them would
+	// follow from the syntax errors. Validation errors, such as a package
+	// outside of any module, don't keep the package from being checked.
+	broken := false
+	for _, e := range pkg.Errors {
+		switch e.Kind {
+		case ParseError:
+			broken = true
+		case ValidateError:
+		default:
+			return
+		}
+	}
+	if !checkTypes && !l.Types {
 		return
 	}
-	pkg.Types = types.NewPackage(pkg.PkgPath, pkg.Name)
+	// Files in the wrong package have already been reported, so check the
+	// files agreeing with the first one rather than failing on the others.
+	name, files := pkg.Name, pkg.GunkSyntax
+	if badPkgName && len(pkg.GunkSyntax) > 0 {
+		name, files = pkg.GunkSyntax[0].Name.Name, nil
+		for _, f := range pkg.GunkSyntax {
+			if f.Name.Name == name {
+				files = append(files, f)
+			}
+		}
+	}
+	pkg.Types = types.NewPackage(pkg.PkgPath, name)
 	tconfig := &types.Config{
 		DisableUnusedImportCheck: true,
 		Importer:                 l,
 		Error: func(e error) {
+			if broken {
+				return
+			}
 			if err, ok := e.(types.Error); ok {
 				pos := err.Fset.Position(err.Pos)
 
@@ -106,7 +179,7 @@ func (l *Loader) ParsePackage(pkg *GunkPackage, checkTypes bool) {
 		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 	}
 	check := types.NewChecker(tconfig, l.Fset, pkg.Types, pkg.TypesInfo)
-	err := check.Files(pkg.GunkSyntax)
+	err := check.Files(files)
 	if err != nil {
 		return
 	}
@@ -115,6 +188,12 @@ func (l *Loader) ParsePackage(pkg *GunkPackage, checkTypes bool) {
 	pkg.Imports = make(map[string]*loader.GunkPackage)
 	for _, file := range pkg.GunkSyntax {
 		l.splitGunkTags(pkg, file)
+		// Capture the proto.Package annotation, like gunk generate.
+		for _, tag := range pkg.GunkTags[file] {
+			if tag.Type != nil && tag.Type.String() == "github.com/gunk/opt/proto.Package" && tag.Value != nil {
+				pkg.ProtoName = constant.StringVal(tag.Value)
+			}
+		}
 		for _, spec := range file.Imports {
 			// we can't error, since the file parsed correctly
 			pkgPath, _ := strconv.Unquote(spec.Path.Value)
@@ -127,12 +206,117 @@ func (l *Loader) ParsePackage(pkg *GunkPackage, checkTypes bool) {
 	}
 }
 
+// parsedFile is a parsed gunk file, along with the hash of the contents it
+// was parsed from.
+type parsedFile struct {
+	hash [sha256.Size]byte
+	file *ast.File
+	err  error
+}
+
+// parseFile parses a gunk file, reusing the syntax tree of the last parse if
+// the contents haven't changed since.
+func (l *Loader) parseFile(path string, src []byte) (*ast.File, error) {
+	hash := sha256.Sum256(src)
+	p, ok := l.parsed[path]
+	metrics.CacheLookup("syntax", ok && p.hash == hash)
+	if ok && p.hash == hash {
+		return p.file, p.err
+	}
+	file, err := parser.ParseFile(l.Fset, path, src, parser.ParseComments)
+	if file != nil {
+		moveSpecDocs(file)
+	}
+	if l.parsed == nil {
+		l.parsed = make(map[string]parsedFile)
+	}
+	l.parsed[path] = parsedFile{hash: hash, file: file, err: err}
+	return file, err
+}
+
+// moveSpecDocs moves the doc of declarations with a single spec to the spec,
+// since we want +gunk tags attached to the type specs. This is done once when
+// parsing, as syntax trees are shared and must not be modified once analyzed.
+func moveSpecDocs(file *ast.File) {
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || len(gd.Specs) != 1 {
+			continue
+		}
+		if doc := nodeDoc(gd.Specs[0]); doc != nil {
+			*doc = gd.Doc
+		}
+	}
+}
+
+// dirPkgName returns the package name of a package's directory. The name is
+// taken from the Go files in the directory if there are any, and otherwise
+// is the name used by most of the gunk files.
+func (l *Loader) dirPkgName(pkg *GunkPackage) string {
+	for _, gofile := range pkg.GoFiles {
+		if _, ok := l.fakeFiles[gofile]; ok {
+			continue
+		}
+		src, err := l.ReadFile(gofile)
+		if err != nil {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), gofile, src, parser.PackageClauseOnly)
+		if err == nil {
+			return f.Name.Name
+		}
+	}
+	var name string
+	count := make(map[string]int)
+	for _, f := range pkg.GunkSyntax {
+		count[f.Name.Name]++
+		// Ties go to the first file.
+		if count[f.Name.Name] > count[name] {
+			name = f.Name.Name
+		}
+	}
+	return name
+}
+
+// inModule reports whether dir is inside one of the module roots found when
+// walking for gunk packages, listing the dependencies if it isn't inside a
+// main module. It always reports true in GOPATH mode, where there are no
+// module roots.
+func (l *Loader) inModule(dir string) bool {
+	if len(l.roots) == 0 || dir == "" {
+		return true
+	}
+	for _, root := range l.roots {
+		if InDir(root, dir) {
+			return true
+		}
+	}
+	if l.listDependencies() {
+		return l.inModule(dir)
+	}
+	return false
+}
+
+// Bounds of protobuf field numbers.
+const (
+	minSequence = 1
+	maxSequence = 1<<29 - 1
+	// Numbers reserved for the protobuf implementation.
+	reservedSequenceStart = 19000
+	reservedSequenceEnd   = 19999
+)
+
 // validatePackage sanity checks a gunk package, to find common errors which are
 // shared among all gunk commands.
 func (l *Loader) validatePackage(pkg *GunkPackage) {
+	l.validateRPCNames(pkg)
 	for i, file := range pkg.GunkSyntax {
 		path := pkg.GunkFiles[i]
 		ast.Inspect(file, func(node ast.Node) bool {
+			if it, ok := node.(*ast.InterfaceType); ok {
+				l.validateMethods(pkg, path, it)
+				return true
+			}
 			st, ok := node.(*ast.StructType)
 			if !ok || st.Fields == nil {
 				return true
@@ -140,9 +324,10 @@ func (l *Loader) validatePackage(pkg *GunkPackage) {
 			// Look through all fields for anonymous/unnamed types.
 			for _, field := range st.Fields.List {
 				if len(field.Names) < 1 {
-					pkg.error(path, field.Pos(), field.End(), l.Fset, "anonymous struct fields are not supported", TypeError)
+					pkg.error(path, field.Pos(), field.End(), l.Fset, "embedded fields are not supported; declare a named field of the message type instead", ValidateError)
 					return false
 				}
+				l.validateFieldType(pkg, path, field.Type)
 			}
 			// Check for struct tag 'pb' and ensure that if it does exist
 			// it is a valid integer, and it is unique in that struct.
@@ -180,6 +365,16 @@ func (l *Loader) validatePackage(pkg *GunkPackage) {
 					pkg.error(path, tag.Pos(), tag.End(), l.Fset, msg, ValidateError)
 					continue
 				}
+				if sequence < minSequence || sequence > maxSequence {
+					msg := fmt.Sprintf("sequence number %d out of range [%d, %d]", sequence, minSequence, maxSequence)
+					pkg.error(path, tag.Pos(), tag.End(), l.Fset, msg, ValidateError)
+					continue
+				}
+				if sequence >= reservedSequenceStart && sequence <= reservedSequenceEnd {
+					msg := fmt.Sprintf("sequence number %d is reserved by protobuf [%d, %d]", sequence, reservedSequenceStart, reservedSequenceEnd)
+					pkg.error(path, tag.Pos(), tag.End(), l.Fset, msg, ValidateError)
+					continue
+				}
 				if usedSequences[sequence] != nil {
 					msg := fmt.Sprintf("sequence number %q seen twice", val)
 					pkg.error(path, tag.Pos(), tag.End(), l.Fset, msg, ValidateError)
@@ -192,23 +387,226 @@ func (l *Loader) validatePackage(pkg *GunkPackage) {
 	}
 }
 
+// validateFieldType checks that the type of a message field is one gunk can
+// generate, looking through repeated and map fields.
+func (l *Loader) validateFieldType(pkg *GunkPackage, path string, expr ast.Expr) {
+	switch t := expr.(type) {
+	case *ast.ArrayType:
+		l.validateFieldType(pkg, path, t.Elt)
+	case *ast.MapType:
+		l.validateMapKey(pkg, path, t.Key)
+		l.validateFieldType(pkg, path, t.Value)
+	case *ast.ChanType:
+		pkg.error(path, t.Pos(), t.End(), l.Fset, "channels are not supported in messages; use them as method parameters or results to declare streams", ValidateError)
+	default:
+		if msg := unsupportedType(expr); msg != "" {
+			pkg.error(path, expr.Pos(), expr.End(), l.Fset, msg, ValidateError)
+		}
+	}
+}
+
+// unsupportedType returns an error message explaining what to use instead
+// of expr, if it is a Go type with no protobuf equivalent.
+func unsupportedType(expr ast.Expr) string {
+	switch expr.(type) {
+	case *ast.StarExpr:
+		return "pointers are not supported; use the type directly, as all message fields are optional"
+	case *ast.FuncType:
+		return "function types are not supported; declare a method in a service instead"
+	case *ast.InterfaceType:
+		return "interfaces are only supported as services, not as field types"
+	case *ast.Ellipsis:
+		return "variadic parameters are not supported; use a message with a repeated field instead"
+	}
+	return ""
+}
+
+// validateMapKey checks that the key of a map field is a type protobuf allows
+// as a map key, which are integral types, bools and strings.
+func (l *Loader) validateMapKey(pkg *GunkPackage, path string, key ast.Expr) {
+	valid := false
+	if pkg.TypesInfo != nil {
+		tv, ok := pkg.TypesInfo.Types[key]
+		if !ok || tv.Type == nil {
+			// Type errors are already reported.
+			return
+		}
+		// Named types, including enums, are not allowed.
+		if basic, ok := tv.Type.(*types.Basic); ok {
+			valid = basic.Info()&(types.IsInteger|types.IsBoolean|types.IsString) != 0 &&
+				basic.Kind() != types.Int8 && basic.Kind() != types.Int16 &&
+				basic.Kind() != types.Uint8 && basic.Kind() != types.Uint16 && basic.Kind() != types.Uintptr
+		}
+	} else if ident, ok := key.(*ast.Ident); ok {
+		switch ident.Name {
+		case "string", "bool", "int", "int32", "int64", "uint", "uint32", "uint64":
+			valid = true
+		}
+	}
+	if !valid {
+		msg := fmt.Sprintf("invalid map key type %s: only integers, bools and strings are allowed", types.ExprString(key))
+		pkg.error(path, key.Pos(), key.End(), l.Fset, msg, ValidateError)
+	}
+}
+
+// validateRPCNames checks that the methods of all services in a package,
+// across all files, generate distinct RPC identifiers. Generated gRPC code
+// names handlers and streams as Service_Method, so Foo.Bar_Baz and
+// Foo_Bar.Baz would conflict, both being Foo_Bar_Baz, as would methods only
+// differing by case in the same service.
+func (l *Loader) validateRPCNames(pkg *GunkPackage) {
+	type rpc struct {
+		service string
+		method  *ast.Ident
+	}
+	seen := make(map[string]rpc)
+	for i, file := range pkg.GunkSyntax {
+		path := pkg.GunkFiles[i]
+		for _, decl := range file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				it, ok := ts.Type.(*ast.InterfaceType)
+				if !ok {
+					continue
+				}
+				methods := make(map[string]*ast.Ident)
+				for _, m := range it.Methods.List {
+					if len(m.Names) != 1 {
+						continue
+					}
+					name := m.Names[0]
+					if prev, ok := methods[strings.ToLower(name.Name)]; ok && prev.Name != name.Name {
+						msg := fmt.Sprintf("method %s conflicts with method %s of %s", name.Name, prev.Name, ts.Name.Name)
+						pkg.error(path, name.Pos(), name.End(), l.Fset, msg, ValidateError)
+					}
+					methods[strings.ToLower(name.Name)] = name
+					id := ts.Name.Name + "_" + name.Name
+					if prev, ok := seen[id]; ok && prev.service != ts.Name.Name {
+						at := l.Fset.Position(prev.method.Pos())
+						msg := fmt.Sprintf("method %s.%s generates the same RPC identifier %s as %s.%s (%s:%d)",
+							ts.Name.Name, name.Name, id, prev.service, prev.method.Name, filepath.Base(at.Filename), at.Line)
+						pkg.error(path, name.Pos(), name.End(), l.Fset, msg, ValidateError)
+						continue
+					}
+					seen[id] = rpc{service: ts.Name.Name, method: name}
+				}
+			}
+		}
+	}
+}
+
+// validateProtoNames checks that the messages, enums and services of a
+// package don't conflict with the ones of other packages using the same proto
+// package name. Conflicts are reported at the proto.Package annotation, or at
+// the package clause if the proto name is the package name.
+func (l *Loader) validateProtoNames(pkgs []*GunkPackage, pkg *GunkPackage) {
+	if pkg.ProtoName == "" || pkg.Types == nil {
+		return
+	}
+	for _, other := range pkgs {
+		if other == pkg || other.ProtoName != pkg.ProtoName || other.Types == nil {
+			continue
+		}
+		for _, name := range pkg.Types.Scope().Names() {
+			if _, ok := pkg.Types.Scope().Lookup(name).(*types.TypeName); !ok {
+				continue
+			}
+			if _, ok := other.Types.Scope().Lookup(name).(*types.TypeName); !ok {
+				continue
+			}
+			msg := fmt.Sprintf("%s.%s is also declared in %s, which uses the same proto package",
+				pkg.ProtoName, name, other.PkgPath)
+			for i, file := range pkg.GunkSyntax {
+				var at ast.Node = file.Name
+				if file.Doc != nil {
+					for _, c := range file.Doc.List {
+						if strings.Contains(c.Text, "proto.Package(") {
+							at = c
+						}
+					}
+				}
+				pkg.error(pkg.GunkFiles[i], at.Pos(), at.End(), l.Fset, msg, ValidateError)
+			}
+		}
+	}
+}
+
+// validateMethods checks that the methods of a service have at most one
+// parameter and result, and that they are messages or streams of messages.
+func (l *Loader) validateMethods(pkg *GunkPackage, path string, it *ast.InterfaceType) {
+	for _, m := range it.Methods.List {
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok {
+			pkg.error(path, m.Pos(), m.End(), l.Fset, "embedded interfaces are not supported; declare the methods in the service instead", ValidateError)
+			continue
+		}
+		for _, fields := range []*ast.FieldList{ft.Params, ft.Results} {
+			if fields == nil {
+				continue
+			}
+			kind := "parameter"
+			if fields == ft.Results {
+				kind = "result"
+			}
+			count := 0
+			for _, field := range fields.List {
+				n := len(field.Names)
+				if n == 0 {
+					n = 1
+				}
+				count += n
+				if count > 1 {
+					msg := fmt.Sprintf("methods can have at most one %s", kind)
+					pkg.error(path, field.Pos(), field.End(), l.Fset, msg, ValidateError)
+					break
+				}
+				l.validateMessageType(pkg, path, field.Type, kind)
+			}
+		}
+	}
+}
+
+// validateMessageType checks that the type of a method parameter or result is
+// a message, or a stream of messages.
+func (l *Loader) validateMessageType(pkg *GunkPackage, path string, expr ast.Expr, kind string) {
+	typ := expr
+	if ch, ok := typ.(*ast.ChanType); ok {
+		typ = ch.Value
+	}
+	if msg := unsupportedType(typ); msg != "" {
+		pkg.error(path, typ.Pos(), typ.End(), l.Fset, msg, ValidateError)
+		return
+	}
+	switch typ.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+	default:
+		msg := fmt.Sprintf("method %s must be a message or a stream (chan) of messages", kind)
+		pkg.error(path, expr.Pos(), expr.End(), l.Fset, msg, ValidateError)
+		return
+	}
+	if pkg.TypesInfo == nil {
+		return
+	}
+	tv, ok := pkg.TypesInfo.Types[typ]
+	if !ok || tv.Type == nil {
+		return
+	}
+	if _, ok := tv.Type.Underlying().(*types.Struct); !ok {
+		msg := fmt.Sprintf("method %s must be a message, found %s", kind, tv.Type)
+		pkg.error(path, typ.Pos(), typ.End(), l.Fset, msg, ValidateError)
+	}
+}
+
 // splitGunkTags parses and typechecks gunk tags from the comments in a Gunk
 // file, adding them to pkg.GunkTags and removing the source lines from each
 // comment.
 func (l *Loader) splitGunkTags(pkg *GunkPackage, file *ast.File) {
 	// hadError := false
 	ast.Inspect(file, func(node ast.Node) bool {
-		if gd, ok := node.(*ast.GenDecl); ok {
-			if len(gd.Specs) != 1 {
-				return true
-			}
-			if doc := nodeDoc(gd.Specs[0]); doc != nil {
-				// Move the doc to the only spec, since we want
-				// +gunk tags attached to the type specs.
-				*doc = gd.Doc
-			}
-			return true
-		}
 		doc := nodeDoc(node)
 		if doc == nil {
 			return true
@@ -280,7 +678,8 @@ func CommentFromText(orig ast.Node, text string) *ast.CommentGroup {
 // documentation and the tags Go expressions.
 //
 // If pkg is not nil, the tag is also type-checked using the package's type
-// information.
+// information, and the uses of objects in it are added to pkg.TypesInfo, so
+// that references and renames include them.
 func SplitGunkTag(pkg *GunkPackage, fset *token.FileSet, comment *ast.CommentGroup) (string, []loader.GunkTag, error) {
 	// Remove the comment leading and / or trailing identifier; // and /* */ and `
 	docLines := strings.Split(comment.Text(), "\n")
@@ -317,11 +716,18 @@ func SplitGunkTag(pkg *GunkPackage, fset *token.FileSet, comment *ast.CommentGro
 		}
 		tag := loader.GunkTag{Expr: expr}
 		if pkg != nil {
-			tv, err := types.Eval(fset, pkg.Types, comment.Pos(), gunkTag)
-			if err != nil {
+			info := &types.Info{
+				Types: make(map[ast.Expr]types.TypeAndValue),
+				Uses:  make(map[*ast.Ident]types.Object),
+			}
+			if err := types.CheckExpr(fset, pkg.Types, comment.Pos(), expr, info); err != nil {
 				return "", nil, err
 			}
+			tv := info.Types[expr]
 			tag.Type, tag.Value = tv.Type, tv.Value
+			if pkg.TypesInfo != nil {
+				recordTagUses(pkg.TypesInfo, fset, comment, i, info.Uses)
+			}
 		}
 		tags = append(tags, tag)
 	}
@@ -330,6 +736,51 @@ func SplitGunkTag(pkg *GunkPackage, fset *token.FileSet, comment *ast.CommentGro
 	return strings.TrimSpace(strComment), tags, nil
 }
 
+// recordTagUses adds the uses of objects in the n-th gunk tag of a comment to
+// info. The tag was parsed on its own, so the identifiers are recorded again
+// with their positions in the comment.
+func recordTagUses(info *types.Info, fset *token.FileSet, comment *ast.CommentGroup, n int, uses map[*ast.Ident]types.Object) {
+	start := -1
+	for i, c := range comment.List {
+		if strings.HasPrefix(lineCommentText(c), "+gunk ") {
+			if n == 0 {
+				start = i
+				break
+			}
+			n--
+		}
+	}
+	if start < 0 {
+		return
+	}
+	for ident, obj := range uses {
+		pos := fset.Position(ident.Pos())
+		i := start + pos.Line - 1
+		if i >= len(comment.List) {
+			continue
+		}
+		c := comment.List[i]
+		// Each line of the tag is the text of a comment, without the
+		// comment marker and the space after it.
+		offset := len(c.Text) - len(lineCommentText(c)) + pos.Column - 1
+		if offset > len(c.Text) || !strings.HasPrefix(c.Text[offset:], ident.Name) {
+			// The tag doesn't map to the comment lines, such as
+			// in a block comment.
+			continue
+		}
+		info.Uses[&ast.Ident{NamePos: c.Slash + token.Pos(offset), Name: ident.Name}] = obj
+	}
+}
+
+// lineCommentText returns the text of a line comment, like
+// ast.CommentGroup.Text. Block comments are returned as is.
+func lineCommentText(c *ast.Comment) string {
+	if !strings.HasPrefix(c.Text, "//") {
+		return c.Text
+	}
+	return strings.TrimPrefix(c.Text[2:], " ")
+}
+
 // ErrorAbsolutePos modifies all positions in err, considered to be relative to
 // pos. This is useful so that the position information of syntax tree nodes
 // parsed from a comment are relative to the entire file, and not only relative
@@ -349,3 +800,28 @@ func ErrorAbsolutePos(err error, pos token.Position) error {
 	}
 	return list
 }
+
+// deprecatedTag matches a gunk tag marking a declaration as deprecated, such
+// as "+gunk field.Deprecated(true)".
+var deprecatedTag = regexp.MustCompile(`^\+gunk\s+\w+\.Deprecated\(\s*true\s*\)`)
+
+// Deprecation reports whether the documentation of a declaration marks it as
+// deprecated, either with a Deprecated gunk tag or a paragraph starting with
+// "Deprecated: ". The deprecation note is returned, if there is one.
+func Deprecation(doc *ast.CommentGroup) (string, bool) {
+	if doc == nil {
+		return "", false
+	}
+	deprecated := false
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		if deprecatedTag.MatchString(line) {
+			deprecated = true
+		}
+	}
+	for _, para := range strings.Split(doc.Text(), "\n\n") {
+		if note := strings.TrimPrefix(para, "Deprecated: "); note != para {
+			return strings.Join(strings.Fields(note), " "), true
+		}
+	}
+	return "", deprecated
+}
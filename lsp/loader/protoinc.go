@@ -0,0 +1,113 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProtoIncludeDirs are the directories searched for the .proto files
+// referenced by a .gunkconfig, relative to its directory, where they are
+// usually vendored by hand or by buf.
+var ProtoIncludeDirs = []string{".", "vendor", "third_party", "proto"}
+
+// bundledProtos are the .proto files that protoc and gunk provide, which
+// don't need to be vendored.
+var bundledProtos = map[string]bool{
+	"google/api/annotations.proto":                   true,
+	"google/api/http.proto":                          true,
+	"protoc-gen-openapiv2/options/annotations.proto": true,
+	"protoc-gen-openapiv2/options/openapiv2.proto":   true,
+	"xo/xo.proto": true,
+}
+
+// ProtoRef is a .proto file referenced by a generate section of a
+// .gunkconfig, with a protoc-gen-go style parameter mapping it to a Go
+// package, such as:
+//
+//	Mgoogle/type/date.proto=google.golang.org/genproto/googleapis/type/date
+type ProtoRef struct {
+	// Config is the path of the .gunkconfig, and Key the parameter.
+	Config string
+	Key    string
+	// Name is the path of the .proto file, relative to an include
+	// directory.
+	Name string
+	// Path is the file Name resolves to, or empty if it wasn't found.
+	Path string
+}
+
+// Bundled reports whether the file is provided by protoc or gunk, which is
+// the case of the well-known types.
+func (r ProtoRef) Bundled() bool {
+	return strings.HasPrefix(r.Name, "google/protobuf/") || bundledProtos[r.Name]
+}
+
+// ProtoRefs returns the .proto files referenced by the generate sections of
+// the configuration of dir, sorted by .gunkconfig and parameter. Files are
+// resolved relative to the directory of the .gunkconfig referencing them, so
+// that a .gunkconfig shared by several packages has the same references for
+// all of them.
+func (l *Loader) ProtoRefs(dir string) ([]ProtoRef, error) {
+	cfg, err := l.Config(dir)
+	if err != nil {
+		return nil, err
+	}
+	var refs []ProtoRef
+	seen := make(map[ProtoRef]bool)
+	for _, gen := range cfg.Generators {
+		for _, p := range gen.Params {
+			if !strings.HasPrefix(p.Key, "M") || !strings.HasSuffix(p.Key, ".proto") {
+				continue
+			}
+			ref := ProtoRef{
+				Config: filepath.Join(gen.ConfigDir, ".gunkconfig"),
+				Key:    p.Key,
+				Name:   strings.TrimPrefix(p.Key, "M"),
+			}
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			ref.Path = l.ResolveProto(gen.ConfigDir, ref.Name)
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Config != refs[j].Config {
+			return refs[i].Config < refs[j].Config
+		}
+		return refs[i].Key < refs[j].Key
+	})
+	return refs, nil
+}
+
+// ResolveProto returns the vendored .proto file that name, as passed to
+// protoc, refers to for the gunk files of dir. The include directories of
+// the .gunkconfig files of dir and its parents are searched, nearest first.
+// It returns an empty string if the file isn't found.
+func (l *Loader) ResolveProto(dir, name string) string {
+	if l.Remote != nil {
+		return ""
+	}
+	cfg, err := l.Config(dir)
+	if err != nil {
+		return ""
+	}
+	roots := []string{cfg.Dir}
+	for _, gen := range cfg.Generators {
+		if !containsString(roots, gen.ConfigDir) {
+			roots = append(roots, gen.ConfigDir)
+		}
+	}
+	for _, root := range roots {
+		for _, inc := range ProtoIncludeDirs {
+			path := filepath.Join(root, inc, filepath.FromSlash(name))
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
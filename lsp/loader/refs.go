@@ -0,0 +1,44 @@
+package loader
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// References is an index from objects to the identifiers that refer to them,
+// across all type checked packages.
+type References map[types.Object][]Reference
+
+// Reference is a single use of an object.
+type Reference struct {
+	File  string
+	Ident *ast.Ident
+}
+
+// NewReferences builds the reference index of the given packages, including
+// the uses in their gunk tags. Packages that have not been type checked are
+// skipped.
+//
+// Packages share type information through Loader.Import, so an object
+// declared in one package is the same object when used from its importers.
+func NewReferences(fset *token.FileSet, pkgs []*GunkPackage) References {
+	refs := make(References)
+	for _, pkg := range pkgs {
+		refs.Add(fset, pkg)
+	}
+	return refs
+}
+
+// Add adds the uses of a type checked package to the index.
+func (refs References) Add(fset *token.FileSet, pkg *GunkPackage) {
+	if pkg.TypesInfo == nil {
+		return
+	}
+	for ident, obj := range pkg.TypesInfo.Uses {
+		refs[obj] = append(refs[obj], Reference{
+			File:  fset.Position(ident.Pos()).Filename,
+			Ident: ident,
+		})
+	}
+}
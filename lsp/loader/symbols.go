@@ -0,0 +1,275 @@
+package loader
+
+import (
+	"go/ast"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"go.lsp.dev/protocol"
+)
+
+// Symbol is a declaration in a gunk file: a message, enum, service, or one of
+// their fields, values or methods.
+type Symbol struct {
+	// Name is the name of the declaration. Members are qualified with the
+	// name of their message, enum or service, such as "Message.Field".
+	Name string
+	Kind protocol.SymbolKind
+	File string
+	// Range is the range of the identifier declaring the symbol, and
+	// DeclRange the range of the whole declaration, such as a message
+	// with its fields. Neither the nodes nor their positions are kept, so
+	// that the index doesn't keep syntax trees in memory or depend on the
+	// file set.
+	Range     protocol.Range
+	DeclRange protocol.Range
+	// Deprecated is set if the documentation of the declaration marks it
+	// as deprecated.
+	Deprecated bool
+}
+
+// SymbolIndex indexes the symbols declared in gunk files, by file. An index
+// is never modified once returned by the loader, so it can be searched
+// without holding the loader's lock.
+type SymbolIndex map[string][]Symbol
+
+// Symbols returns the current symbol index.
+func (l *Loader) Symbols() SymbolIndex {
+	return l.symbols
+}
+
+// IndexPackages adds the symbols of all files of pkgs to the symbol index. The
+// imports of the packages are recorded too, so that their importers are known
+// before they are analyzed.
+func (l *Loader) IndexPackages(pkgs []*GunkPackage) {
+	idx := l.symbols.clone()
+	for _, pkg := range pkgs {
+		for _, path := range pkg.GunkFiles {
+			l.indexFile(idx, path)
+		}
+		if pkg.GunkSyntax == nil {
+			l.setImports(pkg, l.ImportPaths([]*GunkPackage{pkg}))
+		}
+	}
+	l.symbols = idx
+}
+
+// IndexDependencies adds the symbols of the gunk files of the modules of the
+// build list outside of the workspace to the symbol index, such as the API
+// packages shared by other teams, so that they can be found before they are
+// imported. The build list is listed first if loading didn't need it, which
+// is why this is meant to run in the background. The files indexed by a
+// previous call are removed first.
+func (l *Loader) IndexDependencies() error {
+	if l.Remote != nil {
+		return nil
+	}
+	if l.fakeFiles == nil {
+		if err := l.addFakeFiles(); err != nil {
+			return err
+		}
+	}
+	l.listDependencies()
+	idx := l.symbols.clone()
+	for _, path := range l.dependencies {
+		delete(idx, path)
+	}
+	l.dependencies = nil
+	for _, mod := range l.modules {
+		if mod.dir == "" || InDir(l.Dir, mod.dir) {
+			continue
+		}
+		filepath.WalkDir(mod.dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() {
+				// Skip the directories ignored by the go command,
+				// and nested modules, which are listed on their
+				// own if they are dependencies.
+				name := entry.Name()
+				if path != mod.dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+					name == "testdata" || name == "vendor" || IsModule(path)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) == ".gunk" {
+				l.indexFile(idx, path)
+				l.dependencies = append(l.dependencies, path)
+			}
+			return nil
+		})
+	}
+	l.symbols = idx
+	return nil
+}
+
+// indexDependency adds the symbols of a dependency package to the symbol
+// index once it is loaded, so that they can be found from the workspace
+// before IndexDependencies gets to them.
+func (l *Loader) indexDependency(pkg *GunkPackage) {
+	idx := l.symbols.clone()
+	for _, path := range pkg.GunkFiles {
+		l.indexFile(idx, path)
+		if !containsString(l.dependencies, path) {
+			l.dependencies = append(l.dependencies, path)
+		}
+	}
+	l.symbols = idx
+}
+
+// IndexFile updates the symbols of a file in the symbol index, using its
+// current contents. Files that can't be read are removed from the index.
+func (l *Loader) IndexFile(path string) {
+	idx := l.symbols.clone()
+	l.indexFile(idx, path)
+	l.symbols = idx
+}
+
+// unindexFiles removes files from the symbol index, such as files that are
+// still on disk but no longer part of the workspace.
+func (l *Loader) unindexFiles(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	idx := l.symbols.clone()
+	for _, path := range paths {
+		delete(idx, path)
+	}
+	l.symbols = idx
+}
+
+// indexFile updates the symbols of a file in idx, which must not be
+// published yet. Files excluded by their build constraints aren't indexed.
+func (l *Loader) indexFile(idx SymbolIndex, path string) {
+	delete(idx, path)
+	src, err := l.ReadFile(path)
+	if err != nil || l.constrainedSource(src) {
+		return
+	}
+	// Files with syntax errors are still indexed, as far as they parsed.
+	file, _ := l.parseFile(path, src)
+	if file == nil {
+		return
+	}
+	idx[path] = fileSymbols(l.Fset, path, file)
+}
+
+// clone returns a copy of the index that can be modified. The symbols
+// themselves are shared.
+func (idx SymbolIndex) clone() SymbolIndex {
+	c := make(SymbolIndex, len(idx)+1)
+	for path, syms := range idx {
+		c[path] = syms
+	}
+	return c
+}
+
+// Search returns the symbols whose name contains query, ignoring case. An
+// empty query matches all symbols.
+func (idx SymbolIndex) Search(query string) []Symbol {
+	query = strings.ToLower(query)
+	var syms []Symbol
+	for _, file := range idx {
+		for _, sym := range file {
+			if strings.Contains(strings.ToLower(sym.Name), query) {
+				syms = append(syms, sym)
+			}
+		}
+	}
+	return syms
+}
+
+// fileSymbols returns the symbols declared in a file.
+func fileSymbols(fset *token.FileSet, path string, file *ast.File) []Symbol {
+	var syms []Symbol
+	nodeRange := func(node ast.Node) protocol.Range {
+		start, end := fset.Position(node.Pos()), fset.Position(node.End())
+		return protocol.Range{
+			Start: protocol.Position{Line: uint32(start.Line - 1), Character: uint32(start.Column - 1)},
+			End:   protocol.Position{Line: uint32(end.Line - 1), Character: uint32(end.Column - 1)},
+		}
+	}
+	add := func(name string, kind protocol.SymbolKind, ident *ast.Ident, decl ast.Node, doc *ast.CommentGroup) {
+		_, deprecated := Deprecation(doc)
+		syms = append(syms, Symbol{
+			Name:       name,
+			Kind:       kind,
+			File:       path,
+			Range:      nodeRange(ident),
+			DeclRange:  nodeRange(decl),
+			Deprecated: deprecated,
+		})
+	}
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		// declNode returns the node declaring a spec, which is the whole
+		// declaration without parentheses, so that it starts with its
+		// keyword.
+		declNode := func(spec ast.Spec) ast.Node {
+			if !gd.Lparen.IsValid() {
+				return gd
+			}
+			return spec
+		}
+		// The type of the last enum value, which is implicitly repeated
+		// by values without a type or value.
+		var enum *ast.Ident
+		for _, spec := range gd.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				doc := spec.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				switch t := spec.Type.(type) {
+				case *ast.StructType:
+					add(spec.Name.Name, protocol.SymbolKindStruct, spec.Name, declNode(spec), doc)
+					if t.Fields == nil {
+						continue
+					}
+					for _, field := range t.Fields.List {
+						for _, name := range field.Names {
+							add(spec.Name.Name+"."+name.Name, protocol.SymbolKindField, name, field, field.Doc)
+						}
+					}
+				case *ast.InterfaceType:
+					add(spec.Name.Name, protocol.SymbolKindInterface, spec.Name, declNode(spec), doc)
+					if t.Methods == nil {
+						continue
+					}
+					for _, m := range t.Methods.List {
+						for _, name := range m.Names {
+							add(spec.Name.Name+"."+name.Name, protocol.SymbolKindMethod, name, m, m.Doc)
+						}
+					}
+				default:
+					add(spec.Name.Name, protocol.SymbolKindEnum, spec.Name, declNode(spec), doc)
+				}
+			case *ast.ValueSpec:
+				if spec.Type != nil || len(spec.Values) > 0 {
+					enum, _ = spec.Type.(*ast.Ident)
+				}
+				doc := spec.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				for _, name := range spec.Names {
+					// Enum values are qualified with their type.
+					if enum != nil {
+						add(enum.Name+"."+name.Name, protocol.SymbolKindEnumMember, name, declNode(spec), doc)
+					} else {
+						add(name.Name, protocol.SymbolKindConstant, name, declNode(spec), doc)
+					}
+				}
+			}
+		}
+	}
+	return syms
+}
@@ -3,28 +3,78 @@ package lsp
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
-	"path/filepath"
 	"sync"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/gunk/gunkls/lsp/lint"
 	"github.com/gunk/gunkls/lsp/loader"
 	"go.lsp.dev/jsonrpc2"
 	"go.lsp.dev/protocol"
+	"go.uber.org/zap"
 )
 
 type LSP struct {
-	mu sync.Mutex
+	// mu guards the state of the server: its settings and views. Requests
+	// that only read it share the lock. Each view has its own lock for its
+	// packages, so that analysis doesn't need mu.
+	mu sync.RWMutex
+
+	// cancelMu guards cancel, which cancels the running analysis.
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc
+	// sched runs analysis in the background.
+	sched *scheduler
+	// stop stops the background work of the server, which runs with
+	// stopCtx.
+	stop    context.CancelFunc
+	stopCtx context.Context
+	// watcher watches files when the client can't.
+	watcher *fsnotify.Watcher
 
 	conn jsonrpc2.Conn
 
 	initialized bool
 	version     string
-	lint        bool
+	// watchFiles is set if the client can watch files for the server.
+	watchFiles bool
+	// codeLensRefresh is set if the client can be asked to refresh its
+	// code lenses.
+	codeLensRefresh bool
+	// workDoneProgress is set if the client can show the progress of
+	// work started by the server.
+	workDoneProgress bool
+	// createFiles is set if the client can create files with workspace
+	// edits.
+	createFiles bool
+	// hierarchicalSymbols is set if the client supports document symbols
+	// nested in the symbols containing them.
+	hierarchicalSymbols bool
+
+	// defaults are the settings used for values not sent by the client.
+	defaults Settings
+	settings Settings
 
-	loader    *loader.Loader
 	workspace protocol.WorkspaceFolder
-	pkgs      []*loader.GunkPackage
+	// views are the loaded trees of packages. The views of the workspace
+	// and its modules come first, if the client sent one.
+	views []*view
+	// links are the symlinked paths used by the client.
+	links links
+	// remote reads the documents that are not on the local file system.
+	remote *remoteFS
+	// cache is the loader cache, shared by all views.
+	cache *loader.Cache
+	// logger is the log of the server, as opposed to the log messages sent
+	// to the client.
+	logger *zap.Logger
+	// progressCancels cancel the work reported with cancellable progress.
+	progressCancels progressCancels
+	// indexing is the progress of the background indexing.
+	indexing indexing
+	// generations are the runs of gunk generate from code lenses.
+	generations generations
+	// duplicates are the hints on messages with identical fields.
+	duplicates duplicates
 }
 
 type Config struct {
@@ -32,21 +82,103 @@ type Config struct {
 	Lint    bool
 
 	Conn jsonrpc2.Conn
+	// Cache is the loader cache shared with the other sessions of the
+	// process. If nil, the session has a cache of its own.
+	Cache *loader.Cache
+	// Logger is where the server logs. If nil, it doesn't.
+	Logger *zap.Logger
 }
 
 func NewLSPServer(config Config) *LSP {
-	return &LSP{
-		version: config.Version,
-		lint:    config.Lint,
-		conn:    config.Conn,
+	defaults := Settings{
+		Lint:             lint.DefaultConfig(),
+		DiagnosticsDelay: 250,
+		MemoryBudget:     1024,
+		AnalysisScope:    scopeOpen,
+	}
+	defaults.Lint.Enabled = config.Lint
+	logger := config.Logger
+	if logger == nil {
+		logger = zap.NewNop()
 	}
+	l := &LSP{
+		version:  config.Version,
+		defaults: defaults,
+		settings: defaults,
+		conn:     config.Conn,
+		sched:    newScheduler(logger),
+		cache:    config.Cache,
+		logger:   logger,
+	}
+	if l.cache == nil {
+		l.cache = loader.NewCache()
+	}
+	l.remote = newRemoteFS(l)
+	ctx, stop := context.WithCancel(context.Background())
+	l.stop, l.stopCtx = stop, ctx
+	go l.sched.run(ctx)
+	go l.evictLoop(ctx)
+	return l
+}
+
+// Close stops the background work of the server, once its connection is
+// closed.
+func (l *LSP) Close() {
+	l.stop()
+	l.cancelDiagnostics()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.watcher != nil {
+		l.watcher.Close()
+	}
+	// The cache is only an optimization.
+	l.cache.Save()
 }
 
 func (l *LSP) Handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Request) error {
+	reply, done := l.trace(reply, r)
+	switch r.Method() {
+	case protocol.MethodTextDocumentDidOpen, protocol.MethodTextDocumentDidChange, protocol.MethodTextDocumentDidClose:
+		// The running analysis is about to be outdated, stop it rather
+		// than waiting for it to release the lock.
+		l.cancelDiagnostics()
+	case protocol.MethodWorkDoneProgressCancel:
+		// The cancelled work may hold the lock.
+		var params protocol.WorkDoneProgressCancelParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.cancelProgress(params.Token)
+		done()
+		return nil
+	}
+	if readOnly[r.Method()] {
+		// Take the read lock in the read loop, so that the request sees
+		// the changes sent before it, but handle it on its own goroutine
+		// so that a slow request doesn't hold up the ones after it.
+		l.mu.RLock()
+		go func() {
+			defer l.mu.RUnlock()
+			defer done()
+			reply, recovered := l.recoverPanic(ctx, reply, r)
+			defer recovered()
+			if err := l.handle(ctx, reply, r); err != nil {
+				l.logger.Error("handling request", zap.String("method", r.Method()), zap.Error(err))
+			}
+		}()
+		return nil
+	}
+	defer done()
+	reply, recovered := l.recoverPanic(ctx, reply, r)
+	defer recovered()
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	log.Printf("Requested '%s'\n", r.Method())
+	return l.handle(ctx, reply, r)
+}
 
+// handle dispatches a request to its method, with l.mu held: the read lock
+// for the readOnly methods, and the write lock for the others.
+func (l *LSP) handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Request) error {
 	switch r.Method() {
 	case protocol.MethodInitialize:
 		if l.initialized {
@@ -57,22 +189,62 @@ func (l *LSP) Handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Req
 		if err := json.Unmarshal(r.Params(), &params); err != nil {
 			return err
 		}
-		if len(params.WorkspaceFolders) == 0 {
-			l.msg(ctx, protocol.MessageTypeError, "No workspace folders found!")
-			return nil
+		if params.ClientInfo != nil {
+			// Tell the sessions of different editors apart in the
+			// logs of a shared server.
+			l.logger = l.logger.With(zap.String("client", params.ClientInfo.Name))
+			l.logger.Info("initializing", zap.String("version", params.ClientInfo.Version))
 		}
-
-		err := reply(ctx, protocol.InitializeResult{
+		settings, err := l.parseSettings(params.InitializationOptions)
+		if err != nil {
+			l.logerr(ctx, err.Error())
+		}
+		l.settings = settings
+		if ws := params.Capabilities.Workspace; ws != nil && ws.DidChangeWatchedFiles != nil {
+			l.watchFiles = ws.DidChangeWatchedFiles.DynamicRegistration
+		}
+		if ws := params.Capabilities.Workspace; ws != nil && ws.CodeLens != nil {
+			l.codeLensRefresh = ws.CodeLens.RefreshSupport
+		}
+		if ws := params.Capabilities.Workspace; ws != nil && ws.WorkspaceEdit != nil && ws.WorkspaceEdit.DocumentChanges {
+			for _, op := range ws.WorkspaceEdit.ResourceOperations {
+				l.createFiles = l.createFiles || op == string(protocol.CreateResourceOperation)
+			}
+		}
+		if td := params.Capabilities.TextDocument; td != nil && td.DocumentSymbol != nil {
+			l.hierarchicalSymbols = td.DocumentSymbol.HierarchicalDocumentSymbolSupport
+		}
+		if w := params.Capabilities.Window; w != nil {
+			l.workDoneProgress = w.WorkDoneProgress
+		}
+		err = reply(ctx, protocol.InitializeResult{
 			Capabilities: protocol.ServerCapabilities{
 				TextDocumentSync: protocol.TextDocumentSyncOptions{
 					OpenClose: true,
 					Change:    protocol.TextDocumentSyncKindFull,
+					Save:      &protocol.SaveOptions{},
 				},
-				DocumentFormattingProvider: true,
+				DocumentFormattingProvider:      true,
+				DocumentRangeFormattingProvider: true,
 				CompletionProvider: &protocol.CompletionOptions{
 					ResolveProvider: false,
 				},
-				DefinitionProvider: true,
+				HoverProvider:           true,
+				DefinitionProvider:      true,
+				ImplementationProvider:  true,
+				CodeActionProvider:      true,
+				DocumentSymbolProvider:  true,
+				WorkspaceSymbolProvider: true,
+				CodeLensProvider:        &protocol.CodeLensOptions{},
+				Workspace: &protocol.ServerCapabilitiesWorkspace{
+					FileOperations: &protocol.ServerCapabilitiesWorkspaceFileOperations{
+						WillRename: fileOperations,
+						DidRename:  fileOperations,
+					},
+				},
+				ExecuteCommandProvider: &protocol.ExecuteCommandOptions{
+					Commands: []string{commandDocs, commandDuplicateMessages, commandGenerate, commandRenameProtoPackage, commandWarmup, commandWriteHeapProfile},
+				},
 			},
 			ServerInfo: &protocol.ServerInfo{
 				Name:    "gls",
@@ -80,7 +252,13 @@ func (l *LSP) Handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Req
 			},
 		}, nil)
 
-		l.workspace = params.WorkspaceFolders[0]
+		workspace, ok := workspaceFolder(params)
+		if !ok {
+			// Files are loaded from their module when opened.
+			l.log(ctx, "No workspace folders found, loading files from their module")
+			return err
+		}
+		l.workspace = workspace
 		// load gunk
 		if err := l.Load(ctx); err != nil {
 			l.logerr(ctx, "Could not load: "+err.Error())
@@ -89,6 +267,34 @@ func (l *LSP) Handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Req
 		}
 		return err
 	case protocol.MethodInitialized:
+		l.registerWatchers(ctx)
+		return nil
+	case protocol.MethodWorkspaceDidChangeWatchedFiles:
+		var params protocol.DidChangeWatchedFilesParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.ChangeWatchedFiles(ctx, params)
+		return nil
+	case protocol.MethodWillRenameFiles:
+		var params protocol.RenameFilesParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.WillRenameFiles(ctx, params, reply)
+	case protocol.MethodDidRenameFiles:
+		var params protocol.RenameFilesParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.DidRenameFiles(ctx, params)
+		return nil
+	case protocol.MethodWorkspaceDidChangeConfiguration:
+		var params protocol.DidChangeConfigurationParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.ChangeConfiguration(ctx, params)
 		return nil
 	// Text Synchronization
 	case protocol.MethodTextDocumentDidOpen:
@@ -112,6 +318,13 @@ func (l *LSP) Handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Req
 		}
 		l.CloseFile(ctx, params)
 		return nil
+	case protocol.MethodTextDocumentDidSave:
+		var params protocol.DidSaveTextDocumentParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.SaveFile(ctx, params)
+		return nil
 	case protocol.MethodTextDocumentFormatting:
 		var params protocol.DocumentFormattingParams
 		if err := json.Unmarshal(r.Params(), &params); err != nil {
@@ -119,6 +332,13 @@ func (l *LSP) Handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Req
 		}
 		l.Format(ctx, params, reply)
 		return nil
+	case protocol.MethodTextDocumentRangeFormatting:
+		var params protocol.DocumentRangeFormattingParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.RangeFormat(ctx, params, reply)
+		return nil
 	// Language Server Specific Features
 	case protocol.MethodTextDocumentDefinition:
 		var params protocol.DefinitionParams
@@ -126,12 +346,87 @@ func (l *LSP) Handle(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Req
 			return err
 		}
 		l.Goto(ctx, params, reply)
+	case protocol.MethodTextDocumentCompletion:
+		var params protocol.CompletionParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.Completion(ctx, params, reply)
+	case protocol.MethodTextDocumentHover:
+		var params protocol.HoverParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.Hover(ctx, params, reply)
+	case protocol.MethodTextDocumentDocumentSymbol:
+		var params protocol.DocumentSymbolParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.DocumentSymbol(ctx, params, reply)
+	case protocol.MethodTextDocumentImplementation:
+		var params protocol.ImplementationParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.Implementation(ctx, params, reply)
+	case protocol.MethodWorkspaceSymbol:
+		var params protocol.WorkspaceSymbolParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.WorkspaceSymbol(ctx, params, reply)
+	case protocol.MethodTextDocumentCodeAction:
+		var params protocol.CodeActionParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.CodeAction(ctx, params, reply)
+	case protocol.MethodTextDocumentCodeLens:
+		var params protocol.CodeLensParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.CodeLens(ctx, params, reply)
+	case protocol.MethodWorkspaceExecuteCommand:
+		var params protocol.ExecuteCommandParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.ExecuteCommand(ctx, params, reply)
+	// Debugging
+	case methodMemory:
+		l.Memory(ctx, reply)
+	case methodAST:
+		var params astParams
+		if err := json.Unmarshal(r.Params(), &params); err != nil {
+			return err
+		}
+		l.AST(ctx, params, reply)
 	default:
 	}
 	return nil
 }
 
+// readOnly are the methods that don't modify the state of the server.
+var readOnly = map[string]bool{
+	protocol.MethodTextDocumentFormatting:      true,
+	protocol.MethodTextDocumentRangeFormatting: true,
+	protocol.MethodTextDocumentCompletion:      true,
+	protocol.MethodTextDocumentHover:           true,
+	protocol.MethodTextDocumentDefinition:      true,
+	protocol.MethodTextDocumentImplementation:  true,
+	protocol.MethodTextDocumentDocumentSymbol:  true,
+	protocol.MethodWorkspaceSymbol:             true,
+	protocol.MethodTextDocumentCodeAction:      true,
+	protocol.MethodTextDocumentCodeLens:        true,
+	protocol.MethodWillRenameFiles:             true,
+	methodMemory:                               true,
+	methodAST:                                  true,
+}
+
 func (l *LSP) log(ctx context.Context, msg string) {
+	l.logger.Info(msg)
 	l.conn.Notify(ctx, protocol.MethodWindowLogMessage, protocol.LogMessageParams{
 		Type:    protocol.MessageTypeInfo,
 		Message: msg,
@@ -139,6 +434,7 @@ func (l *LSP) log(ctx context.Context, msg string) {
 }
 
 func (l *LSP) logerr(ctx context.Context, msg string) {
+	l.logger.Error(msg)
 	l.conn.Notify(ctx, protocol.MethodWindowLogMessage, protocol.LogMessageParams{
 		Type:    protocol.MessageTypeError,
 		Message: msg,
@@ -151,17 +447,3 @@ func (l *LSP) msg(ctx context.Context, typ protocol.MessageType, msg string) {
 		Message: msg,
 	})
 }
-
-func (l *LSP) filePkg(file string) (*loader.GunkPackage, error) {
-	dir := filepath.Dir(file)
-	// We should be able to assume that the file is already parsed
-	// and this is called only on open files with an up to date AST
-	pkgs, err := l.loader.Load(dir)
-	if err != nil {
-		return nil, fmt.Errorf("could not load package: %v", err)
-	}
-	if len(pkgs) != 1 {
-		return nil, fmt.Errorf("expected 1 package, got %d", len(pkgs))
-	}
-	return pkgs[0], nil
-}
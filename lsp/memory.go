@@ -0,0 +1,88 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+)
+
+// methodMemory reports the memory used by the server, and
+// commandWriteHeapProfile writes a heap profile to the file given as its
+// argument, or to a new file in the temporary directory. They let users who
+// can't attach a profiler report memory issues.
+const (
+	methodMemory            = "gunkls/memory"
+	commandWriteHeapProfile = "gunkls.writeHeapProfile"
+)
+
+// memoryResult is the result of methodMemory: the heap of the process, which
+// may be shared with other sessions, and what each view holds in it.
+type memoryResult struct {
+	HeapAlloc uint64 `json:"heapAlloc"`
+	HeapInuse uint64 `json:"heapInuse"`
+	Sys       uint64 `json:"sys"`
+	NumGC     uint32 `json:"numGC"`
+	// Budget is the memory budget, in MB.
+	Budget int          `json:"budget"`
+	Views  []viewMemory `json:"views"`
+}
+
+type viewMemory struct {
+	Dir string `json:"dir"`
+	loader.MemoryStats
+}
+
+// Memory replies with the memory used by the server, by view and subsystem.
+func (l *LSP) Memory(ctx context.Context, reply jsonrpc2.Replier) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	res := memoryResult{
+		HeapAlloc: stats.HeapAlloc,
+		HeapInuse: stats.HeapInuse,
+		Sys:       stats.Sys,
+		NumGC:     stats.NumGC,
+		Budget:    l.settings.MemoryBudget,
+		Views:     []viewMemory{},
+	}
+	for _, v := range l.views {
+		v.locked(func() {
+			res.Views = append(res.Views, viewMemory{
+				Dir:         v.loader.Dir,
+				MemoryStats: v.loader.MemoryStats(),
+			})
+		})
+	}
+	reply(ctx, res, nil)
+}
+
+// writeHeapProfile writes a heap profile to path, or to a new file in the
+// temporary directory if path is empty, and returns the path written to.
+func writeHeapProfile(path string) (string, error) {
+	var f *os.File
+	var err error
+	if path == "" {
+		f, err = os.CreateTemp("", "gunkls-heap-"+time.Now().Format("20060102-150405")+"-*.pprof")
+	} else {
+		f, err = os.Create(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not write heap profile: %w", err)
+	}
+	// Only report what is still in use.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return "", fmt.Errorf("could not write heap profile: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("could not write heap profile: %w", err)
+	}
+	return filepath.Clean(f.Name()), nil
+}
@@ -0,0 +1,77 @@
+// Package metrics collects the runtime metrics of the server, published with
+// expvar so that they are served at /debug/vars along with pprof.
+package metrics
+
+import (
+	"expvar"
+	"strings"
+	"time"
+)
+
+var (
+	// requests and requestMicros are the number of requests handled and
+	// their total latency in microseconds, by method.
+	requests      = expvar.NewMap("gunkls.requests")
+	requestMicros = expvar.NewMap("gunkls.requestMicros")
+	// cache is the number of hits and misses of the caches, as
+	// "<cache>.hit" and "<cache>.miss".
+	cache = expvar.NewMap("gunkls.cache")
+	// loads and loadMicros are the number of go/packages loads and their
+	// total duration in microseconds.
+	loads      = new(expvar.Int)
+	loadMicros = new(expvar.Int)
+)
+
+func init() {
+	expvar.Publish("gunkls.packageLoads", loads)
+	expvar.Publish("gunkls.packageLoadMicros", loadMicros)
+	expvar.Publish("gunkls.cacheHitRates", expvar.Func(cacheHitRates))
+}
+
+// Request records a request handled with the given latency.
+func Request(method string, latency time.Duration) {
+	requests.Add(method, 1)
+	requestMicros.Add(method, latency.Microseconds())
+}
+
+// CacheLookup records a lookup in a cache, and whether it was a hit.
+func CacheLookup(name string, hit bool) {
+	if hit {
+		cache.Add(name+".hit", 1)
+	} else {
+		cache.Add(name+".miss", 1)
+	}
+}
+
+// PackageLoad records a load of packages by the go command that took d.
+func PackageLoad(d time.Duration) {
+	loads.Add(1)
+	loadMicros.Add(d.Microseconds())
+}
+
+// cacheHitRates returns the ratio of hits to lookups of each cache.
+func cacheHitRates() interface{} {
+	hits := make(map[string]int64)
+	lookups := make(map[string]int64)
+	cache.Do(func(kv expvar.KeyValue) {
+		n, ok := kv.Value.(*expvar.Int)
+		if !ok {
+			return
+		}
+		switch {
+		case strings.HasSuffix(kv.Key, ".hit"):
+			name := strings.TrimSuffix(kv.Key, ".hit")
+			hits[name] += n.Value()
+			lookups[name] += n.Value()
+		case strings.HasSuffix(kv.Key, ".miss"):
+			lookups[strings.TrimSuffix(kv.Key, ".miss")] += n.Value()
+		}
+	})
+	rates := make(map[string]float64, len(lookups))
+	for name, n := range lookups {
+		if n > 0 {
+			rates[name] = float64(hits[name]) / float64(n)
+		}
+	}
+	return rates
+}
@@ -0,0 +1,172 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// links maps the paths that clients use through symlinks, such as bazel-out
+// trees or linked vendor directories, to the real paths used by the loaders.
+type links struct {
+	mu sync.Mutex
+	// clientPaths are the client paths of resolved paths that differ.
+	clientPaths map[string]string
+	// uris are the URIs sent by the client for the paths of its open
+	// documents, so that they are sent back with the same encoding.
+	uris map[string]protocol.DocumentURI
+}
+
+// resolve returns the real path of a client path, so that files are matched
+// with the package directories found by the go command. Paths that don't
+// exist, such as unsaved files, are resolved through their directory.
+func (ls *links) resolve(path string) string {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return path
+		}
+		ls.add(dir, filepath.Dir(path))
+		return filepath.Join(dir, filepath.Base(path))
+	}
+	ls.add(real, path)
+	if dir := filepath.Dir(path); filepath.Dir(real) != dir {
+		ls.add(filepath.Dir(real), dir)
+	}
+	return real
+}
+
+func (ls *links) add(real, path string) {
+	if real == path {
+		return
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.clientPaths == nil {
+		ls.clientPaths = make(map[string]string)
+	}
+	ls.clientPaths[real] = path
+}
+
+// addURI records the URI sent by the client for the path of an open
+// document.
+func (ls *links) addURI(path string, u protocol.DocumentURI) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.uris == nil {
+		ls.uris = make(map[string]protocol.DocumentURI)
+	}
+	ls.uris[path] = u
+}
+
+// removeURI forgets the URI of a document once it is closed.
+func (ls *links) removeURI(path string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	delete(ls.uris, path)
+}
+
+// clientPath returns the path through which the client knows a real path,
+// from the closest resolved parent.
+func (ls *links) clientPath(path string) string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if client, ok := ls.clientPaths[dir]; ok {
+			return client + strings.TrimPrefix(path, dir)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return path
+		}
+	}
+}
+
+// clientURI returns the URI of a client path, as sent by the client if it
+// did.
+func (ls *links) clientURI(path string) protocol.DocumentURI {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if u, ok := ls.uris[path]; ok {
+		return u
+	}
+	if u, ok := remoteURI(path); ok {
+		return u
+	}
+	return uri.File(path)
+}
+
+// parseURI returns the path of a document URI: the path of file URIs, or a
+// path under remoteRoot for other URIs. Unlike uri.URI.Filename, it returns an
+// error rather than panicking on URIs that are not valid.
+func parseURI(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI %q: %v", s, err)
+	}
+	if u.Scheme == "" || u.Opaque != "" {
+		// Such as untitled:Untitled-1, which has no directory.
+		return "", fmt.Errorf("unsupported URI %q", s)
+	}
+	if u.Scheme != uri.FileScheme {
+		return remotePath(u), nil
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" && u.Host != "" && u.Host != "localhost" {
+		// A UNC path, such as file://server/share/file.
+		path = "//" + u.Host + path
+	}
+	if runtime.GOOS == "windows" && isDriveURI(path) {
+		// file:///c:/file, where the drive letter may be in any case
+		// and the colon may be escaped.
+		path = strings.ToUpper(path[1:2]) + path[2:]
+	}
+	return filepath.Clean(filepath.FromSlash(path)), nil
+}
+
+// isDriveURI reports whether the path of a URI starts with a Windows drive
+// letter, as in /c:/file.
+func isDriveURI(path string) bool {
+	if len(path) < 3 || path[0] != '/' || path[2] != ':' {
+		return false
+	}
+	c := path[1]
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// filePath returns the real path of a document URI sent by the client.
+func (l *LSP) filePath(u protocol.DocumentURI) (string, error) {
+	path, err := parseURI(string(u))
+	if err != nil {
+		return "", err
+	}
+	if isRemote(path) {
+		return path, nil
+	}
+	return l.links.resolve(path), nil
+}
+
+// openURI records the URI of a document opened by the client, so that it is
+// sent back as is while the document is open, and closeURI forgets it.
+func (l *LSP) openURI(u protocol.DocumentURI) {
+	if path, err := parseURI(string(u)); err == nil {
+		l.links.addURI(path, u)
+	}
+}
+
+func (l *LSP) closeURI(u protocol.DocumentURI) {
+	if path, err := parseURI(string(u)); err == nil {
+		l.links.removeURI(path)
+	}
+}
+
+// fileURI returns the document URI of a real path, as known by the client.
+func (l *LSP) fileURI(path string) protocol.DocumentURI {
+	return l.links.clientURI(l.links.clientPath(path))
+}
@@ -0,0 +1,138 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.lsp.dev/protocol"
+)
+
+// progressTokens numbers the progress tokens created by the server.
+var progressTokens int64
+
+// progress reports the progress of work started by the server, such as
+// generating a package, as work done progress. It reports nothing if the
+// client doesn't support it.
+type progress struct {
+	// updates are the values sent to the client, in order. It is nil if
+	// the client doesn't support work done progress.
+	updates chan interface{}
+	// token is the token of the progress, and cancels the ones that can
+	// be cancelled by the user.
+	token   string
+	cancels *progressCancels
+}
+
+// progressCancels are the functions cancelling the work of cancellable
+// progress, by token.
+type progressCancels struct {
+	mu sync.Mutex
+	m  map[string]context.CancelFunc
+}
+
+// startProgress creates a progress token and begins reporting progress with
+// it. The progress must be ended with end. If cancel is not nil, the client
+// offers to cancel the work, which calls cancel.
+func (l *LSP) startProgress(ctx context.Context, title string, cancel context.CancelFunc) *progress {
+	p := &progress{}
+	if !l.workDoneProgress {
+		return p
+	}
+	p.updates = make(chan interface{}, 16)
+	p.token = fmt.Sprintf("gunkls-%d", atomic.AddInt64(&progressTokens, 1))
+	if cancel != nil {
+		p.cancels = &l.progressCancels
+		p.cancels.mu.Lock()
+		if p.cancels.m == nil {
+			p.cancels.m = make(map[string]context.CancelFunc)
+		}
+		p.cancels.m[p.token] = cancel
+		p.cancels.mu.Unlock()
+	}
+	token := *protocol.NewProgressToken(p.token)
+	// Params are sent as pointers, as tokens only marshal as pointers.
+	// The token is created from a goroutine, as the client may send
+	// requests before replying, which would wait for the lock held by the
+	// caller.
+	go func() {
+		_, err := l.conn.Call(ctx, protocol.MethodWorkDoneProgressCreate, &protocol.WorkDoneProgressCreateParams{Token: token}, nil)
+		for value := range p.updates {
+			if err != nil {
+				continue
+			}
+			l.conn.Notify(ctx, protocol.MethodProgress, &protocol.ProgressParams{Token: token, Value: value})
+		}
+	}()
+	p.updates <- protocol.WorkDoneProgressBegin{
+		Kind:        protocol.WorkDoneProgressKindBegin,
+		Title:       title,
+		Cancellable: cancel != nil,
+	}
+	return p
+}
+
+// requestProgress reports the progress of a request, with the work done
+// token sent by the client in its parameters if there is one, or with one
+// created by the server otherwise. The progress must be ended with end. The
+// client cancels the request itself rather than the progress.
+func (l *LSP) requestProgress(ctx context.Context, params protocol.WorkDoneProgressParams, title string) *progress {
+	if params.WorkDoneToken == nil {
+		return l.startProgress(ctx, title, nil)
+	}
+	token := *params.WorkDoneToken
+	p := &progress{updates: make(chan interface{}, 16), token: token.String()}
+	go func() {
+		for value := range p.updates {
+			l.conn.Notify(ctx, protocol.MethodProgress, &protocol.ProgressParams{Token: token, Value: value})
+		}
+	}()
+	p.updates <- protocol.WorkDoneProgressBegin{
+		Kind:  protocol.WorkDoneProgressKindBegin,
+		Title: title,
+	}
+	return p
+}
+
+// cancelProgress cancels the work of a cancellable progress, if it is still
+// running.
+func (l *LSP) cancelProgress(token protocol.ProgressToken) {
+	c := &l.progressCancels
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, ok := c.m[token.String()]; ok {
+		cancel()
+		delete(c.m, token.String())
+	}
+}
+
+// report reports a message and a percentage of the work done. Reports are
+// dropped if the client is too slow to receive them.
+func (p *progress) report(message string, percentage uint32) {
+	if p.updates == nil {
+		return
+	}
+	select {
+	case p.updates <- protocol.WorkDoneProgressReport{
+		Kind:       protocol.WorkDoneProgressKindReport,
+		Message:    message,
+		Percentage: percentage,
+	}:
+	default:
+	}
+}
+
+// end ends the progress with a final message.
+func (p *progress) end(message string) {
+	if p.updates == nil {
+		return
+	}
+	if p.cancels != nil {
+		p.cancels.mu.Lock()
+		delete(p.cancels.m, p.token)
+		p.cancels.mu.Unlock()
+	}
+	p.updates <- protocol.WorkDoneProgressEnd{Kind: protocol.WorkDoneProgressKindEnd, Message: message}
+	close(p.updates)
+}
@@ -0,0 +1,132 @@
+package lsp
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// protoString matches the quoted .proto files in annotations.
+var protoString = regexp.MustCompile(`"([^"]+\.proto)"`)
+
+// protoDiagnostics returns the warnings on the .proto files referenced by the
+// .gunkconfig files of a package that can't be found in their include
+// directories, by .gunkconfig. The .gunkconfig files without missing files
+// have no diagnostics, so that fixed ones are cleared. The view of the loader
+// must be locked.
+func protoDiagnostics(ldr *loader.Loader, pkg *loader.GunkPackage) map[string][]protocol.Diagnostic {
+	refs, err := ldr.ProtoRefs(pkg.Dir)
+	if err != nil {
+		// Invalid configurations are reported by the formatter.
+		return nil
+	}
+	diags := make(map[string][]protocol.Diagnostic)
+	for _, ref := range refs {
+		if diags[ref.Config] == nil {
+			diags[ref.Config] = []protocol.Diagnostic{}
+		}
+		if ref.Path != "" || ref.Bundled() {
+			continue
+		}
+		src, err := ldr.ReadFile(ref.Config)
+		if err != nil {
+			continue
+		}
+		rng, ok := configKeyRange(src, ref.Key)
+		if !ok {
+			continue
+		}
+		diags[ref.Config] = append(diags[ref.Config], protocol.Diagnostic{
+			Range:    rng,
+			Severity: protocol.DiagnosticSeverityWarning,
+			Code:     "missingproto",
+			Source:   loader.DiagnosticSource,
+			Message: fmt.Sprintf("could not find %s in the include directories: %s",
+				ref.Name, strings.Join(loader.ProtoIncludeDirs, ", ")),
+		})
+	}
+	return diags
+}
+
+// configKeyRange returns the range of a key in the source of a .gunkconfig.
+func configKeyRange(src []byte, key string) (protocol.Range, bool) {
+	for i, line := range strings.Split(string(src), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, key) || !strings.HasPrefix(strings.TrimSpace(trimmed[len(key):]), "=") {
+			continue
+		}
+		start := uint32(strings.Index(line, key))
+		return protocol.Range{
+			Start: protocol.Position{Line: uint32(i), Character: start},
+			End:   protocol.Position{Line: uint32(i), Character: start + uint32(len(key))},
+		}, true
+	}
+	return protocol.Range{}, false
+}
+
+// configProtoLocation returns the location of the .proto file referenced by
+// the parameter at pos in a .gunkconfig, or nil if there is none or it
+// wasn't found.
+func (l *LSP) configProtoLocation(v *view, file string, pos protocol.Position) []protocol.Location {
+	var path string
+	v.locked(func() {
+		src, err := v.loader.ReadFile(file)
+		if err != nil {
+			return
+		}
+		refs, err := v.loader.ProtoRefs(filepath.Dir(file))
+		if err != nil {
+			return
+		}
+		for _, ref := range refs {
+			rng, ok := configKeyRange(src, ref.Key)
+			if ok && ref.Config == file && rng.Start.Line == pos.Line &&
+				rng.Start.Character <= pos.Character && pos.Character <= rng.End.Character {
+				path = ref.Path
+				return
+			}
+		}
+	})
+	if path == "" {
+		return nil
+	}
+	return []protocol.Location{{URI: l.fileURI(path)}}
+}
+
+// annotationProtoLocation returns the location of the .proto file quoted at
+// pos in an annotation of a gunk file, such as one referring to the vendored
+// definition of an option, or nil if pos isn't on one or it wasn't found.
+func (l *LSP) annotationProtoLocation(v *view, fset *token.FileSet, f *ast.File, file string, pos protocol.Position) []protocol.Location {
+	var name string
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			start := fset.Position(c.Pos())
+			if start.Line-1 != int(pos.Line) || strings.HasPrefix(c.Text, "/*") {
+				continue
+			}
+			offset := int(pos.Character) - (start.Column - 1)
+			for _, m := range protoString.FindAllStringSubmatchIndex(c.Text, -1) {
+				if m[0] <= offset && offset < m[1] {
+					name = c.Text[m[2]:m[3]]
+				}
+			}
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	var path string
+	v.locked(func() {
+		path = v.loader.ResolveProto(filepath.Dir(file), name)
+	})
+	if path == "" {
+		return nil
+	}
+	return []protocol.Location{{URI: l.fileURI(path)}}
+}
@@ -0,0 +1,224 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// commandRenameProtoPackage changes the proto package name of the package of
+// the file given as its first argument to the name given as its second
+// argument. The full proto names of its declarations in the gunk tags of the
+// analyzed packages are updated too.
+const commandRenameProtoPackage = "gunkls.renameProtoPackage"
+
+// protoOptPath is the package of the proto.Package annotation.
+const protoOptPath = "github.com/gunk/opt/proto"
+
+var (
+	protoPackageName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+	// protoPackageTag matches the string of a proto.Package annotation,
+	// whatever the name of the imported package.
+	protoPackageTag = regexp.MustCompile(`\.Package\(\s*("[^"]*"|` + "`[^`]*`" + `)\s*\)`)
+)
+
+// RenameProtoPackage applies the edit renaming the proto package of a
+// package, and warns that the change isn't compatible with the existing
+// clients of the package.
+func (l *LSP) RenameProtoPackage(ctx context.Context, params protocol.ExecuteCommandParams, reply jsonrpc2.Replier) {
+	var u, name string
+	if len(params.Arguments) > 1 {
+		u, _ = params.Arguments[0].(string)
+		name, _ = params.Arguments[1].(string)
+	}
+	if u == "" || name == "" {
+		reply(ctx, nil, fmt.Errorf("%s expects the URI of a gunk file and a proto package name", commandRenameProtoPackage))
+		return
+	}
+	if !protoPackageName.MatchString(name) {
+		reply(ctx, nil, fmt.Errorf("invalid proto package name %q", name))
+		return
+	}
+	file, err := l.filePath(protocol.DocumentURI(u))
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok || pkg.Types == nil {
+		reply(ctx, nil, fmt.Errorf("package of %s has not been analyzed yet", file))
+		return
+	}
+	if pkg.Stale {
+		// The positions of the last successful analysis may not match
+		// the current contents.
+		reply(ctx, nil, fmt.Errorf("package %s has errors", pkg.PkgPath))
+		return
+	}
+	old := pkg.ProtoName
+	if name == old {
+		reply(ctx, nil, nil)
+		return
+	}
+	// Renaming to the proto package of another package must not declare
+	// the same names twice.
+	for _, v := range l.views {
+		for _, other := range v.snapshot().pkgs {
+			if other == pkg || other.ProtoName != name || other.Types == nil {
+				continue
+			}
+			for _, n := range pkg.Types.Scope().Names() {
+				if _, ok := other.Types.Scope().Lookup(n).(*types.TypeName); ok {
+					reply(ctx, nil, fmt.Errorf("%s.%s is already declared in %s", name, n, other.PkgPath))
+					return
+				}
+			}
+		}
+	}
+
+	changes := make(map[protocol.DocumentURI][]protocol.TextEdit)
+	l.protoPackageEdits(s, pkg, name, changes)
+	for _, v := range l.views {
+		s := v.snapshot()
+		seen := make(map[*loader.GunkPackage]bool)
+		for _, p := range s.pkgs {
+			if !seen[p] {
+				seen[p] = true
+				l.protoNameEdits(s, p, pkg, name, changes)
+			}
+		}
+	}
+	label := fmt.Sprintf("Rename proto package %s to %s", old, name)
+	l.goReply(ctx, commandRenameProtoPackage, reply, func() (interface{}, error) {
+		err := l.applyEdit(ctx, label, protocol.WorkspaceEdit{Changes: changes})
+		if err == nil {
+			l.msg(ctx, protocol.MessageTypeWarning, fmt.Sprintf("Renamed proto package %s to %s. "+
+				"The full names of its messages, enums and services changed. "+
+				"This breaks existing clients of its services, stored Any values, "+
+				"and packages outside the workspace that use %s.", old, name, old))
+		}
+		return nil, err
+	})
+}
+
+// protoPackageEdits adds the edits setting the proto package of pkg to name:
+// the strings of its proto.Package annotations, or a new annotation in its
+// first file if it has none.
+func (l *LSP) protoPackageEdits(s *snapshot, pkg *loader.GunkPackage, name string, changes map[protocol.DocumentURI][]protocol.TextEdit) {
+	found := false
+	for i, f := range pkg.GunkSyntax {
+		if f.Doc == nil || i >= len(pkg.GunkFiles) {
+			continue
+		}
+		u := l.fileURI(pkg.GunkFiles[i])
+		for _, c := range f.Doc.List {
+			if !strings.Contains(c.Text, "+gunk") {
+				continue
+			}
+			m := protoPackageTag.FindStringSubmatchIndex(c.Text)
+			if m == nil {
+				continue
+			}
+			found = true
+			changes[u] = append(changes[u], protocol.TextEdit{
+				Range:   posRange(s.fset, c.Pos()+token.Pos(m[2]), c.Pos()+token.Pos(m[3])),
+				NewText: strconv.Quote(name),
+			})
+		}
+	}
+	if found || len(pkg.GunkSyntax) == 0 || len(pkg.GunkFiles) == 0 {
+		return
+	}
+
+	f := pkg.GunkSyntax[0]
+	u := l.fileURI(pkg.GunkFiles[0])
+	local := ""
+	for _, spec := range f.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == protoOptPath {
+			local = "proto"
+			if spec.Name != nil {
+				local = spec.Name.Name
+			}
+		}
+	}
+	if local == "" {
+		local = "proto"
+		changes[u] = append(changes[u], importEdit(s.fset, f, protoOptPath))
+	}
+	changes[u] = append(changes[u], protocol.TextEdit{
+		Range:   posRange(s.fset, f.Package, f.Package),
+		NewText: fmt.Sprintf("// +gunk %s.Package(%q)\n", local, name),
+	})
+}
+
+// importEdit returns the edit adding an import of path to a file.
+func importEdit(fset *token.FileSet, f *ast.File, path string) protocol.TextEdit {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		if gd.Lparen.IsValid() {
+			return protocol.TextEdit{
+				Range:   posRange(fset, gd.Lparen+1, gd.Lparen+1),
+				NewText: "\n\t" + strconv.Quote(path),
+			}
+		}
+		return protocol.TextEdit{
+			Range:   posRange(fset, gd.Pos(), gd.Pos()),
+			NewText: "import " + strconv.Quote(path) + "\n",
+		}
+	}
+	return protocol.TextEdit{
+		Range:   posRange(fset, f.Name.End(), f.Name.End()),
+		NewText: "\n\nimport " + strconv.Quote(path),
+	}
+}
+
+// protoNameEdits adds the edits replacing the proto package in the full proto
+// names of the declarations of renamed, such as "foo.v1.Service", found in
+// the strings of the gunk tags of p.
+func (l *LSP) protoNameEdits(s *snapshot, p, renamed *loader.GunkPackage, name string, changes map[protocol.DocumentURI][]protocol.TextEdit) {
+	ref := regexp.MustCompile(`"` + regexp.QuoteMeta(renamed.ProtoName) + `\.([A-Za-z_][A-Za-z0-9_]*)`)
+	for i, f := range p.GunkSyntax {
+		if i >= len(p.GunkFiles) {
+			continue
+		}
+		u := l.fileURI(p.GunkFiles[i])
+		for _, group := range f.Comments {
+			for _, c := range group.List {
+				if !strings.Contains(c.Text, "+gunk") {
+					continue
+				}
+				for _, m := range ref.FindAllStringSubmatchIndex(c.Text, -1) {
+					// Only names declared in the package are
+					// renamed, rather than any string with
+					// the same prefix, such as a host name.
+					if _, ok := renamed.Types.Scope().Lookup(c.Text[m[2]:m[3]]).(*types.TypeName); !ok {
+						continue
+					}
+					start := c.Pos() + token.Pos(m[0]+1)
+					changes[u] = append(changes[u], protocol.TextEdit{
+						Range:   posRange(s.fset, start, start+token.Pos(len(renamed.ProtoName))),
+						NewText: name,
+					})
+				}
+			}
+		}
+	}
+}
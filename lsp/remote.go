@@ -0,0 +1,251 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// remoteRoot is the directory under which documents with URIs other than
+// file URIs, such as the ones of virtual workspaces, are given a path, as
+// <remoteRoot>/<scheme>/<authority>/<path>. It doesn't exist on disk.
+var remoteRoot = filepath.Join(string(filepath.Separator), ".gunkls-remote")
+
+// Methods through which the files of remote documents are read from the
+// client. They mirror the file system providers of VS Code: readFile returns
+// the contents of a file as a string, and readDirectory the [name, type]
+// pairs of the entries of a directory, where type 2 is a directory.
+const (
+	methodReadFile      = "gunkls/readFile"
+	methodReadDirectory = "gunkls/readDirectory"
+)
+
+type remoteParams struct {
+	URI protocol.DocumentURI `json:"uri"`
+}
+
+// remotePath returns the path of a URI that is not a file URI.
+func remotePath(u *url.URL) string {
+	host := u.Host
+	if host == "" {
+		host = "_"
+	}
+	return filepath.Join(remoteRoot, u.Scheme, host, filepath.FromSlash(u.Path))
+}
+
+// remoteURI returns the URI of a path returned by remotePath, and reports
+// whether path is one.
+func remoteURI(path string) (protocol.DocumentURI, bool) {
+	rel, err := filepath.Rel(remoteRoot, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+	if len(parts) < 2 {
+		return "", false
+	}
+	u := url.URL{Scheme: parts[0], Host: parts[1], Path: "/"}
+	if u.Host == "_" {
+		u.Host = ""
+	}
+	if len(parts) == 3 {
+		u.Path += parts[2]
+	}
+	return protocol.DocumentURI(u.String()), true
+}
+
+// isRemote reports whether path is the path of a remote document.
+func isRemote(path string) bool {
+	return loader.InDir(remoteRoot, path)
+}
+
+// errFetching is returned for remote files that are being read from the
+// client.
+var errFetching = errors.New("not read from the client yet")
+
+// remoteRetry is how long the error reading a remote file is kept, before the
+// file is read from the client again.
+const remoteRetry = 10 * time.Second
+
+// remoteFS reads the files of remote documents from the client. Requests are
+// handled one at a time, so the client's response can't be read while one is
+// handled: reads never wait for it. Files that haven't been read yet are
+// reported as missing while they are fetched in the background, and their
+// packages are analyzed again once they are. Files are read again once the
+// client reports them changed, or once their error expires.
+type remoteFS struct {
+	l *LSP
+
+	mu sync.Mutex
+	// files and dirs are the files and directories read from the client,
+	// or being read.
+	files map[string]*remoteFile
+	dirs  map[string]*remoteFile
+}
+
+type remoteFile struct {
+	data    []byte
+	entries []fs.DirEntry
+	err     error
+	// done is set once the file has been read, at fetched.
+	done    bool
+	fetched time.Time
+}
+
+func newRemoteFS(l *LSP) *remoteFS {
+	return &remoteFS{
+		l:     l,
+		files: make(map[string]*remoteFile),
+		dirs:  make(map[string]*remoteFile),
+	}
+}
+
+func (r *remoteFS) ReadFile(path string) ([]byte, error) {
+	f, err := r.get(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return f.data, nil
+}
+
+func (r *remoteFS) ReadDir(dir string) ([]fs.DirEntry, error) {
+	f, err := r.get(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.entries, nil
+}
+
+// get returns a file or directory read from the client, and starts reading it
+// if it hasn't been yet.
+func (r *remoteFS) get(path string, dir bool) (*remoteFile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cache := r.files
+	if dir {
+		cache = r.dirs
+	}
+	f, ok := cache[path]
+	if !ok || f.done && f.err != nil && time.Since(f.fetched) > remoteRetry {
+		f = &remoteFile{}
+		cache[path] = f
+		r.l.goRecover("remote read", func() {
+			r.fetch(path, dir, f)
+		})
+	}
+	if !f.done {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: errFetching}
+	}
+	return f, f.err
+}
+
+// forget drops a file changed on the client, and the listing of its
+// directory, so that they are read again.
+func (r *remoteFS) forget(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.files, path)
+	delete(r.dirs, path)
+	delete(r.dirs, filepath.Dir(path))
+}
+
+// fetch reads a file or directory from the client into pending, and updates
+// the packages affected by its contents. The result is dropped if the file
+// was forgotten in the meantime.
+func (r *remoteFS) fetch(path string, dir bool, pending *remoteFile) {
+	ctx := r.l.stopCtx
+	u, _ := remoteURI(path)
+	f := &remoteFile{}
+	var changed []string
+	if dir {
+		var entries [][2]json.RawMessage
+		_, f.err = r.l.conn.Call(ctx, methodReadDirectory, remoteParams{URI: u}, &entries)
+		for _, e := range entries {
+			var entry remoteDirEntry
+			var typ int
+			if json.Unmarshal(e[0], &entry.name) != nil || json.Unmarshal(e[1], &typ) != nil {
+				continue
+			}
+			entry.dir = typ&2 != 0
+			f.entries = append(f.entries, entry)
+			if !entry.dir && filepath.Ext(entry.name) == ".gunk" {
+				changed = append(changed, filepath.Join(path, entry.name))
+			}
+		}
+		sort.Slice(f.entries, func(i, j int) bool {
+			return f.entries[i].Name() < f.entries[j].Name()
+		})
+	} else {
+		var content string
+		_, f.err = r.l.conn.Call(ctx, methodReadFile, remoteParams{URI: u}, &content)
+		f.data = []byte(content)
+		changed = append(changed, path)
+	}
+	if f.err != nil {
+		f.err = &fs.PathError{Op: "read", Path: path, Err: f.err}
+		changed = nil
+	}
+	r.mu.Lock()
+	cache := r.files
+	if dir {
+		cache = r.dirs
+	}
+	if cache[path] != pending {
+		r.mu.Unlock()
+		return
+	}
+	f.done, f.fetched = true, time.Now()
+	*pending = *f
+	r.mu.Unlock()
+	for _, path := range changed {
+		r.l.remoteChanged(ctx, path)
+	}
+}
+
+// remoteChanged updates the package of a remote file that was read from the
+// client, like a file changed on disk.
+func (l *LSP) remoteChanged(ctx context.Context, path string) {
+	l.sched.schedule(interactive, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		v := l.findView(path)
+		if v == nil {
+			return
+		}
+		v.locked(func() {
+			v.pkgs = v.loader.FileChanged(v.pkgs, path)
+			v.publish()
+		})
+		l.doDiagnostics(ctx)
+	})
+}
+
+// remoteDirEntry is an entry of a remote directory.
+type remoteDirEntry struct {
+	name string
+	dir  bool
+}
+
+func (e remoteDirEntry) Name() string { return e.name }
+func (e remoteDirEntry) IsDir() bool  { return e.dir }
+
+func (e remoteDirEntry) Type() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e remoteDirEntry) Info() (fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "stat", Path: e.name, Err: fs.ErrInvalid}
+}
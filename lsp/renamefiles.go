@@ -0,0 +1,211 @@
+package lsp
+
+import (
+	"context"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+	"go.uber.org/zap"
+)
+
+// fileOperations are the renames the server is notified of: gunk files, and
+// directories, which may contain packages.
+var fileOperations = &protocol.FileOperationRegistrationOptions{
+	Filters: []protocol.FileOperationFilter{
+		{
+			Scheme: "file",
+			Pattern: protocol.FileOperationPattern{
+				Glob:    "**/*.gunk",
+				Matches: protocol.FileOperationPatternKindFile,
+			},
+		},
+		{
+			Scheme: "file",
+			Pattern: protocol.FileOperationPattern{
+				Glob:    "**",
+				Matches: protocol.FileOperationPatternKindFolder,
+			},
+		},
+	},
+}
+
+// WillRenameFiles replies with the edit rewriting the imports of the packages
+// moved by renaming files or directories, in all of the loaded gunk files, so
+// that the workspace still compiles once they are moved. The edit is applied
+// before the files are moved, so it uses their old URIs.
+func (l *LSP) WillRenameFiles(ctx context.Context, params protocol.RenameFilesParams, reply jsonrpc2.Replier) {
+	moved := make(map[string]string)
+	for _, f := range params.Files {
+		oldPath, err := l.filePath(protocol.DocumentURI(f.OldURI))
+		if err != nil {
+			continue
+		}
+		newPath, err := l.filePath(protocol.DocumentURI(f.NewURI))
+		if err != nil {
+			continue
+		}
+		for from, to := range l.movedPackages(oldPath, newPath) {
+			moved[from] = to
+		}
+	}
+	if len(moved) == 0 {
+		reply(ctx, nil, nil)
+		return
+	}
+	changes := make(map[protocol.DocumentURI][]protocol.TextEdit)
+	for _, v := range l.views {
+		v.locked(func() {
+			for _, pkg := range v.pkgs {
+				for _, file := range pkg.GunkFiles {
+					l.importEdits(v, file, moved, changes)
+				}
+			}
+		})
+	}
+	if len(changes) == 0 {
+		reply(ctx, nil, nil)
+		return
+	}
+	reply(ctx, &protocol.WorkspaceEdit{Changes: changes}, nil)
+}
+
+// movedPackages returns the new import paths of the packages moved by
+// renaming oldPath to newPath, by their old import paths. Renaming a gunk
+// file only moves its package if it is the only file of the package.
+func (l *LSP) movedPackages(oldPath, newPath string) map[string]string {
+	from, to := l.findView(oldPath), l.findView(newPath)
+	if from == nil || to == nil {
+		return nil
+	}
+	// dirs are the new directories of the moved packages, by their old
+	// import paths.
+	dirs := make(map[string]string)
+	from.locked(func() {
+		for _, pkg := range from.pkgs {
+			if pkg.Dir == "" {
+				continue
+			}
+			if filepath.Ext(oldPath) == ".gunk" {
+				if len(pkg.GunkFiles) == 1 && pkg.GunkFiles[0] == oldPath {
+					dirs[pkg.PkgPath] = filepath.Dir(newPath)
+				}
+				continue
+			}
+			if rel, err := filepath.Rel(oldPath, pkg.Dir); err == nil && loader.InDir(oldPath, pkg.Dir) {
+				dirs[pkg.PkgPath] = filepath.Join(newPath, rel)
+			}
+		}
+	})
+	moved := make(map[string]string)
+	to.locked(func() {
+		for path, dir := range dirs {
+			if newPath := to.loader.ImportPath(dir); newPath != "" && newPath != path {
+				moved[path] = newPath
+			}
+		}
+	})
+	return moved
+}
+
+// importEdits adds the edits replacing the imports of the moved packages in a
+// file. v.mu must be held.
+func (l *LSP) importEdits(v *view, file string, moved map[string]string, changes map[protocol.DocumentURI][]protocol.TextEdit) {
+	src, err := v.loader.ReadFile(file)
+	if err != nil {
+		return
+	}
+	fset := token.NewFileSet()
+	// Files with syntax errors still have their imports fixed, as far as
+	// they parsed.
+	f, _ := parser.ParseFile(fset, file, src, parser.ImportsOnly)
+	if f == nil {
+		return
+	}
+	u := l.fileURI(file)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if newPath, ok := moved[path]; ok {
+			changes[u] = append(changes[u], protocol.TextEdit{
+				Range:   nodeRange(fset, spec.Path),
+				NewText: strconv.Quote(newPath),
+			})
+		}
+	}
+}
+
+// DidRenameFiles updates the loaded packages once files or directories have
+// been renamed: the packages in the old directories are dropped along with
+// their diagnostics, and the ones in the new directories are loaded. The
+// contents of a renamed file open in the editor move to its new path.
+func (l *LSP) DidRenameFiles(ctx context.Context, params protocol.RenameFilesParams) {
+	for _, f := range params.Files {
+		oldPath, err := l.filePath(protocol.DocumentURI(f.OldURI))
+		if err != nil {
+			continue
+		}
+		newPath, err := l.filePath(protocol.DocumentURI(f.NewURI))
+		if err != nil {
+			continue
+		}
+		var removed []string
+		var src string
+		open := false
+		if v := l.findView(oldPath); v != nil {
+			v.locked(func() {
+				if filepath.Ext(oldPath) == ".gunk" {
+					src, open = v.loader.InMemoryFiles[oldPath]
+					if open {
+						v.pkgs, err = v.loader.CloseFile(v.pkgs, oldPath)
+						if err != nil {
+							l.logger.Warn("could not close renamed file", zap.String("path", oldPath), zap.Error(err))
+						}
+					} else {
+						v.pkgs = v.loader.FileChanged(v.pkgs, oldPath)
+					}
+					removed = append(removed, oldPath)
+				} else {
+					var pkgs []*loader.GunkPackage
+					v.pkgs, pkgs = v.loader.DirRemoved(v.pkgs, oldPath)
+					for _, pkg := range pkgs {
+						removed = append(removed, pkg.GunkFiles...)
+					}
+				}
+				v.publish()
+			})
+		}
+		if v := l.findView(newPath); v != nil {
+			v.locked(func() {
+				switch {
+				case open:
+					// Load the new package from disk first, if
+					// any, so that the file is added to it.
+					v.pkgs = v.loader.FileChanged(v.pkgs, newPath)
+					v.pkgs, _, err = v.loader.AddFile(v.pkgs, newPath, src)
+					if err != nil {
+						l.logger.Warn("could not add renamed file", zap.String("path", newPath), zap.Error(err))
+					}
+				case filepath.Ext(newPath) == ".gunk":
+					v.pkgs = v.loader.FileChanged(v.pkgs, newPath)
+				default:
+					v.pkgs = v.loader.DirAdded(v.pkgs, newPath)
+				}
+				v.publish()
+			})
+		}
+		for _, file := range removed {
+			l.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{
+				URI:         l.fileURI(file),
+				Diagnostics: []protocol.Diagnostic{},
+			})
+		}
+	}
+	l.doDiagnostics(ctx)
+}
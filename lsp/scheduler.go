@@ -0,0 +1,90 @@
+package lsp
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// priority is the priority of a job run by the scheduler.
+type priority int
+
+const (
+	// background jobs, such as indexing the workspace, run when there is
+	// nothing more urgent to do.
+	background priority = iota
+	// interactive jobs, such as the diagnostics of open files, run before
+	// all background jobs.
+	interactive
+)
+
+// scheduler runs analysis jobs in the background, one at a time. Pending
+// interactive jobs always run before background ones, so that a large
+// workspace being analyzed doesn't delay the results for open files by more
+// than a single job.
+type scheduler struct {
+	mu   sync.Mutex
+	jobs [interactive + 1][]func()
+	wake chan struct{}
+
+	logger *zap.Logger
+}
+
+func newScheduler(logger *zap.Logger) *scheduler {
+	return &scheduler{wake: make(chan struct{}, 1), logger: logger}
+}
+
+// schedule queues a job to be run with the given priority.
+func (s *scheduler) schedule(p priority, job func()) {
+	s.mu.Lock()
+	s.jobs[p] = append(s.jobs[p], job)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next dequeues the job with the highest priority, or returns nil if there
+// are none.
+func (s *scheduler) next() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := interactive; p >= background; p-- {
+		if len(s.jobs[p]) > 0 {
+			job := s.jobs[p][0]
+			s.jobs[p] = s.jobs[p][1:]
+			return job
+		}
+	}
+	return nil
+}
+
+// run runs the queued jobs until ctx is done. Jobs take the locks they need
+// themselves, so that requests can be handled between the steps of a job.
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		job := s.next()
+		if job == nil {
+			select {
+			case <-s.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		s.runJob(job)
+	}
+}
+
+// runJob runs a job, recovering from a panic in it so that the following jobs
+// still run.
+func (s *scheduler) runJob(job func()) {
+	defer func() {
+		if x := recover(); x != nil {
+			s.logger.Error("panic in background job", zap.Any("panic", x), zap.StackSkip("stack", 1))
+		}
+	}()
+	job()
+}
@@ -0,0 +1,203 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/gunk/gunkls/lsp/lint"
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// Settings are the user configurable settings of the language server. They
+// can be sent by the client in the initializationOptions of initialize, and
+// updated at runtime with workspace/didChangeConfiguration.
+type Settings struct {
+	Lint   lint.Config   `json:"lint"`
+	Format FormatOptions `json:"format"`
+	// DiagnosticsDelay is the time in milliseconds to wait after a change
+	// before computing diagnostics, so that typing isn't slowed down by an
+	// analysis for each keystroke.
+	DiagnosticsDelay int `json:"diagnosticsDelay"`
+	// MemoryBudget is the heap size in megabytes above which the syntax
+	// trees and type information of packages that aren't in use are
+	// dropped. Zero disables eviction.
+	MemoryBudget int `json:"memoryBudget"`
+	// PackagesDriver is the GOPACKAGESDRIVER used to load packages, for
+	// build systems such as Bazel. If empty, the GOPACKAGESDRIVER of the
+	// environment is used, and "off" forces the go command.
+	PackagesDriver string `json:"packagesDriver"`
+	// Env are environment variables for the go command and the packages
+	// driver, such as GOFLAGS, GOPRIVATE or GONOSUMDB.
+	Env map[string]string `json:"env"`
+	// GoCommand is the path of the go binary to use instead of the one
+	// found in PATH.
+	GoCommand string `json:"goCommand"`
+	// DocsDir is the directory where the API references of packages are
+	// written, relative to the package directory if not absolute.
+	DocsDir string `json:"docsDir"`
+	// GenerateOnSave runs gunk generate for the package of a saved gunk
+	// file, if the package has no errors.
+	GenerateOnSave bool `json:"generateOnSave"`
+	// AnalysisScope is the set of packages analyzed when they change:
+	// scopeOpen or scopeWorkspace.
+	AnalysisScope string `json:"analysisScope"`
+	// DiagnoseWorkspace publishes the diagnostics of all packages of the
+	// workspace once it is loaded, analyzing them in the background, so
+	// that broken packages are reported without opening their files.
+	DiagnoseWorkspace bool `json:"diagnoseWorkspace"`
+	// Severity overrides the severity of diagnostics by code: the name of
+	// a lint rule, or "parse error", "type error" and "validation error".
+	// Severities are "error", "warning", "information" and "hint", and
+	// "off" drops the diagnostics.
+	Severity map[string]string `json:"severity"`
+	// Exclude are the directories of the workspace where packages aren't
+	// looked for, as slash-separated patterns matching the name of a
+	// directory, such as "testdata", or a sequence of them, such as
+	// "api/gen". Packages in excluded directories are only loaded when
+	// imported, and their diagnostics only published when their files are
+	// open.
+	Exclude []string `json:"exclude"`
+	// DiagnosticSource is the source of the published diagnostics, to
+	// tell them apart from the ones of other tools. If empty, it is
+	// loader.DiagnosticSource.
+	DiagnosticSource string `json:"diagnosticSource"`
+}
+
+// severities are the severities that diagnostics can be overridden with.
+var severities = map[string]protocol.DiagnosticSeverity{
+	"error":       protocol.DiagnosticSeverityError,
+	"warning":     protocol.DiagnosticSeverityWarning,
+	"information": protocol.DiagnosticSeverityInformation,
+	"hint":        protocol.DiagnosticSeverityHint,
+}
+
+// overrideSeverity applies the severity overrides to the diagnostics of a
+// file, dropping the ones turned off.
+func (s Settings) overrideSeverity(diags []protocol.Diagnostic) []protocol.Diagnostic {
+	if len(s.Severity) == 0 {
+		return diags
+	}
+	kept := diags[:0]
+	for _, d := range diags {
+		code, _ := d.Code.(string)
+		if name, ok := s.Severity[code]; ok {
+			if name == "off" {
+				continue
+			}
+			d.Severity = severities[name]
+		}
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+// setSource sets the configured source of the diagnostics of a file.
+func (s Settings) setSource(diags []protocol.Diagnostic) []protocol.Diagnostic {
+	if s.DiagnosticSource == "" {
+		return diags
+	}
+	for i := range diags {
+		diags[i].Source = s.DiagnosticSource
+	}
+	return diags
+}
+
+const (
+	// scopeOpen analyzes the packages with open files and the packages
+	// importing them, so that large monorepos only pay for what is being
+	// edited.
+	scopeOpen = "open"
+	// scopeWorkspace analyzes any package of the workspace affected by a
+	// change, such as the importers of a package edited on disk.
+	scopeWorkspace = "workspace"
+)
+
+// environ returns the environment variables of the settings, in the form
+// "key=value".
+func (s Settings) environ() []string {
+	env := make([]string, 0, len(s.Env))
+	for key, value := range s.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// parseSettings decodes settings sent by the client on top of the defaults.
+// Settings may either be sent as is, or nested under a "gunkls" key.
+func (l *LSP) parseSettings(raw interface{}) (Settings, error) {
+	settings := l.defaults
+	if raw == nil {
+		return settings, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return settings, err
+	}
+	var nested struct {
+		Gunkls *json.RawMessage `json:"gunkls"`
+	}
+	if err := json.Unmarshal(b, &nested); err == nil && nested.Gunkls != nil {
+		b = *nested.Gunkls
+	}
+	if err := json.Unmarshal(b, &settings); err != nil {
+		return l.defaults, fmt.Errorf("invalid settings: %v", err)
+	}
+	if settings.AnalysisScope != scopeOpen && settings.AnalysisScope != scopeWorkspace {
+		return l.defaults, fmt.Errorf("invalid settings: unknown analysis scope %q", settings.AnalysisScope)
+	}
+	for code, name := range settings.Severity {
+		if _, ok := severities[name]; !ok && name != "off" {
+			return l.defaults, fmt.Errorf("invalid settings: unknown severity %q for %q", name, code)
+		}
+	}
+	return settings, nil
+}
+
+// ChangeConfiguration applies new settings and republishes the diagnostics of
+// all tracked packages, so that the change is reflected immediately.
+func (l *LSP) ChangeConfiguration(ctx context.Context, params protocol.DidChangeConfigurationParams) error {
+	settings, err := l.parseSettings(params.Settings)
+	if err != nil {
+		l.logerr(ctx, err.Error())
+		return err
+	}
+	reload := settings.PackagesDriver != l.settings.PackagesDriver ||
+		settings.GoCommand != l.settings.GoCommand ||
+		!reflect.DeepEqual(settings.environ(), l.settings.environ())
+	exclude := !reflect.DeepEqual(settings.Exclude, l.settings.Exclude)
+	l.settings = settings
+	for _, v := range l.views {
+		var removed []*loader.GunkPackage
+		v.locked(func() {
+			if exclude {
+				v.loader.Exclude = settings.Exclude
+				v.pkgs, removed = v.loader.UpdateExclusions(v.pkgs)
+				v.publish()
+			}
+			if reload {
+				// Packages have to be loaded again with the new
+				// build environment.
+				v.loader.Driver = settings.PackagesDriver
+				v.loader.Env = settings.environ()
+				v.loader.GoCommand = settings.GoCommand
+				v.loader.ReloadModules(v.pkgs)
+				l.indexDependencies(v)
+				v.publish()
+			}
+			v.loader.AnalyzeWorkspace = settings.AnalysisScope == scopeWorkspace
+			for _, pkg := range v.pkgs {
+				if pkg.State != loader.Untracked {
+					pkg.State = loader.Dirty
+				}
+			}
+		})
+		l.clearDiagnostics(ctx, removed)
+	}
+	l.doDiagnostics(ctx)
+	return nil
+}
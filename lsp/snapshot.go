@@ -0,0 +1,79 @@
+package lsp
+
+import (
+	"go/token"
+	"path/filepath"
+
+	"github.com/gunk/gunkls/lsp/loader"
+)
+
+// snapshot is the analyzed state of a view at one point in time: its packages
+// as of their last analysis, or of their last successful one if the last one
+// failed, and the symbol index. A snapshot is never
+// modified once published, so read-only requests use it without any lock
+// while edits and analysis build the next one.
+type snapshot struct {
+	fset *token.FileSet
+	// pkgs are the analyzed packages, by each of their directories.
+	pkgs    map[string]*loader.GunkPackage
+	symbols loader.SymbolIndex
+}
+
+// snapshot returns the latest snapshot of the view.
+func (v *view) snapshot() *snapshot {
+	return v.snap.Load().(*snapshot)
+}
+
+// publish replaces the snapshot of the view with one with the current symbol
+// index, and the given packages, which have just been analyzed. Packages
+// that were removed from the view or evicted are dropped. v.mu must be held.
+func (v *view) publish(analyzed ...*loader.GunkPackage) {
+	old := v.snapshot()
+	s := &snapshot{
+		fset:    v.loader.Fset,
+		pkgs:    make(map[string]*loader.GunkPackage, len(old.pkgs)+len(analyzed)),
+		symbols: v.loader.Symbols(),
+	}
+	live := make(map[string]bool, len(v.pkgs))
+	for _, pkg := range v.pkgs {
+		// Evicted packages have no syntax left, while dirty ones
+		// keep their last analysis until they are analyzed again.
+		for _, dir := range pkg.Dirs {
+			live[dir] = pkg.GunkSyntax != nil || pkg.State == loader.Dirty
+		}
+	}
+	for dir, pkg := range old.pkgs {
+		if live[dir] {
+			s.pkgs[dir] = pkg
+		}
+	}
+	for _, pkg := range analyzed {
+		clone := pkg.Clone()
+		if len(pkg.Dirs) > 0 && failed(pkg) {
+			// Keep serving the last successful analysis, such as
+			// while a typo is being fixed, rather than what little
+			// could be analyzed.
+			if prev := old.pkgs[pkg.Dirs[0]]; prev != nil && !failed(prev) {
+				stale := *prev
+				stale.Stale = true
+				clone = &stale
+			}
+		}
+		for _, dir := range pkg.Dirs {
+			s.pkgs[dir] = clone
+		}
+	}
+	v.snap.Store(s)
+}
+
+// failed reports whether the analysis of a package failed: its files could
+// not be parsed, or it could not be type checked.
+func failed(pkg *loader.GunkPackage) bool {
+	return pkg.TypesInfo == nil || pkg.HasParseErrors()
+}
+
+// filePkg returns the package of a file, as of its last analysis.
+func (s *snapshot) filePkg(file string) (*loader.GunkPackage, bool) {
+	pkg, ok := s.pkgs[filepath.Dir(file)]
+	return pkg, ok
+}
@@ -0,0 +1,294 @@
+package lsp
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kenshaw/snaker"
+	"go.lsp.dev/protocol"
+)
+
+// splitLines is the number of lines above which a file is offered to be split
+// by service.
+const splitLines = 500
+
+// resourceEdit is a workspace edit with resource operations, such as creating
+// files, which protocol.WorkspaceEdit can't hold. Its document changes are
+// protocol.CreateFile and protocol.TextDocumentEdit operations.
+type resourceEdit struct {
+	DocumentChanges []interface{} `json:"documentChanges"`
+}
+
+// resourceAction is a code action with a resourceEdit.
+type resourceAction struct {
+	Title string                  `json:"title"`
+	Kind  protocol.CodeActionKind `json:"kind"`
+	Edit  resourceEdit            `json:"edit"`
+}
+
+// splitUnit is a top-level declaration of a file that is moved as a whole:
+// a type declaration, along with the constants of the enum it declares.
+type splitUnit struct {
+	decls []*ast.GenDecl
+	// refs are the names of the other declarations of the file that the
+	// unit refers to.
+	refs    []string
+	service bool
+}
+
+// splitFile returns the edit splitting a file into a file for each of its
+// services, named after the service without suffix, such as user.gunk for
+// UserService. The messages and enums used by a single service, directly or
+// not, move with it, while the ones shared by several services or used by
+// none stay in the file. It returns nil if pos isn't on the package clause
+// of a file of more than splitLines lines with at least two services, or if
+// exists reports that one of the files to create exists already.
+func (l *LSP) splitFile(file string, src []byte, pos protocol.Position, suffix string, exists func(string) bool) *resourceEdit {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	tf := fset.File(f.Pos())
+	if int(pos.Line) != fset.Position(f.Package).Line-1 || tf.LineCount() <= splitLines {
+		return nil
+	}
+	units := make(map[string]*splitUnit)
+	var order []string
+	var consts []*ast.GenDecl
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		switch gd.Tok {
+		case token.TYPE:
+			if len(gd.Specs) != 1 {
+				// Grouped declarations stay together, in the
+				// file.
+				continue
+			}
+			ts := gd.Specs[0].(*ast.TypeSpec)
+			_, service := ts.Type.(*ast.InterfaceType)
+			units[ts.Name.Name] = &splitUnit{decls: []*ast.GenDecl{gd}, service: service}
+			order = append(order, ts.Name.Name)
+		case token.CONST:
+			consts = append(consts, gd)
+		}
+	}
+	// The values of an enum move with it.
+	for _, gd := range consts {
+		vs, ok := gd.Specs[0].(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+		if id, ok := vs.Type.(*ast.Ident); ok && units[id.Name] != nil {
+			units[id.Name].decls = append(units[id.Name].decls, gd)
+		}
+	}
+	var services []string
+	for _, name := range order {
+		u := units[name]
+		if u.service {
+			services = append(services, name)
+		}
+		ts := u.decls[0].Specs[0].(*ast.TypeSpec)
+		ast.Inspect(ts.Type, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				// Declared in another package.
+				return false
+			case *ast.Ident:
+				if n.Name != name && units[n.Name] != nil {
+					u.refs = append(u.refs, n.Name)
+				}
+			}
+			return true
+		})
+	}
+	if len(services) < 2 {
+		return nil
+	}
+	// owners are the services using each declaration.
+	owners := make(map[string]map[string]bool)
+	for _, service := range services {
+		var visit func(name string)
+		visit = func(name string) {
+			if owners[name] == nil {
+				owners[name] = make(map[string]bool)
+			}
+			if owners[name][service] {
+				return
+			}
+			owners[name][service] = true
+			for _, ref := range units[name].refs {
+				if !units[ref].service {
+					visit(ref)
+				}
+			}
+		}
+		visit(service)
+	}
+
+	nl := "\n"
+	if crlf(src) {
+		nl = "\r\n"
+	}
+	ini := snaker.NewDefaultInitialisms()
+	dir := filepath.Dir(file)
+	edit := &resourceEdit{}
+	var moved [][2]int
+	for _, service := range services {
+		name := strings.TrimSuffix(service, suffix)
+		if name == "" {
+			name = service
+		}
+		newFile := filepath.Join(dir, ini.CamelToSnake(name)+".gunk")
+		if newFile == file || exists(newFile) {
+			return nil
+		}
+		var texts []string
+		for _, name := range order {
+			if len(owners[name]) != 1 || !owners[name][service] {
+				continue
+			}
+			for _, gd := range units[name].decls {
+				start, end := gd.Pos(), gd.End()
+				if gd.Doc != nil {
+					start = gd.Doc.Pos()
+				}
+				texts = append(texts, string(src[tf.Offset(start):tf.Offset(end)]))
+				moved = append(moved, [2]int{tf.Offset(start), tf.Offset(end)})
+			}
+		}
+		body := strings.Join(texts, nl+nl)
+		text := "package " + f.Name.Name + nl + nl
+		if imports := usedImports(f, body); len(imports) > 0 {
+			text += "import (" + nl
+			for _, spec := range imports {
+				text += "\t" + string(src[tf.Offset(spec.Pos()):tf.Offset(spec.End())]) + nl
+			}
+			text += ")" + nl + nl
+		}
+		text += body + nl
+		u := l.fileURI(newFile)
+		edit.DocumentChanges = append(edit.DocumentChanges,
+			protocol.CreateFile{Kind: protocol.CreateResourceOperation, URI: u},
+			protocol.TextDocumentEdit{
+				TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: u},
+				},
+				Edits: []protocol.TextEdit{{NewText: text}},
+			})
+	}
+	sort.Slice(moved, func(i, j int) bool { return moved[i][0] < moved[j][0] })
+	removed := removedRanges(src, moved)
+	edits := make([]protocol.TextEdit, 0, len(removed))
+	// The imports only used by the moved declarations are removed too.
+	var kept strings.Builder
+	last := 0
+	for _, r := range removed {
+		kept.Write(src[last:r[0]])
+		last = r[1]
+	}
+	kept.Write(src[last:])
+	decls := tf.Offset(f.Name.End())
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			decls = tf.Offset(gd.End())
+		}
+	}
+	used := make(map[*ast.ImportSpec]bool)
+	for _, spec := range usedImports(f, kept.String()[decls:]) {
+		used[spec] = true
+	}
+	for _, spec := range f.Imports {
+		if used[spec] {
+			continue
+		}
+		start := tf.LineStart(fset.Position(spec.Pos()).Line)
+		end := tf.Offset(spec.End())
+		if i := bytes.IndexByte(src[end:], '\n'); i >= 0 {
+			end += i + 1
+		}
+		edits = append(edits, protocol.TextEdit{Range: posRange(fset, start, tf.Pos(end))})
+	}
+	for _, r := range removed {
+		rng := posRange(fset, tf.Pos(r[0]), tf.Pos(r[1]))
+		if r[1] == len(src) && bytes.HasSuffix(src, []byte("\n")) {
+			// The end of the file is past its last line.
+			rng.End = protocol.Position{Line: uint32(bytes.Count(src, []byte("\n")))}
+		}
+		edits = append(edits, protocol.TextEdit{Range: rng})
+	}
+	edit.DocumentChanges = append(edit.DocumentChanges, protocol.TextDocumentEdit{
+		TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: l.fileURI(file)},
+		},
+		Edits: edits,
+	})
+	return edit
+}
+
+// usedImports returns the imports of f used in the source of declarations,
+// including in their gunk tags.
+func usedImports(f *ast.File, src string) []*ast.ImportSpec {
+	var used []*ast.ImportSpec
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\.`).MatchString(src) {
+			used = append(used, spec)
+		}
+	}
+	return used
+}
+
+// removedRanges returns the ranges of offsets removing the declarations at
+// the given ranges from src, along with the blank lines following them, or
+// preceding them at the end of the file. The ranges must be in order.
+func removedRanges(src []byte, decls [][2]int) [][2]int {
+	var ranges [][2]int
+	for _, r := range decls {
+		start, end := r[0], r[1]
+		if i := bytes.IndexByte(src[end:], '\n'); i >= 0 {
+			end += i + 1
+		} else {
+			end = len(src)
+		}
+		for end < len(src) {
+			i := bytes.IndexByte(src[end:], '\n')
+			if i < 0 || len(bytes.TrimSpace(src[end:end+i])) > 0 {
+				break
+			}
+			end += i + 1
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] >= start {
+			ranges[n-1][1] = end
+			continue
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	if n := len(ranges); n > 0 && ranges[n-1][1] == len(src) {
+		// Keep the newline ending the last declaration left.
+		start := len(bytes.TrimRight(src[:ranges[n-1][0]], " \t\r\n"))
+		if i := bytes.IndexByte(src[start:], '\n'); i >= 0 {
+			ranges[n-1][0] = start + i + 1
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,121 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/jsonrpc2"
+	"go.lsp.dev/protocol"
+)
+
+// WorkspaceSymbol replies with the declarations in the workspace matching
+// the query, from the symbol index. The search of each view is reported as
+// work done progress.
+func (l *LSP) WorkspaceSymbol(ctx context.Context, params protocol.WorkspaceSymbolParams, reply jsonrpc2.Replier) {
+	p := l.requestProgress(ctx, params.WorkDoneProgressParams, "Searching symbols")
+	infos := make([]protocol.SymbolInformation, 0)
+	// The dependencies shared by several views are indexed by each.
+	seen := make(map[loader.Symbol]bool)
+	for i, v := range l.views {
+		if ctx.Err() != nil {
+			p.end("cancelled")
+			reply(ctx, nil, ctx.Err())
+			return
+		}
+		p.report(fmt.Sprintf("searching %s", v.loader.Dir), uint32(100*i/len(l.views)))
+		s := v.snapshot()
+		for _, sym := range s.symbols.Search(params.Query) {
+			if seen[sym] {
+				continue
+			}
+			seen[sym] = true
+			infos = append(infos, protocol.SymbolInformation{
+				Name:       sym.Name,
+				Kind:       sym.Kind,
+				Tags:       symbolTags(sym),
+				Deprecated: sym.Deprecated,
+				Location: protocol.Location{
+					URI:   l.fileURI(sym.File),
+					Range: sym.Range,
+				},
+			})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name != infos[j].Name {
+			return infos[i].Name < infos[j].Name
+		}
+		return infos[i].Location.URI < infos[j].Location.URI
+	})
+	p.end(fmt.Sprintf("found %d symbols", len(infos)))
+	reply(ctx, infos, nil)
+}
+
+// DocumentSymbol replies with the declarations of a file, from the symbol
+// index. Members are nested in their message, enum or service if it is
+// declared in the same file and the client supports it.
+func (l *LSP) DocumentSymbol(ctx context.Context, params protocol.DocumentSymbolParams, reply jsonrpc2.Replier) {
+	file, err := l.filePath(params.TextDocument.URI)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
+	}
+	syms := v.snapshot().symbols[file]
+	if !l.hierarchicalSymbols {
+		infos := make([]protocol.SymbolInformation, 0, len(syms))
+		for _, sym := range syms {
+			infos = append(infos, protocol.SymbolInformation{
+				Name:       sym.Name,
+				Kind:       sym.Kind,
+				Tags:       symbolTags(sym),
+				Deprecated: sym.Deprecated,
+				Location: protocol.Location{
+					URI:   params.TextDocument.URI,
+					Range: sym.Range,
+				},
+			})
+		}
+		reply(ctx, infos, nil)
+		return
+	}
+	docSyms := make([]protocol.DocumentSymbol, 0, len(syms))
+	// parents are the indexes of the top level symbols by name.
+	parents := make(map[string]int)
+	for _, sym := range syms {
+		docSym := protocol.DocumentSymbol{
+			Name:           sym.Name,
+			Kind:           sym.Kind,
+			Tags:           symbolTags(sym),
+			Deprecated:     sym.Deprecated,
+			Range:          sym.DeclRange,
+			SelectionRange: sym.Range,
+		}
+		if i := strings.IndexByte(sym.Name, '.'); i >= 0 {
+			if p, ok := parents[sym.Name[:i]]; ok {
+				docSym.Name = sym.Name[i+1:]
+				docSyms[p].Children = append(docSyms[p].Children, docSym)
+				continue
+			}
+		} else {
+			parents[sym.Name] = len(docSyms)
+		}
+		docSyms = append(docSyms, docSym)
+	}
+	reply(ctx, docSyms, nil)
+}
+
+// symbolTags returns the tags of a symbol.
+func symbolTags(sym loader.Symbol) []protocol.SymbolTag {
+	if sym.Deprecated {
+		return []protocol.SymbolTag{protocol.SymbolTagDeprecated}
+	}
+	return nil
+}
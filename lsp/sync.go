@@ -3,94 +3,394 @@ package lsp
 import (
 	"context"
 	"fmt"
-	"go/token"
-	"log"
-	"net/url"
+	"sort"
+	"time"
 
 	"github.com/gunk/gunkls/lsp/lint"
 	"github.com/gunk/gunkls/lsp/loader"
 	"go.lsp.dev/protocol"
-	"go.lsp.dev/uri"
+	"go.uber.org/zap"
 )
 
 func (l *LSP) Load(ctx context.Context) error {
-	workspace, err := url.ParseRequestURI(l.workspace.URI)
+	workspace, err := parseURI(l.workspace.URI)
 	if err != nil {
 		return fmt.Errorf("could not load workspace: %w", err)
 	}
 
-	l.loader = &loader.Loader{
-		Dir:   workspace.Path,
-		Fset:  token.NewFileSet(),
-		Types: false,
+	// Each module in the workspace gets its own view, so that packages
+	// are loaded relative to their own go.mod. A workspace without
+	// modules, such as one in GOPATH, is loaded as a single view, while
+	// files outside of the modules of a monorepo get a view when opened.
+	if isRemote(workspace) {
+		// Remote workspaces are read from the client as files are
+		// opened.
+		l.views = append([]*view{l.newRemoteView(workspace)}, l.views...)
+		return nil
 	}
+	l.scanning(ctx)
+	// Report the state once the modules are loaded, which is idle if no
+	// packages were queued for indexing.
+	defer l.queueIndexing(ctx, 0)
+	root := l.links.resolve(workspace)
+	roots, err := findModules(root, l.settings.Exclude)
+	if err != nil {
+		return fmt.Errorf("could not find modules: %w", err)
+	}
+	if len(roots) == 0 {
+		roots = []string{root}
+	}
+	views := make([]*view, len(roots))
+	for i, root := range roots {
+		views[i] = l.newView(root)
+	}
+	l.views = append(views, l.views...)
+
+	var firstErr error
+	for _, v := range views {
+		if err := l.loadView(ctx, v); err != nil {
+			if len(views) == 1 {
+				return err
+			}
+			l.logerr(ctx, "Could not load "+v.loader.Dir+": "+err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// loadView loads all packages of a view, and indexes them in the background.
+func (l *LSP) loadView(ctx context.Context, v *view) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 
-	l.pkgs, err = l.loader.Load(workspace.Path + "/...")
+	var err error
+	v.pkgs, err = v.loader.Load(v.loader.Dir + "/...")
 	if err != nil {
 		return err
 	}
-
+	// Index the view in the background, one package at a time.
+	l.queueIndexing(ctx, len(v.pkgs))
+	for _, pkg := range v.pkgs {
+		pkgs := []*loader.GunkPackage{pkg}
+		l.sched.schedule(background, func() {
+			defer l.packageIndexed(ctx)
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			v.loader.IndexPackages(pkgs)
+			v.publish()
+		})
+	}
+	if l.settings.DiagnoseWorkspace {
+		// Once indexed, the packages are analyzed in the background
+		// too, after the ones with open files.
+		for _, pkg := range v.pkgs {
+			pkg := pkg
+			l.sched.schedule(background, func() {
+				l.diagnoseUntracked(ctx, v, pkg)
+			})
+		}
+	}
+	// The build list is only listed once the workspace is usable.
+	l.indexDependencies(v)
 	return nil
 }
 
+// indexDependencies indexes the gunk files of the dependency modules of a view
+// in the background, after the jobs already scheduled, such as the indexing
+// of its own packages.
+func (l *LSP) indexDependencies(v *view) {
+	l.sched.schedule(background, func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if err := v.loader.IndexDependencies(); err != nil {
+			l.logger.Warn("could not index dependencies", zap.String("dir", v.loader.Dir), zap.Error(err))
+		}
+		v.publish()
+	})
+}
+
+// diagnoseUntracked publishes the diagnostics of a package of the workspace
+// that wasn't analyzed yet, such as one without open files.
+func (l *LSP) diagnoseUntracked(ctx context.Context, v *view, pkg *loader.GunkPackage) {
+	l.mu.RLock()
+	settings := l.settings
+	l.mu.RUnlock()
+	var diags map[string][]protocol.Diagnostic
+	v.locked(func() {
+		if pkg.State != loader.Untracked || !loader.ContainsPkg(v.pkgs, pkg) {
+			// Already analyzed, or removed since.
+			return
+		}
+		pkg.State = loader.Dirty
+		diags, _ = l.analyze(ctx, v, pkg, settings, lint.NewReferences())
+	})
+	l.publishDiagnostics(ctx, diags)
+}
+
 func (l *LSP) OpenFile(ctx context.Context, data protocol.DidOpenTextDocumentParams) error {
-	path := data.TextDocument.URI.Filename()
+	path, err := l.filePath(data.TextDocument.URI)
+	if err != nil {
+		l.logerr(ctx, err.Error())
+		return err
+	}
+	l.openURI(data.TextDocument.URI)
+	v := l.viewOf(ctx, path)
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	// Add to pkgs
-	var err error
-	l.pkgs, _, err = l.loader.AddFile(l.pkgs, path, data.TextDocument.Text)
+	v.pkgs, _, err = v.loader.AddFile(v.pkgs, path, data.TextDocument.Text)
 	if err != nil {
-		log.Println("error adding new file:", err)
+		l.logger.Warn("could not add opened file", zap.String("path", path), zap.Error(err))
 	}
+	v.publish()
 	l.doDiagnostics(ctx)
 	return err
 }
 
 func (l *LSP) UpdateFile(ctx context.Context, data protocol.DidChangeTextDocumentParams) error {
-	path := data.TextDocument.URI.Filename()
+	path, err := l.filePath(data.TextDocument.URI)
+	if err != nil {
+		l.logerr(ctx, err.Error())
+		return err
+	}
+	v := l.viewOf(ctx, path)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	l.duplicates.forget(path)
 	// Add to pkgs
-	var err error
-	l.pkgs, err = l.loader.UpdateFile(l.pkgs, path, data.ContentChanges[0].Text)
+	v.pkgs, err = v.loader.UpdateFile(v.pkgs, path, data.ContentChanges[0].Text)
 	if err != nil {
-		log.Println("error adding new file:", err)
+		l.logger.Warn("could not update file", zap.String("path", path), zap.Error(err))
 	}
-	l.doDiagnostics(ctx)
+	v.publish()
+	l.delayDiagnostics(ctx, time.Duration(l.settings.DiagnosticsDelay)*time.Millisecond)
 	return err
 }
 
 func (l *LSP) CloseFile(ctx context.Context, data protocol.DidCloseTextDocumentParams) error {
-	path := data.TextDocument.URI.Filename()
-	var err error
-	l.pkgs, err = l.loader.CloseFile(l.pkgs, path)
+	path, err := l.filePath(data.TextDocument.URI)
+	if err != nil {
+		l.logerr(ctx, err.Error())
+		return err
+	}
+	l.closeURI(data.TextDocument.URI)
+	v := l.findView(path)
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.pkgs, err = v.loader.CloseFile(v.pkgs, path)
 	if err != nil {
-		log.Println("error adding closing file:", err)
+		l.logger.Warn("could not close file", zap.String("path", path), zap.Error(err))
 	}
+	v.publish()
 	l.doDiagnostics(ctx)
 	return nil
 }
 
+// doDiagnostics schedules computing and publishing the diagnostics of all
+// dirty packages in the background, once the current request releases the
+// lock.
+// An analysis still running from a previous call is cancelled, since its
+// results would be outdated.
 func (l *LSP) doDiagnostics(ctx context.Context) {
-	for _, pkg := range l.pkgs {
-		if pkg.State != loader.Dirty {
-			continue
-		}
+	l.delayDiagnostics(ctx, 0)
+}
 
-		diags, err := l.loader.Errors(l.pkgs, pkg)
-		if err != nil {
-			log.Printf("could not load diagnostics: %v", err)
+// delayDiagnostics is like doDiagnostics, but waits for delay first. As
+// every call cancels the previous analysis, a burst of edits only analyzes
+// the packages once.
+func (l *LSP) delayDiagnostics(ctx context.Context, delay time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	l.cancelDiagnostics()
+	l.cancelMu.Lock()
+	l.cancel = cancel
+	l.cancelMu.Unlock()
+	go func() {
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
 		}
+		l.sched.schedule(interactive, func() {
+			defer cancel()
+			l.diagnose(ctx)
+		})
+	}()
+}
 
-		// Don't add linting errors if there are already errors.
-		if l.lint && len(pkg.Errors) == 0 {
-			for k, d := range lint.LintPkg(ctx, pkg, l.loader) {
-				diags[k] = append(diags[k], d...)
+// cancelDiagnostics cancels the running analysis, if any. It doesn't need
+// l.mu, so that new edits can stop an analysis holding it.
+func (l *LSP) cancelDiagnostics() {
+	l.cancelMu.Lock()
+	defer l.cancelMu.Unlock()
+	if l.cancel != nil {
+		l.cancel()
+		l.cancel = nil
+	}
+}
+
+// diagnose publishes the diagnostics of all dirty packages, marking them as
+// up to date. It stops early if ctx is cancelled, leaving the remaining
+// packages dirty for the next analysis.
+func (l *LSP) diagnose(ctx context.Context) {
+	l.mu.RLock()
+	views := append([]*view(nil), l.views...)
+	settings := l.settings
+	l.mu.RUnlock()
+	for _, v := range views {
+		refs := lint.NewReferences()
+		for {
+			if ctx.Err() != nil {
+				return
 			}
+			diags, ok := l.diagnoseNext(ctx, v, settings, refs)
+			if !ok {
+				break
+			}
+			l.publishDiagnostics(ctx, diags)
 		}
-		// send out notifs
-		for file, d := range diags {
-			l.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{
-				URI:         uri.File(file),
-				Diagnostics: d,
-			})
+	}
+}
+
+// publishDiagnostics sends the diagnostics of files to the client.
+func (l *LSP) publishDiagnostics(ctx context.Context, diags map[string][]protocol.Diagnostic) {
+	for file, d := range diags {
+		l.conn.Notify(ctx, protocol.MethodTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{
+			URI:         l.fileURI(file),
+			Diagnostics: d,
+		})
+	}
+}
+
+// clearDiagnostics clears the diagnostics of the files of packages that are
+// no longer loaded.
+func (l *LSP) clearDiagnostics(ctx context.Context, pkgs []*loader.GunkPackage) {
+	cleared := make(map[string][]protocol.Diagnostic)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.GunkFiles {
+			cleared[file] = []protocol.Diagnostic{}
+		}
+	}
+	l.publishDiagnostics(ctx, cleared)
+}
+
+// diagnoseNext computes the diagnostics of the next dirty package of a view,
+// and marks it as up to date. It returns false if there are no dirty packages
+// left, or if ctx was cancelled during the analysis. refs is the reference
+// index of the analysis pass.
+// The view is only locked while analyzing a single package, so that requests
+// and edits are handled between packages.
+func (l *LSP) diagnoseNext(ctx context.Context, v *view, settings Settings, refs *lint.References) (map[string][]protocol.Diagnostic, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var pkg *loader.GunkPackage
+	for _, p := range v.pkgs {
+		if p.State == loader.Dirty {
+			pkg = p
+			break
+		}
+	}
+	if pkg == nil {
+		return nil, false
+	}
+	return l.analyze(ctx, v, pkg, settings, refs)
+}
+
+// analyze computes the diagnostics of a dirty package, and marks it as up to
+// date, with the reference index refs. It returns false if ctx was cancelled
+// during the analysis. v.mu must be held.
+func (l *LSP) analyze(ctx context.Context, v *view, pkg *loader.GunkPackage, settings Settings, refs *lint.References) (map[string][]protocol.Diagnostic, bool) {
+	start := time.Now()
+	diags, err := v.loader.Errors(v.pkgs, pkg)
+	if err != nil {
+		l.logger.Warn("could not load diagnostics", zap.String("package", pkg.PkgPath), zap.Error(err))
+	}
+
+	// Don't add linting errors if there are already errors.
+	if len(pkg.Errors) == 0 {
+		for k, d := range lint.LintPkg(ctx, pkg, v.pkgs, refs, v.loader, settings.Lint) {
+			diags[k] = append(diags[k], d...)
+		}
+	}
+	// The .proto files referenced by the configuration are checked even
+	// if the package has errors.
+	for k, d := range protoDiagnostics(v.loader, pkg) {
+		if diags[k] == nil {
+			diags[k] = d
+			continue
 		}
+		diags[k] = append(diags[k], d...)
+	}
+	// The hints on duplicated messages are kept until the command finding
+	// them runs again, or their file is edited.
+	for _, file := range pkg.GunkFiles {
+		if d := l.duplicates.file(file); len(d) > 0 {
+			diags[file] = append(diags[file], d...)
+		}
+	}
+	if ctx.Err() != nil {
+		// Don't publish diagnostics for outdated contents.
+		return nil, false
+	}
+	for file, d := range diags {
+		diags[file] = sortDiagnostics(settings.overrideSeverity(settings.setSource(d)))
+	}
+	pkg.State = loader.Open
+	v.publish(pkg)
+	l.logger.Debug("diagnosed package", zap.String("package", pkg.PkgPath), zap.Duration("latency", time.Since(start)))
+	return diags, true
+}
+
+// sortDiagnostics sorts the diagnostics of a file by position, so that they
+// are published in the same order every time, and removes the duplicates
+// with the same range, code and message, such as an error reported both
+// when loading and when type checking the package. Only the most severe of
+// the duplicates is kept.
+func sortDiagnostics(diags []protocol.Diagnostic) []protocol.Diagnostic {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.Range.Start != b.Range.Start {
+			return positionLess(a.Range.Start, b.Range.Start)
+		}
+		if a.Range.End != b.Range.End {
+			return positionLess(a.Range.End, b.Range.End)
+		}
+		if ac, bc := fmt.Sprint(a.Code), fmt.Sprint(b.Code); ac != bc {
+			return ac < bc
+		}
+		if a.Message != b.Message {
+			return a.Message < b.Message
+		}
+		// Duplicates are next to each other, with the most severe one
+		// first, which is the one kept.
+		return a.Severity < b.Severity
+	})
+	unique := diags[:0]
+	for i, d := range diags {
+		if i > 0 {
+			prev := unique[len(unique)-1]
+			if d.Range == prev.Range && fmt.Sprint(d.Code) == fmt.Sprint(prev.Code) && d.Message == prev.Message {
+				continue
+			}
+		}
+		unique = append(unique, d)
+	}
+	return unique
+}
+
+// positionLess reports whether a is before b.
+func positionLess(a, b protocol.Position) bool {
+	if a.Line != b.Line {
+		return a.Line < b.Line
 	}
+	return a.Character < b.Character
 }
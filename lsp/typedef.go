@@ -7,47 +7,50 @@ import (
 	"go/ast"
 	"go/token"
 	"go/types"
+	"path/filepath"
 	"strconv"
 
 	"github.com/gunk/gunkls/lsp/loader"
 	"go.lsp.dev/jsonrpc2"
 	"go.lsp.dev/protocol"
-	"go.lsp.dev/uri"
 )
 
 var invalidType = errors.New("can only go to definition on struct or enum types")
 
 func (l *LSP) Goto(ctx context.Context, params protocol.DefinitionParams, reply jsonrpc2.Replier) {
-	file := params.TextDocument.URI.Filename()
-	pkg, err := l.filePkg(file)
+	file, err := l.filePath(params.TextDocument.URI)
 	if err != nil {
 		reply(ctx, nil, err)
 		return
 	}
-	// does this file have errors, or another file?
-	var fileErr bool
-	for _, err := range pkg.Errors {
-		if err.File == file && err.Kind != loader.ValidateError {
-			fileErr = true
-			break
-		}
+	v, err := l.fileView(file)
+	if err != nil {
+		reply(ctx, nil, err)
+		return
 	}
-	if fileErr {
-		reply(ctx, nil, fmt.Errorf("file %s has errors", file))
+	if filepath.Base(file) == ".gunkconfig" {
+		// Parameters mapping .proto files go to the vendored files.
+		reply(ctx, l.configProtoLocation(v, file, params.Position), nil)
 		return
 	}
-	// find the file
-	var f *ast.File
-	for i, path := range pkg.GunkFiles {
-		if path == file {
-			f = pkg.GunkSyntax[i]
-			break
-		}
+	s := v.snapshot()
+	pkg, ok := s.filePkg(file)
+	if !ok {
+		reply(ctx, nil, fmt.Errorf("package of %s has not been analyzed yet", file))
+		return
 	}
+	// Files with errors are resolved as far as they were analyzed, so
+	// that an error in one declaration doesn't prevent going to the
+	// definitions used in the others.
+	f := pkgFile(pkg, file)
 	if f == nil {
 		reply(ctx, nil, fmt.Errorf("could not find file %s", file))
 		return
 	}
+	if locs := l.annotationProtoLocation(v, s.fset, f, file, params.Position); locs != nil {
+		reply(ctx, locs, nil)
+		return
+	}
 	// LSP params are 0 indexed
 	pos := params.Position
 	pos.Character++
@@ -72,9 +75,9 @@ func (l *LSP) Goto(ctx context.Context, params protocol.DefinitionParams, reply
 		default:
 			return false
 		case *ast.File, *ast.GenDecl, *ast.TypeSpec, *ast.FieldList, *ast.Field, *ast.StructType, *ast.InterfaceType:
-			return contains(l.loader.Fset, node, pos)
+			return contains(s.fset, node, pos)
 		case *ast.ArrayType, *ast.FuncType, *ast.ChanType, *ast.MapType:
-			if !contains(l.loader.Fset, node, pos) {
+			if !contains(s.fset, node, pos) {
 				return false
 			}
 			// Make a note that we are inside these types so we can notify the
@@ -82,18 +85,18 @@ func (l *LSP) Goto(ctx context.Context, params protocol.DefinitionParams, reply
 			foundTyp = true
 			return true
 		case *ast.ImportSpec:
-			if !contains(l.loader.Fset, node, pos) {
+			if !contains(s.fset, node, pos) {
 				return false
 			}
-			l.gotoImport(ctx, node, reply)
+			l.gotoImport(ctx, v, pkg, node, reply)
 			panic(bailout{})
 		case *ast.SelectorExpr, *ast.Ident:
-			if !contains(l.loader.Fset, node, pos) {
+			if !contains(s.fset, node, pos) {
 				return false
 			}
 			// node must be an expression as it can only be selector or identifier.
 			n := node.(ast.Expr)
-			l.gotoType(ctx, pkg, n, reply)
+			l.gotoType(ctx, s, pkg, n, reply)
 			panic(bailout{})
 		}
 	})
@@ -107,32 +110,59 @@ func (l *LSP) Goto(ctx context.Context, params protocol.DefinitionParams, reply
 }
 
 // gotoImport handles goto requests when the cursor is on an import path.
-func (l *LSP) gotoImport(ctx context.Context, spec *ast.ImportSpec, reply jsonrpc2.Replier) {
-	// Load the package specified.
+func (l *LSP) gotoImport(ctx context.Context, v *view, from *loader.GunkPackage, spec *ast.ImportSpec, reply jsonrpc2.Replier) {
 	path, _ := strconv.Unquote(spec.Path.Value)
-	pkgs, err := l.loader.Load(path)
-	if err != nil || len(pkgs) > 1 {
-		reply(ctx, nil, fmt.Errorf("unexpected error loading %q: %v", path, err))
-		return
-	}
-	if len(pkgs) == 0 {
-		reply(ctx, nil, fmt.Errorf("no gunk files in package %s", spec.Path.Value))
-		return
+	var gunkFiles []string
+	if pkg := from.Imports[path]; pkg != nil {
+		gunkFiles = pkg.GunkFiles
+	} else {
+		// The package was not imported during type checking, such as
+		// when it has errors. Load the package specified.
+		var pkgs []*loader.GunkPackage
+		var err error
+		v.locked(func() {
+			pkgs, err = v.loader.Load(path)
+			if len(pkgs) == 1 {
+				gunkFiles = pkgs[0].GunkFiles
+			}
+		})
+		if err != nil || len(pkgs) > 1 {
+			reply(ctx, nil, fmt.Errorf("unexpected error loading %q: %v", path, err))
+			return
+		}
+		if len(pkgs) == 0 {
+			reply(ctx, nil, fmt.Errorf("no gunk files in package %s", spec.Path.Value))
+			return
+		}
 	}
 	// Create the list of files to reply with.
-	pkg := pkgs[0]
-	files := make([]protocol.Location, 0, len(pkg.GunkFiles))
-	for _, v := range pkg.GunkFiles {
+	files := make([]protocol.Location, 0, len(gunkFiles))
+	for _, v := range gunkFiles {
 		files = append(files, protocol.Location{
-			URI: uri.File(v),
+			URI: l.fileURI(v),
 		})
 	}
 	reply(ctx, files, nil)
 }
 
 // gotoIdent handles goto requests when the cursor is on a type.
-func (l *LSP) gotoType(ctx context.Context, pkg *loader.GunkPackage, expr ast.Expr, reply jsonrpc2.Replier) {
-	typAndValue := pkg.TypesInfo.Types[expr]
+func (l *LSP) gotoType(ctx context.Context, s *snapshot, pkg *loader.GunkPackage, expr ast.Expr, reply jsonrpc2.Replier) {
+	if pkg.TypesInfo == nil {
+		reply(ctx, nil, fmt.Errorf("could not resolve %s: package %s could not be type checked", types.ExprString(expr), pkg.PkgPath))
+		return
+	}
+	typAndValue, ok := pkg.TypesInfo.Types[expr]
+	if !ok || typAndValue.Type == types.Typ[types.Invalid] {
+		if id, isIdent := expr.(*ast.Ident); isIdent {
+			if _, ok := pkg.TypesInfo.Defs[id]; ok {
+				// A declaration rather than a type. Ignore.
+				reply(ctx, nil, nil)
+				return
+			}
+		}
+		reply(ctx, nil, fmt.Errorf("could not resolve %s", types.ExprString(expr)))
+		return
+	}
 	if !typAndValue.IsType() {
 		// Not a type. Ignore.
 		reply(ctx, nil, nil)
@@ -147,13 +177,13 @@ func (l *LSP) gotoType(ctx context.Context, pkg *loader.GunkPackage, expr ast.Ex
 		reply(ctx, nil, invalidType)
 		return
 	case *types.Named:
-		pos := l.loader.Fset.Position(typ.Obj().Pos())
+		pos := s.fset.Position(typ.Obj().Pos())
 		if !pos.IsValid() {
 			reply(ctx, nil, invalidType)
 			return
 		}
 		loc := protocol.Location{
-			URI: uri.File(pos.Filename),
+			URI: l.fileURI(pos.Filename),
 			Range: protocol.Range{
 				Start: protocol.Position{
 					Line:      uint32(pos.Line - 1),
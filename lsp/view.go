@@ -0,0 +1,191 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+)
+
+// view is a tree of gunk packages loaded by one loader: the workspace, one of
+// the modules in it, or the module of a file opened outside of the workspace.
+type view struct {
+	// mu guards the loader and the packages. Analysis holds it for one
+	// package at a time, so that requests don't wait for all packages to
+	// be analyzed.
+	mu sync.Mutex
+
+	loader *loader.Loader
+	pkgs   []*loader.GunkPackage
+
+	// snap holds the latest *snapshot, read without holding mu.
+	snap atomic.Value
+}
+
+// locked runs f with the view locked. The view is unlocked even if f panics,
+// so that the server keeps working once the panic is recovered.
+func (v *view) locked(f func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	f()
+}
+
+func (l *LSP) newView(dir string) *view {
+	v := &view{
+		loader: &loader.Loader{
+			Dir:              dir,
+			Fset:             token.NewFileSet(),
+			Types:            true,
+			Driver:           l.settings.PackagesDriver,
+			Env:              l.settings.environ(),
+			GoCommand:        l.settings.GoCommand,
+			Shared:           l.cache,
+			Logger:           l.logger,
+			Exclude:          l.settings.Exclude,
+			AnalyzeWorkspace: l.settings.AnalysisScope == scopeWorkspace,
+		},
+	}
+	v.snap.Store(&snapshot{fset: v.loader.Fset})
+	return v
+}
+
+// newRemoteView returns a view for the remote documents under dir.
+func (l *LSP) newRemoteView(dir string) *view {
+	v := l.newView(dir)
+	v.loader.Remote = l.remote
+	return v
+}
+
+// findView returns the view whose directory is the closest parent of path,
+// or nil if path is outside of all views.
+func (l *LSP) findView(path string) *view {
+	var found *view
+	for _, v := range l.views {
+		if !loader.InDir(v.loader.Dir, path) {
+			continue
+		}
+		if found == nil || len(v.loader.Dir) > len(found.loader.Dir) {
+			found = v
+		}
+	}
+	return found
+}
+
+// fileView returns the view of an open file, for requests that can't create
+// one.
+func (l *LSP) fileView(file string) (*view, error) {
+	v := l.findView(file)
+	if v == nil {
+		return nil, fmt.Errorf("no package loaded for %s", file)
+	}
+	return v, nil
+}
+
+// filePkg returns the package of a file. v.mu must be held.
+func (v *view) filePkg(file string) (*loader.GunkPackage, error) {
+	dir := filepath.Dir(file)
+	// We should be able to assume that the file is already parsed
+	// and this is called only on open files with an up to date AST
+	pkgs, err := v.loader.Load(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not load package: %v", err)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected 1 package, got %d", len(pkgs))
+	}
+	return pkgs[0], nil
+}
+
+// viewOf returns the view of the file at path. Files outside of all views,
+// such as files opened without a workspace, get a view rooted at their
+// module, or at their directory if they are not in a module.
+func (l *LSP) viewOf(ctx context.Context, path string) *view {
+	if v := l.findView(path); v != nil {
+		return v
+	}
+	if isRemote(path) {
+		// Remote files are read from the client, so there is no
+		// module to look for: use one view for each authority.
+		rel, _ := filepath.Rel(remoteRoot, path)
+		parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+		v := l.newRemoteView(filepath.Join(remoteRoot, parts[0], parts[1]))
+		l.views = append(l.views, v)
+		l.log(ctx, "Reading "+string(l.fileURI(v.loader.Dir))+" from the client")
+		return v
+	}
+	dir := filepath.Dir(path)
+	if root := moduleRoot(dir); root != "" {
+		dir = root
+	}
+	v := l.newView(dir)
+	l.views = append(l.views, v)
+	l.log(ctx, "Loading "+dir+" for files outside of the workspace")
+	return v
+}
+
+// moduleRoot returns the closest parent of dir containing a go.mod file, or
+// an empty string if there is none.
+func moduleRoot(dir string) string {
+	for {
+		if loader.IsModule(dir) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// findModules returns the roots of the modules in dir, including dir itself,
+// skipping the directories that the go command ignores, the excluded ones and
+// the ones ignored by ignore files.
+func findModules(dir string, exclude []string) ([]string, error) {
+	var roots []string
+	ignores := loader.NewIgnores(dir, os.ReadFile)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+			name == "vendor" || name == "testdata" || loader.Excluded(dir, path, exclude) || ignores.Ignored(path)) {
+			return filepath.SkipDir
+		}
+		if loader.IsModule(path) {
+			roots = append(roots, path)
+		}
+		return nil
+	})
+	return roots, err
+}
+
+// workspaceFolder returns the folder to load as the workspace: the first
+// workspace folder, or the root of clients that don't support folders.
+func workspaceFolder(params protocol.InitializeParams) (protocol.WorkspaceFolder, bool) {
+	if len(params.WorkspaceFolders) > 0 {
+		return params.WorkspaceFolders[0], true
+	}
+	if params.RootURI != "" {
+		name := string(params.RootURI)
+		if path, err := parseURI(name); err == nil {
+			name = filepath.Base(path)
+		}
+		return protocol.WorkspaceFolder{
+			URI:  string(params.RootURI),
+			Name: name,
+		}, true
+	}
+	return protocol.WorkspaceFolder{}, false
+}
@@ -0,0 +1,74 @@
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// commandWarmup type-checks the packages imported by the workspace, such as
+// annotation packages, the standard library and gunk dependencies, so that
+// the first requests after startup don't wait for them to be loaded.
+const commandWarmup = "gunkls.warmup"
+
+// Warmup type-checks the packages imported by the packages of all views in
+// the background, one package at a time, and replies with the number of
+// packages type-checked once done. Its progress is reported to the client,
+// which may cancel it.
+func (l *LSP) Warmup(ctx context.Context, reply jsonrpc2.Replier) {
+	start := time.Now()
+	work, cancel := context.WithCancel(ctx)
+	p := l.startProgress(ctx, "Warming up", cancel)
+	var wg sync.WaitGroup
+	// checked, done and total are only updated by jobs, which run one at a
+	// time.
+	var checked, done, total int
+	for _, v := range l.views {
+		v := v
+		wg.Add(1)
+		l.sched.schedule(background, func() {
+			defer wg.Done()
+			if work.Err() != nil {
+				return
+			}
+			var paths []string
+			v.locked(func() {
+				paths = v.loader.ImportPaths(v.pkgs)
+			})
+			total += len(paths)
+			for _, path := range paths {
+				path := path
+				wg.Add(1)
+				l.sched.schedule(background, func() {
+					defer wg.Done()
+					if work.Err() != nil {
+						return
+					}
+					v.locked(func() {
+						if _, err := v.loader.Import(path); err == nil {
+							checked++
+						}
+					})
+					done++
+					p.report(fmt.Sprintf("type-checked %d/%d packages", done, total), uint32(100*done/total))
+				})
+			}
+		})
+	}
+	l.goReply(ctx, commandWarmup, reply, func() (interface{}, error) {
+		wg.Wait()
+		cancelled := work.Err() != nil
+		cancel()
+		if cancelled {
+			p.end("cancelled")
+			l.log(ctx, fmt.Sprintf("Cancelled warmup after %d packages", checked))
+		} else {
+			p.end("done")
+			l.log(ctx, fmt.Sprintf("Warmed up %d packages in %v", checked, time.Since(start).Round(time.Millisecond)))
+		}
+		return checked, nil
+	})
+}
@@ -0,0 +1,166 @@
+package lsp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gunk/gunkls/lsp/loader"
+	"go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// registerWatchers asks the client to notify the server of changes to gunk
+// files and their configuration. Clients that can't watch files for the
+// server are watched for by the server itself.
+func (l *LSP) registerWatchers(ctx context.Context) {
+	if !l.watchFiles {
+		l.startWatcher(ctx)
+		return
+	}
+	params := protocol.RegistrationParams{
+		Registrations: []protocol.Registration{{
+			ID:     "gunkls-watch",
+			Method: protocol.MethodWorkspaceDidChangeWatchedFiles,
+			RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{
+				Watchers: []protocol.FileSystemWatcher{
+					{GlobPattern: "**/*.gunk"},
+					{GlobPattern: "**/.gunkconfig"},
+					{GlobPattern: "**/go.mod"},
+					{GlobPattern: "**/go.sum"},
+					{GlobPattern: "**/.gitignore"},
+					{GlobPattern: "**/.gunklsignore"},
+				},
+			},
+		}},
+	}
+	// Requests are handled one at a time, so the response can't be read
+	// until the current request returns.
+	go func() {
+		if _, err := l.conn.Call(ctx, protocol.MethodClientRegisterCapability, params, nil); err != nil {
+			l.logerr(ctx, "Could not register file watchers: "+err.Error())
+		}
+	}()
+}
+
+// startWatcher watches the workspace root and the directories of the loaded
+// gunk packages, and handles changes like the client's notifications.
+func (l *LSP) startWatcher(ctx context.Context) {
+	if len(l.views) == 0 {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		l.logerr(ctx, "Could not watch files: "+err.Error())
+		return
+	}
+	l.watcher = watcher
+	dirs := make(map[string]bool)
+	for _, v := range l.views {
+		if v.loader.Remote != nil {
+			continue
+		}
+		v.mu.Lock()
+		dirs[v.loader.Dir] = true
+		for _, pkg := range v.pkgs {
+			for _, dir := range pkg.Dirs {
+				dirs[dir] = true
+			}
+		}
+		v.mu.Unlock()
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			l.logerr(ctx, "Could not watch "+dir+": "+err.Error())
+		}
+	}
+	l.goRecover("file watcher", func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Create != 0 {
+					// Watch new directories, which may be new
+					// packages.
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+						watcher.Add(event.Name)
+						continue
+					}
+				}
+				if !watched(event.Name) {
+					continue
+				}
+				params := protocol.DidChangeWatchedFilesParams{
+					Changes: []*protocol.FileEvent{{
+						Type: protocol.FileChangeTypeChanged,
+						URI:  uri.File(event.Name),
+					}},
+				}
+				l.sched.schedule(interactive, func() {
+					l.mu.Lock()
+					defer l.mu.Unlock()
+					l.ChangeWatchedFiles(ctx, params)
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				l.logerr(ctx, "Error watching files: "+err.Error())
+			}
+		}
+	})
+}
+
+// watched reports whether changes to the file at path affect the loaded
+// packages.
+func watched(path string) bool {
+	base := filepath.Base(path)
+	return base == ".gunkconfig" || base == "go.mod" || base == "go.sum" || filepath.Ext(base) == ".gunk" ||
+		loader.IsIgnoreFile(base)
+}
+
+// ChangeWatchedFiles updates the packages affected by gunk files, .gunkconfig
+// files, ignore files and module files changed on disk, and resends their
+// diagnostics.
+func (l *LSP) ChangeWatchedFiles(ctx context.Context, params protocol.DidChangeWatchedFilesParams) {
+	var changed bool
+	var removed []*loader.GunkPackage
+	for _, change := range params.Changes {
+		path, err := l.filePath(change.URI)
+		if err != nil {
+			continue
+		}
+		if isRemote(path) {
+			l.remote.forget(path)
+		}
+		v := l.findView(path)
+		if v == nil || !watched(path) {
+			continue
+		}
+		v.locked(func() {
+			switch {
+			case filepath.Base(path) == ".gunkconfig":
+				v.loader.ReloadConfig(v.pkgs, path)
+			case filepath.Ext(path) == ".gunk":
+				v.pkgs = v.loader.FileChanged(v.pkgs, path)
+			case loader.IsIgnoreFile(path):
+				var dropped []*loader.GunkPackage
+				v.pkgs, dropped = v.loader.IgnoreFileChanged(v.pkgs)
+				removed = append(removed, dropped...)
+			default:
+				v.loader.ReloadModules(v.pkgs)
+				l.indexDependencies(v)
+			}
+			v.publish()
+		})
+		changed = true
+	}
+	if changed {
+		l.clearDiagnostics(ctx, removed)
+		l.doDiagnostics(ctx)
+	}
+}
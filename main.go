@@ -2,55 +2,182 @@ package main
 
 import (
 	"context"
+	"errors"
+	_ "expvar"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"strings"
 
 	"github.com/gunk/gunkls/lsp"
+	"github.com/gunk/gunkls/lsp/loader"
 
 	"go.lsp.dev/jsonrpc2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 const version = "0.0.1"
 
-var (
-	pprofPort = flag.Int("pprof", -1, "enables pprof on the specified port")
-	lint      = flag.Bool("lint", false, "run linter")
-)
-
 func main() {
-	if err := run(context.Background()); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v", err)
+	if loader.IsGoDriver() {
+		// Run by go/packages to list packages with the go binary of a
+		// session.
+		if err := loader.RunGoDriver(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	loader.GoDriver, _ = os.Executable()
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func run(ctx context.Context) error {
-	flag.Parse()
+// commands are the subcommands of gunkls, by name.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"serve":   runServe,
+	"lint":    runLint,
+	"format":  runFormat,
+	"warmup":  runWarmup,
+	"version": runVersion,
+}
+
+const usage = `usage: gunkls <command> [arguments]
+
+Commands:
+  serve     run the language server (the default)
+  lint      report the diagnostics and lint findings of gunk packages
+  format    format the gunk files of packages
+  warmup    fill the caches with the packages imported by gunk packages
+  version   print the version of gunkls
+
+Run gunkls <command> -h for the flags of a command.
+`
+
+func run(ctx context.Context, args []string) error {
+	// Editors run gunkls without a command, or with the flags of serve.
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return nil
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd(ctx, args)
+}
+
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	pprofPort := fs.Int("pprof", -1, "enables pprof and the metrics at /debug/vars on the specified port")
+	lint := fs.Bool("lint", false, "run linter")
+	listenOn := fs.String("listen", "", "listen for clients on a unix socket (unix:/path/to.sock), a named pipe on Windows (pipe:name) or a TCP address, instead of stdio")
+	logFile := fs.String("logfile", "", "write the log to the file instead of stderr")
+	verbose := fs.Bool("v", false, "verbose logging, including a trace of requests and their latencies")
+	fs.Parse(args)
+
+	logger, err := newLogger(*logFile, *verbose)
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
 
 	if *pprofPort > 0 {
-		log.Println("starting pprof on port", *pprofPort)
+		logger.Info("starting pprof and metrics", zap.Int("port", *pprofPort))
 		go func() {
 			http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", *pprofPort), nil)
 		}()
 	}
-	log.Println("gunkls: reading on stdin, writing on stdout")
+	config := lsp.Config{
+		Lint:    *lint,
+		Version: version,
+		Logger:  logger,
+	}
 	if *lint {
-		log.Println("gunkls: linting enabled")
+		logger.Info("linting enabled")
+	}
+	if *listenOn == "" {
+		logger.Info("reading on stdin, writing on stdout")
+		return serve(ctx, stdrwc{}, config)
 	}
 
-	stream := jsonrpc2.NewStream(stdrwc{})
-	conn := jsonrpc2.NewConn(stream)
+	ln, err := listen(*listenOn)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	logger.Info("listening", zap.String("address", *listenOn))
+	// Clients are served concurrently, each with a session of its own, but
+	// share the loader cache so that they don't load the standard library
+	// and module dependencies again.
+	config.Cache = loader.NewCache()
+	for session := 1; ; session++ {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		config := config
+		config.Logger = logger.With(zap.Int("session", session))
+		config.Logger.Info("client connected")
+		go func() {
+			if err := serve(ctx, c, config); err != nil && !errors.Is(err, io.EOF) {
+				config.Logger.Warn("client disconnected", zap.Error(err))
+			} else {
+				config.Logger.Info("client disconnected")
+			}
+		}()
+	}
+}
 
-	config := lsp.Config{
-		Lint:    *lint,
-		Version: version,
-		Conn:    conn,
+func runVersion(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Parse(args)
+	fmt.Println("gunkls", version)
+	return nil
+}
+
+// newLogger returns a logger writing to path, or to stderr if it is empty.
+// Debug messages are only logged if verbose.
+func newLogger(path string, verbose bool) (*zap.Logger, error) {
+	w := zapcore.Lock(os.Stderr)
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open log file: %w", err)
+		}
+		w = zapcore.Lock(f)
+	}
+	level := zap.InfoLevel
+	if verbose {
+		level = zap.DebugLevel
 	}
-	server := jsonrpc2.HandlerServer(lsp.NewLSPServer(config).Handle)
+	encoder := zap.NewDevelopmentEncoderConfig()
+	encoder.EncodeLevel = zapcore.CapitalLevelEncoder
+	core := zapcore.NewCore(zapcore.NewConsoleEncoder(encoder), w, level)
+	return zap.New(core).Named("gunkls"), nil
+}
+
+// serve runs a language server session on a client connection, until it is
+// closed. Sessions with the same cache share it.
+func serve(ctx context.Context, rwc io.ReadWriteCloser, config lsp.Config) error {
+	stream := jsonrpc2.NewStream(rwc)
+	conn := jsonrpc2.NewConn(stream)
+
+	config.Conn = conn
+	l := lsp.NewLSPServer(config)
+	defer l.Close()
+	server := jsonrpc2.HandlerServer(l.Handle)
 	return server.ServeStream(ctx, conn)
 }
 
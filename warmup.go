@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gunk/gunkls/lsp"
+)
+
+// runWarmup type-checks the packages imported by the packages given as
+// arguments, so that the caches of the go command are filled before the
+// server is started, such as when building a development image.
+func runWarmup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("warmup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gunkls warmup [packages]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	checked, err := lsp.Warmup(ctx, dir, patterns)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("warmed up %d packages in %v\n", checked, time.Since(start).Round(time.Millisecond))
+	return nil
+}